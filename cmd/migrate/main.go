@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/bbr/telestory-api-based/internal/config"
+	"github.com/bbr/telestory-api-based/internal/datasources"
+	"github.com/bbr/telestory-api-based/internal/migrate"
+	"github.com/bbr/telestory-api-based/migrations"
+	_ "github.com/lib/pq"
+)
+
+const usage = `usage: migrate [-env local|prod] [-dir migrations] <command>
+
+commands:
+  up             apply all pending migrations
+  down [N]       revert the last N applied migrations (default 1)
+  status         list migrations and whether they are applied
+  redo           revert and re-apply the last applied migration
+  create <name>  write empty up/down files for a new migration into -dir
+`
+
+func main() {
+	envFlag := flag.String("env", "local", "environment to load (local, prod)")
+	dirFlag := flag.String("dir", "migrations", "migrations directory used by create")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// create only touches the source tree, no database needed
+	if args[0] == "create" {
+		if len(args) != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		up, down, err := migrate.Create(*dirFlag, args[1])
+		if err != nil {
+			log.Fatalf("Failed to create migration: %v", err)
+		}
+		fmt.Printf("Created %s\nCreated %s\n", up, down)
+		return
+	}
+
+	cfg, err := config.Load(*envFlag, "DATABASE_URL")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := datasources.NewPostgresConnection(cfg.DatabaseURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	migrator, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		log.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			log.Fatalf("Failed to run migrations: %v", err)
+		}
+		fmt.Printf("Applied %d migration(s)\n", applied)
+
+	case "down":
+		n := 1
+		if len(args) > 1 {
+			n, err = strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				log.Fatalf("Invalid migration count %q", args[1])
+			}
+		}
+		reverted, err := migrator.Down(ctx, n)
+		if err != nil {
+			log.Fatalf("Failed to revert migrations: %v", err)
+		}
+		fmt.Printf("Reverted %d migration(s)\n", reverted)
+
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			log.Fatalf("Failed to read migration status: %v", err)
+		}
+		for _, st := range statuses {
+			state := "pending"
+			if st.Applied {
+				state = "applied " + st.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if st.Modified {
+				state += " (modified since applied)"
+			}
+			fmt.Printf("%03d_%-30s %s\n", st.Version, st.Name, state)
+		}
+
+	case "redo":
+		mig, err := migrator.Redo(ctx)
+		if err != nil {
+			log.Fatalf("Failed to redo migration: %v", err)
+		}
+		fmt.Printf("Redid %03d_%s\n", mig.Version, mig.Name)
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
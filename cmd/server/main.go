@@ -1,19 +1,27 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"flag"
-	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"syscall"
+	"time"
+	_ "time/tzdata" // user timezones must resolve even without system zoneinfo
 
+	"github.com/bbr/telestory-api-based/internal/callback"
+	"github.com/bbr/telestory-api-based/internal/config"
 	"github.com/bbr/telestory-api-based/internal/controllers"
 	"github.com/bbr/telestory-api-based/internal/datasources"
+	"github.com/bbr/telestory-api-based/internal/logging"
+	"github.com/bbr/telestory-api-based/internal/metrics"
+	"github.com/bbr/telestory-api-based/internal/migrate"
 	"github.com/bbr/telestory-api-based/internal/repositories"
 	"github.com/bbr/telestory-api-based/internal/services"
-	"github.com/joho/godotenv"
+	"github.com/bbr/telestory-api-based/migrations"
 	_ "github.com/lib/pq"
 )
 
@@ -22,143 +30,219 @@ func main() {
 	envFlag := flag.String("env", "local", "environment to run in (local, prod)")
 	flag.Parse()
 
-	// Load environment variables
-	loadEnv(*envFlag)
+	// Load and validate configuration
+	cfg, err := config.Load(*envFlag, config.ServerRequired...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logging.Setup(cfg.LogLevel, cfg.LogFormat)
+	slog.Info("Starting server", "app_env", cfg.AppEnv, "config", cfg.Summary())
 
 	// Initialize Datasources
 	// Initialize database
-	db, err := datasources.NewPostgresConnection()
+	db, err := datasources.NewPostgresConnection(cfg.DatabaseURL)
 	if err != nil {
-		log.Fatal(err)
+		fatal("Failed to connect to database", err)
 	}
 	defer db.Close()
-	log.Println("Database connection established")
+	slog.Info("Database connection established")
 
 	// Run migrations automatically
-	if err := runMigrations(db); err != nil {
-		log.Fatalf("Failed to run migrations: %v", err)
+	migrator, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		fatal("Failed to load migrations", err)
 	}
+	applied, err := migrator.Up(context.Background())
+	if err != nil {
+		fatal("Failed to run migrations", err)
+	}
+	slog.Info("Migrations up to date", "applied", applied)
 
-	bot, err := datasources.NewTelegramBot()
+	updatesHeartbeat := &datasources.UpdatesHeartbeat{}
+	bot, err := datasources.NewTelegramBot(cfg.TelegramBotToken, datasources.NewPoller(cfg), updatesHeartbeat)
 	if err != nil {
-		log.Fatal(err)
+		fatal("Failed to initialize Telegram bot", err)
 	}
-	log.Println("Telegram Bot initialized")
+	slog.Info("Telegram bot initialized")
+
+	// Callback payloads are signed with CALLBACK_SECRET, falling back to the bot token
+	callbackSigner := callback.NewSigner(cfg.CallbackSecret)
 
 	// Initialize Repositories
 	userRepo := repositories.NewUserRepository(db)
 	downloadRepo := repositories.NewDownloadRepository(db)
+	archiveRepo := repositories.NewArchiveRepository(db)
+	chatRepo := repositories.NewChatRepository(db)
+	runtimeSettingRepo := repositories.NewRuntimeSettingRepository(db)
 
 	// Initialize Services
-	userService := services.NewUserService(userRepo, downloadRepo)
-	downloadService := services.NewDownloadService(downloadRepo)
-	logService := services.NewLogService(bot)
-	analyticsService := services.NewAnalyticsService(userRepo, downloadRepo)
+	// Operator overrides of the configuration, kept in sync across instances
+	runtimeSettings := services.NewRuntimeSettings(runtimeSettingRepo, cfg)
+	if err := runtimeSettings.Listen(cfg.DatabaseURL); err != nil {
+		fatal("Failed to load runtime settings", err)
+	}
+	defer runtimeSettings.Close()
+
+	userService := services.NewUserService(userRepo, downloadRepo, runtimeSettings)
+	storyProvider := services.NewTeleStoryProvider(&http.Client{}, cfg.TeleStoryAPIURL, cfg.TeleStoryAPIKey)
+	downloadService := services.NewDownloadService(downloadRepo, archiveRepo, userService, storyProvider, callbackSigner, runtimeSettings)
+	logService := services.NewLogService(bot, cfg.LogChannelID, cfg.LogRoutes)
+	logService.Start()
+	alertService := services.NewAlertService(logService, runtimeSettings)
+	if cfg.ClusterMode {
+		alertService.InstanceID = cfg.InstanceID
+	}
+	analyticsService := services.NewAnalyticsService(repositories.NewAnalyticsRepository(db))
+	exportService := services.NewExportService(repositories.NewExportRepository(db))
+	chatService := services.NewChatService(chatRepo)
+	adminKeyService := services.NewAdminKeyService(repositories.NewAdminKeyRepository(db))
+	broadcastService := services.NewBroadcastService(repositories.NewBroadcastRepository(db), userRepo, bot)
 
 	// Initialize Controllers
-	httpCtrl := controllers.NewHTTPController()
-	teleCtrl := controllers.NewTelegramController(bot, userService, downloadService, logService, analyticsService)
+	healthService := services.NewHealthService(db, migrator, updatesHeartbeat, storyProvider.Breaker)
+	healthService.Polling = cfg.BotMode != config.BotModeWebhook
+	httpCtrl := controllers.NewHTTPController(healthService, analyticsService, exportService, adminKeyService, userService, downloadService, runtimeSettings, broadcastService)
+	callbackRouter := controllers.NewCallbackRouter(callbackSigner)
+	teleCtrl := controllers.NewTelegramController(bot, runtimeSettings, callbackRouter, userService, downloadService, logService, analyticsService, exportService, adminKeyService, chatService)
 
 	// Setup Handlers
 	httpCtrl.SetupRoutes()
 	teleCtrl.SetupHandlers()
 
-	// Start Bot in Goroutine
-	go bot.Start()
-	log.Println("Telegram Bot started")
-
-	// Start HTTP Server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-	log.Printf("Server starting on port %s...", port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	// In cluster mode only the elected leader takes updates; deliveries go through the shared queue
+	var leader *services.Leader
+	if cfg.ClusterMode {
+		leader = services.NewLeader(db, cfg.InstanceID)
+		downloadService.Queue = repositories.NewDeliveryJobRepository(db)
+		downloadService.InstanceID = cfg.InstanceID
+		healthService.TakesUpdates = leader.IsLeader
 	}
-}
-
-// runMigrations automatically runs all SQL migrations in the migrations folder
-func runMigrations(db *sql.DB) error {
-	log.Println("Running database migrations...")
 
-	// Get migrations directory
-	migrationsDir := "migrations"
-
-	// Read all migration files
-	files, err := filepath.Glob(filepath.Join(migrationsDir, "*.sql"))
-	if err != nil {
-		return fmt.Errorf("failed to read migrations: %v", err)
+	// Gauges are read on each scrape
+	metrics.NewGaugeFunc("telestory_active_users", "Users active in the last 24 hours.", func() (float64, error) {
+		count, err := userRepo.CountActiveUsers(context.Background(), 1)
+		return float64(count), err
+	})
+	metrics.NewGaugeFunc("telestory_deliveries_in_progress", "Story deliveries running on this instance.", func() (float64, error) {
+		return float64(downloadService.InFlight()), nil
+	})
+	if queue := downloadService.Queue; queue != nil {
+		metrics.NewGaugeFunc("telestory_delivery_queue_depth", "Delivery jobs waiting for a worker.", func() (float64, error) {
+			count, err := queue.CountQueued(context.Background())
+			return float64(count), err
+		})
 	}
 
-	if len(files) == 0 {
-		log.Println("No migration files found")
-		return nil
-	}
-
-	// Execute each migration file
-	for _, file := range files {
-		log.Printf("Applying migration: %s", filepath.Base(file))
-
-		// Read migration file
-		content, err := os.ReadFile(file)
-		if err != nil {
-			return fmt.Errorf("failed to read migration %s: %v", file, err)
+	if cfg.BotMode == config.BotModeWebhook {
+		webhookCtrl := controllers.NewWebhookController(bot, cfg.WebhookSecret, updatesHeartbeat)
+		if leader != nil {
+			webhookCtrl.Active = leader.IsLeader
 		}
-
-		// Execute migration
-		if _, err := db.Exec(string(content)); err != nil {
-			// Ignore "already exists" errors
-			if !isAlreadyExistsError(err) {
-				return fmt.Errorf("failed to apply migration %s: %v", file, err)
-			}
-			log.Printf("Migration %s already applied, skipping", filepath.Base(file))
-		} else {
-			log.Printf("Migration %s applied successfully", filepath.Base(file))
+		httpCtrl.Handle(cfg.WebhookPath, webhookCtrl)
+	} else if info, err := bot.Webhook(); err == nil && info.Listen != "" {
+		// getUpdates is refused while a webhook is set
+		slog.Info("Removing webhook to use long polling", "webhook", info.Listen)
+		if err := bot.RemoveWebhook(); err != nil {
+			fatal("Failed to remove webhook", err)
 		}
 	}
 
-	log.Println("All migrations completed successfully")
-	return nil
-}
-
-// isAlreadyExistsError checks if the error is due to table/column already existing
-func isAlreadyExistsError(err error) bool {
-	if err == nil {
-		return false
+	// Start Bot in Goroutine; in webhook mode this registers the webhook
+	clusterCtx, stopCluster := context.WithCancel(context.Background())
+	defer stopCluster()
+	leaderDone := make(chan struct{})
+	if leader != nil {
+		leader.RunExclusive("telegram-updates", func(ctx context.Context) {
+			started := make(chan struct{})
+			go func() {
+				bot.Start()
+				close(started)
+			}()
+			<-ctx.Done()
+			bot.Stop()
+			<-started
+		})
+		leader.RunExclusive("delivery-sweeper", func(ctx context.Context) {
+			downloadService.SweepJobs(ctx, bot)
+		})
+		leader.RunExclusive("stats-rollup", analyticsService.RunRollups)
+		leader.RunExclusive("broadcast-sweeper", broadcastService.SweepStale)
+		go func() {
+			leader.Run(clusterCtx)
+			close(leaderDone)
+		}()
+		go downloadService.RunWorkers(clusterCtx, bot, cfg.WorkerConcurrency)
+		slog.Info("Cluster mode: campaigning for leadership", "instance_id", cfg.InstanceID, "bot_mode", cfg.BotMode)
+	} else {
+		go bot.Start()
+		slog.Info("Telegram bot started", "bot_mode", cfg.BotMode)
 	}
-	errMsg := err.Error()
-	return contains(errMsg, "already exists") ||
-		contains(errMsg, "duplicate") ||
-		contains(errMsg, "42P07") // PostgreSQL duplicate table error code
-}
 
-func contains(s, substr string) bool {
-	return len(s) >= len(substr) && (s == substr || len(s) > len(substr) &&
-		(s[:len(substr)] == substr || s[len(s)-len(substr):] == substr ||
-			findSubstring(s, substr)))
-}
+	// Watch failure rates and alert the admin log channel; outside cluster mode also roll up daily stats
+	// and clean up broadcasts left by a crash
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	go alertService.Run(jobsCtx)
+	if leader == nil {
+		go analyticsService.RunRollups(jobsCtx)
+		go broadcastService.SweepStale(jobsCtx)
+	}
 
-func findSubstring(s, substr string) bool {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return true
+	// Start HTTP Server
+	server := &http.Server{Addr: ":" + cfg.Port, Handler: httpCtrl.Handler()}
+	go func() {
+		slog.Info("HTTP server starting", "port", cfg.Port)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fatal("Failed to start HTTP server", err)
+		}
+	}()
+
+	// Wait for SIGINT/SIGTERM (deploy.sh uses pkill)
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-stop
+	slog.Info("Shutting down", "signal", sig.String())
+
+	// Stop taking updates first so no new work arrives
+	if leader != nil {
+		// Steps down (stopping the bot) and stops claiming queued deliveries
+		stopCluster()
+		<-leaderDone
+	} else {
+		bot.Stop()
+	}
+	slog.Info("Telegram bot stopped")
+	// In cluster mode the next leader registers its own webhook, removing it could race with that
+	if cfg.BotMode == config.BotModeWebhook && cfg.WebhookUnregister && leader == nil {
+		if err := bot.RemoveWebhook(); err != nil {
+			slog.Error("Failed to remove webhook", "error", err)
 		}
 	}
-	return false
-}
 
-func loadEnv(envName string) {
-	envFile := ".env." + envName
-	err := godotenv.Load(envFile)
-	if err != nil {
-		log.Printf("Warning: Could not load %s, falling back to .env or system vars: %v", envFile, err)
-		godotenv.Load()
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if interrupted := downloadService.Shutdown(ctx, bot); interrupted > 0 {
+		slog.Warn("Interrupted delivery jobs", "count", interrupted)
 	}
 
-	env := os.Getenv("APP_ENV")
-	if env == "" {
-		env = "development"
+	stopJobs()
+	// The admin API starts broadcasts, so it stops taking requests before they are closed
+	httpCtx, httpCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer httpCancel()
+	if err := server.Shutdown(httpCtx); err != nil {
+		slog.Error("HTTP server shutdown failed", "error", err)
+	}
+	broadcastService.Close()
+	logCtx, logCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer logCancel()
+	if err := logService.Close(logCtx); err != nil {
+		slog.Warn("Admin log channel shutdown", "error", err)
 	}
-	log.Printf("Starting server in %s mode (loaded %s)", env, envFile)
+	slog.Info("Shutdown complete")
+}
+
+// fatal logs err at error level and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
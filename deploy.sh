@@ -8,19 +8,49 @@ VPS_DIR="/root/telegram-bots-golang/telestory-api-based"
 BINARY_NAME="application"
 # ─────────────────────────────────────────────────────────────────────────────
 
+# to_seconds converts a Go duration such as 30s, 2m or 1m30s; anything else falls back to 30
+to_seconds() {
+  local d=$1 total=0 n
+  while [[ $d =~ ^([0-9]+)(h|m|s)(.*)$ ]]; do
+    n=${BASH_REMATCH[1]}
+    case ${BASH_REMATCH[2]} in
+      h) total=$((total + n * 3600)) ;;
+      m) total=$((total + n * 60)) ;;
+      s) total=$((total + n)) ;;
+    esac
+    d=${BASH_REMATCH[3]}
+  done
+  if [[ -z $d && $total -gt 0 ]]; then echo "$total"; else echo 30; fi
+}
+
+# Worst case shutdown: SHUTDOWN_TIMEOUT for running downloads, then 5s cleanup grace,
+# 10s admin log flush and 5s HTTP drain, plus a margin
+SHUTDOWN_TIMEOUT=$(grep -E '^SHUTDOWN_TIMEOUT=' .env.prod | tail -n 1 | cut -d= -f2- | tr -d "\"' ")
+STOP_WAIT=$(( $(to_seconds "${SHUTDOWN_TIMEOUT:-30s}") + 5 + 10 + 5 + 10 ))
+
 echo "▶ Building for Linux..."
-GOOS=linux GOARCH=amd64 go build -o "$BINARY_NAME" ./cmd/server/main.go
+GOOS=linux GOARCH=amd64 go build -o "$BINARY_NAME" ./cmd/server
+GOOS=linux GOARCH=amd64 go build -o migrate ./cmd/migrate
 
 echo "▶ Copying files to VPS..."
-ssh "$VPS_USER@$VPS_HOST" "mkdir -p $VPS_DIR/migrations"
 scp "$BINARY_NAME"        "$VPS_USER@$VPS_HOST:$VPS_DIR/"
 scp ".env.prod"           "$VPS_USER@$VPS_HOST:$VPS_DIR/"
-scp migrations/*.sql      "$VPS_USER@$VPS_HOST:$VPS_DIR/migrations/"
+scp migrate               "$VPS_USER@$VPS_HOST:$VPS_DIR/"
 
 echo "▶ Restarting server..."
 ssh "$VPS_USER@$VPS_HOST" bash <<EOF
   cd $VPS_DIR
   pkill -f "$BINARY_NAME" || true
+  # The server drains running downloads on SIGTERM; two instances polling at once get 409 Conflict
+  for i in \$(seq 1 $STOP_WAIT); do
+    pgrep -f "$BINARY_NAME" > /dev/null || break
+    sleep 1
+  done
+  if pgrep -f "$BINARY_NAME" > /dev/null; then
+    echo "Server still running after ${STOP_WAIT}s, killing it"
+    pkill -9 -f "$BINARY_NAME" || true
+    sleep 1
+  fi
   nohup ./$BINARY_NAME -env prod > server.log 2>&1 &
   echo "Server started (PID \$!)"
 EOF
@@ -0,0 +1,128 @@
+package callback
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Version is the current payload envelope version.
+// Envelope format: "<version>.<issued at, base36>.<signature>.<field|field|...>"
+const Version = "1"
+
+// maxCallbackData is Telegram's limit for callback_data in bytes
+const maxCallbackData = 64
+
+var (
+	ErrUnsigned     = errors.New("callback payload is not signed")
+	ErrVersion      = errors.New("unsupported callback payload version")
+	ErrBadSignature = errors.New("invalid callback payload signature")
+	ErrExpired      = errors.New("callback payload expired")
+	ErrTooLong      = errors.New("callback data exceeds Telegram's 64 byte limit")
+)
+
+// Payload is a typed inline button payload.
+// Fields must not contain the "|" separator.
+type Payload interface {
+	Fields() []string
+	Parse(fields []string) error
+}
+
+// Signer encodes and verifies signed callback payloads
+type Signer struct {
+	key []byte
+	now func() time.Time
+}
+
+func NewSigner(secret string) *Signer {
+	key := sha256.Sum256([]byte(secret))
+	return &Signer{key: key[:], now: time.Now}
+}
+
+// Button builds an inline button whose data is the signed payload
+func (s *Signer) Button(text, unique string, p Payload) (tele.Btn, error) {
+	data, err := s.Encode(unique, p)
+	if err != nil {
+		return tele.Btn{}, err
+	}
+	return tele.Btn{Text: text, Unique: unique, Data: data}, nil
+}
+
+// Encode returns the signed envelope for p on the given route, or ErrTooLong when
+// Telegram would reject the button
+func (s *Signer) Encode(unique string, p Payload) (string, error) {
+	body := strings.Join(p.Fields(), "|")
+	issued := strconv.FormatInt(s.now().Unix(), 36)
+	data := Version + "." + issued + "." + s.sign(unique, issued, body) + "." + body
+
+	if n := len("\f"+unique+"|") + len(data); n > maxCallbackData {
+		return "", fmt.Errorf("%w: %s payload is %d bytes", ErrTooLong, unique, n)
+	}
+	return data, nil
+}
+
+// Keyboard builds the buttons of one menu and keeps the first encoding error,
+// so menus can be laid out inline and checked once with Err
+type Keyboard struct {
+	signer *Signer
+	err    error
+}
+
+func (s *Signer) Keyboard() *Keyboard {
+	return &Keyboard{signer: s}
+}
+
+// Button is Signer.Button; after an error it returns an empty button
+func (k *Keyboard) Button(text, unique string, p Payload) tele.Btn {
+	btn, err := k.signer.Button(text, unique, p)
+	if err != nil && k.err == nil {
+		k.err = err
+	}
+	return btn
+}
+
+// Err returns the first error of the buttons built so far
+func (k *Keyboard) Err() error {
+	return k.err
+}
+
+// Decode verifies data for the given route and parses it into p.
+// A maxAge of zero disables the expiry check.
+func (s *Signer) Decode(unique, data string, maxAge time.Duration, p Payload) error {
+	parts := strings.SplitN(data, ".", 4)
+	if len(parts) != 4 {
+		return ErrUnsigned
+	}
+	version, issued, sig, body := parts[0], parts[1], parts[2], parts[3]
+
+	if version != Version {
+		return ErrVersion
+	}
+	if !hmac.Equal([]byte(sig), []byte(s.sign(unique, issued, body))) {
+		return ErrBadSignature
+	}
+
+	issuedAt, err := strconv.ParseInt(issued, 36, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+	if maxAge > 0 && s.now().Sub(time.Unix(issuedAt, 0)) > maxAge {
+		return ErrExpired
+	}
+
+	return p.Parse(strings.Split(body, "|"))
+}
+
+// sign returns a truncated HMAC over the route, issue time and body
+func (s *Signer) sign(unique, issued, body string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(Version + "\x00" + unique + "\x00" + issued + "\x00" + body))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:8])
+}
@@ -0,0 +1,88 @@
+package callback
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testPayload struct {
+	Values []string
+}
+
+func (p *testPayload) Fields() []string { return p.Values }
+
+func (p *testPayload) Parse(fields []string) error {
+	if len(fields) == 0 {
+		return fmt.Errorf("test payload: no fields")
+	}
+	p.Values = fields
+	return nil
+}
+
+func TestEncodeDecodeRoundtrip(t *testing.T) {
+	s := NewSigner("secret")
+	data, err := s.Encode("route", &testPayload{Values: []string{"a", "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got testPayload
+	if err := s.Decode("route", data, time.Minute, &got); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got.Values, "|") != "a|b" {
+		t.Fatalf("decoded %v, want [a b]", got.Values)
+	}
+
+	if err := s.Decode("other", data, 0, &got); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("decode on another route: %v, want ErrBadSignature", err)
+	}
+	if err := NewSigner("other").Decode("route", data, 0, &got); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("decode with another secret: %v, want ErrBadSignature", err)
+	}
+}
+
+func TestDecodeExpired(t *testing.T) {
+	s := NewSigner("secret")
+	data, err := s.Encode("route", &testPayload{Values: []string{"a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return time.Now().Add(time.Hour) }
+
+	if err := s.Decode("route", data, time.Minute, &testPayload{}); !errors.Is(err, ErrExpired) {
+		t.Fatalf("got %v, want ErrExpired", err)
+	}
+	if err := s.Decode("route", data, 0, &testPayload{}); err != nil {
+		t.Fatalf("zero max age: %v", err)
+	}
+}
+
+func TestEncodeTooLong(t *testing.T) {
+	s := NewSigner("secret")
+	if _, err := s.Encode("route", &testPayload{Values: []string{strings.Repeat("x", 64)}}); !errors.Is(err, ErrTooLong) {
+		t.Fatalf("got %v, want ErrTooLong", err)
+	}
+	if _, err := s.Button("text", "route", &testPayload{Values: []string{strings.Repeat("x", 64)}}); !errors.Is(err, ErrTooLong) {
+		t.Fatalf("button: got %v, want ErrTooLong", err)
+	}
+}
+
+func TestKeyboardKeepsFirstError(t *testing.T) {
+	kb := NewSigner("secret").Keyboard()
+	if btn := kb.Button("ok", "route", &testPayload{Values: []string{"a"}}); btn.Data == "" {
+		t.Fatal("valid button has no data")
+	}
+	if kb.Err() != nil {
+		t.Fatalf("unexpected error %v", kb.Err())
+	}
+
+	kb.Button("long", "first", &testPayload{Values: []string{strings.Repeat("x", 64)}})
+	kb.Button("long", "second", &testPayload{Values: []string{strings.Repeat("x", 64)}})
+	if err := kb.Err(); !errors.Is(err, ErrTooLong) || !strings.Contains(err.Error(), "first") {
+		t.Fatalf("got %v, want the ErrTooLong of the first button", err)
+	}
+}
@@ -0,0 +1,183 @@
+// Package charts renders simple PNG charts with the standard library only.
+// Text is limited to the digits and symbols of axis labels; titles belong in the photo caption.
+package charts
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"strconv"
+)
+
+const (
+	width  = 800
+	height = 400
+
+	marginLeft   = 70
+	marginRight  = 20
+	marginTop    = 20
+	marginBottom = 40
+
+	gridLines = 4
+	maxLabels = 8 // x axis labels, the rest are skipped
+)
+
+var (
+	background = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	gridColor  = color.RGBA{0xe0, 0xe0, 0xe0, 0xff}
+	axisColor  = color.RGBA{0x60, 0x60, 0x60, 0xff}
+	textColor  = color.RGBA{0x30, 0x30, 0x30, 0xff}
+)
+
+// BarChart is a bar per value on a y axis starting at zero
+type BarChart struct {
+	Values []float64 // NaN leaves a gap
+	Labels []string  // x axis labels, one per value
+	Color  color.RGBA
+	Max    float64 // fixed top of the y axis, zero to scale to the data
+	Suffix string  // appended to y axis labels, e.g. "%"
+}
+
+// PNG renders the chart
+func (c BarChart) PNG() ([]byte, error) {
+	if len(c.Values) == 0 {
+		return nil, fmt.Errorf("bar chart has no values")
+	}
+	if len(c.Labels) != len(c.Values) {
+		return nil, fmt.Errorf("bar chart has %d labels for %d values", len(c.Labels), len(c.Values))
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	fill(img, img.Bounds(), background)
+
+	plot := image.Rect(marginLeft, marginTop, width-marginRight, height-marginBottom)
+	top, step := c.scale()
+
+	// Horizontal grid with y labels
+	for i := 0; i <= gridLines; i++ {
+		v := step * float64(i)
+		if v > top {
+			break
+		}
+		y := plot.Max.Y - int(math.Round(v/top*float64(plot.Dy())))
+		fill(img, image.Rect(plot.Min.X, y, plot.Max.X, y+1), gridColor)
+		label := strconv.FormatFloat(v, 'f', -1, 64) + c.Suffix
+		drawText(img, plot.Min.X-8-textWidth(label), y-glyphHeight*textScale/2, label)
+	}
+
+	// Bars and every n-th x label
+	slot := float64(plot.Dx()) / float64(len(c.Values))
+	barWidth := max(1, int(slot*0.7))
+	every := (len(c.Values) + maxLabels - 1) / maxLabels
+	for i, v := range c.Values {
+		center := plot.Min.X + int(slot*(float64(i)+0.5))
+		if !math.IsNaN(v) && v > 0 {
+			h := int(math.Round(math.Min(v, top) / top * float64(plot.Dy())))
+			fill(img, image.Rect(center-barWidth/2, plot.Max.Y-h, center-barWidth/2+barWidth, plot.Max.Y), c.Color)
+		}
+		if i%every == 0 {
+			label := c.Labels[i]
+			drawText(img, center-textWidth(label)/2, plot.Max.Y+10, label)
+		}
+	}
+
+	// Axes
+	fill(img, image.Rect(plot.Min.X, plot.Max.Y, plot.Max.X, plot.Max.Y+2), axisColor)
+	fill(img, image.Rect(plot.Min.X-2, plot.Min.Y, plot.Min.X, plot.Max.Y+2), axisColor)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode chart: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// scale returns the top of the y axis and the grid step
+func (c BarChart) scale() (top, step float64) {
+	if c.Max > 0 {
+		return c.Max, c.Max / gridLines
+	}
+
+	highest := 0.0
+	for _, v := range c.Values {
+		if !math.IsNaN(v) {
+			highest = math.Max(highest, v)
+		}
+	}
+	step = niceStep(highest / gridLines)
+	return step * math.Max(1, math.Ceil(highest/step)), step
+}
+
+// niceStep rounds raw up to 1, 2 or 5 times a power of ten, at least 1 since the values are counts
+func niceStep(raw float64) float64 {
+	if raw <= 1 {
+		return 1
+	}
+	exp := math.Pow(10, math.Floor(math.Log10(raw)))
+	switch f := raw / exp; {
+	case f <= 1:
+		return exp
+	case f <= 2:
+		return 2 * exp
+	case f <= 5:
+		return 5 * exp
+	default:
+		return 10 * exp
+	}
+}
+
+func fill(img *image.RGBA, r image.Rectangle, c color.Color) {
+	draw.Draw(img, r, &image.Uniform{C: c}, image.Point{}, draw.Src)
+}
+
+// A tiny 3x5 bitmap font, drawn at textScale
+const (
+	glyphWidth  = 3
+	glyphHeight = 5
+	textScale   = 2
+	advance     = (glyphWidth + 1) * textScale
+)
+
+var glyphs = map[rune][glyphHeight]string{
+	'0': {"111", "101", "101", "101", "111"},
+	'1': {"010", "110", "010", "010", "111"},
+	'2': {"111", "001", "111", "100", "111"},
+	'3': {"111", "001", "111", "001", "111"},
+	'4': {"101", "101", "111", "001", "001"},
+	'5': {"111", "100", "111", "001", "111"},
+	'6': {"111", "100", "111", "101", "111"},
+	'7': {"111", "001", "010", "010", "010"},
+	'8': {"111", "101", "111", "101", "111"},
+	'9': {"111", "101", "111", "001", "111"},
+	'-': {"000", "000", "111", "000", "000"},
+	'.': {"000", "000", "000", "000", "010"},
+	'%': {"101", "001", "010", "100", "101"},
+	'/': {"001", "001", "010", "100", "100"},
+}
+
+func textWidth(s string) int {
+	n := len([]rune(s))
+	if n == 0 {
+		return 0
+	}
+	return n*advance - textScale
+}
+
+// drawText draws s with its top left corner at x, y; runes without a glyph are left blank
+func drawText(img *image.RGBA, x, y int, s string) {
+	for _, r := range s {
+		for row, bits := range glyphs[r] {
+			for col, bit := range bits {
+				if bit == '1' {
+					px, py := x+col*textScale, y+row*textScale
+					fill(img, image.Rect(px, py, px+textScale, py+textScale), textColor)
+				}
+			}
+		}
+		x += advance
+	}
+}
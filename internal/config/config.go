@@ -0,0 +1,494 @@
+// Package config loads and validates the application settings once at startup.
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// ServerRequired lists the settings the bot server cannot start without
+var ServerRequired = []string{
+	"DATABASE_URL",
+	"TELEGRAM_BOT_TOKEN",
+	"TELESTORY_API_KEY",
+	"TELESTORY_API_URL",
+	"ARCHIVE_CHANNEL_ID",
+}
+
+// How the bot receives updates
+const (
+	BotModePolling = "polling"
+	BotModeWebhook = "webhook"
+)
+
+// LogCategories are the admin log event categories that LOG_ROUTES can route
+var LogCategories = []string{"new_user", "search", "failure", "payment", "admin", "alert"}
+
+// webhookSecretRx is the character set Telegram allows in X-Telegram-Bot-Api-Secret-Token
+var webhookSecretRx = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// Config holds every setting of the application
+type Config struct {
+	Name   string // environment file that was loaded, e.g. "local" for .env.local
+	AppEnv string // "production" enables the strict download limits
+
+	Port             string
+	DatabaseURL      string
+	TelegramBotToken string
+	CallbackSecret   string // falls back to the bot token
+
+	TeleStoryAPIKey string
+	TeleStoryAPIURL string
+
+	ArchiveChannelID int64
+	LogChannelID     int64            // zero disables log messages
+	LogRoutes        map[string]int64 // category -> chat, overriding LogChannelID; zero disables the category
+
+	Maintenance      bool
+	DownloadCooldown time.Duration
+	DailyLimit       int
+
+	ShutdownTimeout time.Duration // how long running deliveries may finish after SIGTERM
+
+	// Error spike alerts; a signal alerts when at least AlertMinEvents happened in AlertWindow
+	// and AlertFailureRate percent of them failed
+	AlertFailureRate int
+	AlertMinEvents   int
+	AlertWindow      time.Duration
+
+	LogLevel  string // debug, info, warn or error
+	LogFormat string // json or text
+
+	// Webhook mode; updates are served on the HTTP server at WebhookPath
+	BotMode               string
+	WebhookPublicURL      string // public https base URL, e.g. behind a reverse proxy
+	WebhookPath           string
+	WebhookSecret         string
+	WebhookCert           string // optional self-signed certificate uploaded to Telegram
+	WebhookMaxConnections int
+	WebhookUnregister     bool // remove the webhook on shutdown; ignored in cluster mode
+
+	// Cluster mode: the leader takes updates, every instance runs queued deliveries
+	ClusterMode       bool
+	InstanceID        string
+	WorkerConcurrency int
+}
+
+// WebhookURL is the URL registered with Telegram
+func (c *Config) WebhookURL() string {
+	return c.WebhookPublicURL + c.WebhookPath
+}
+
+// IsProduction reports whether APP_ENV is "production"
+func (c *Config) IsProduction() bool {
+	return c.AppEnv == "production"
+}
+
+// Load reads .env.<name> (or .env), then the optional YAML file named by CONFIG_FILE
+// (default config.yaml). Environment variables win over the YAML file.
+// Every malformed or missing required value is reported in the returned error.
+func Load(name string, required ...string) (*Config, error) {
+	envFile := ".env." + name
+	if err := godotenv.Load(envFile); err != nil {
+		log.Printf("Warning: Could not load %s, falling back to .env or system vars: %v", envFile, err)
+		godotenv.Load()
+	}
+
+	yamlFile := os.Getenv("CONFIG_FILE")
+	yamlRequired := yamlFile != ""
+	if yamlFile == "" {
+		yamlFile = "config.yaml"
+	}
+	fileValues, err := readYAML(yamlFile)
+	if err != nil && (yamlRequired || !errors.Is(err, os.ErrNotExist)) {
+		return nil, err
+	}
+
+	p := &parser{file: fileValues}
+	cfg := &Config{
+		Name:             name,
+		AppEnv:           p.str("APP_ENV", "development"),
+		Port:             p.str("PORT", "8080"),
+		DatabaseURL:      p.str("DATABASE_URL", ""),
+		TelegramBotToken: p.str("TELEGRAM_BOT_TOKEN", ""),
+		CallbackSecret:   p.str("CALLBACK_SECRET", ""),
+		TeleStoryAPIKey:  p.str("TELESTORY_API_KEY", ""),
+		TeleStoryAPIURL:  strings.TrimRight(p.str("TELESTORY_API_URL", ""), "/"),
+		ArchiveChannelID: p.chatID("ARCHIVE_CHANNEL_ID"),
+		LogChannelID:     p.chatID("LOG_CHANNEL_ID"),
+		LogRoutes:        p.logRoutes("LOG_ROUTES"),
+		Maintenance:      p.boolean("MAINTENANCE", false),
+	}
+
+	// Limits default to the previous per-environment values
+	cooldown, dailyLimit := "10s", "100"
+	if cfg.IsProduction() {
+		cooldown, dailyLimit = "1m", "3"
+	}
+	cfg.DownloadCooldown = p.duration("DOWNLOAD_COOLDOWN", cooldown)
+	cfg.DailyLimit = p.positiveInt("DAILY_LIMIT", dailyLimit)
+	cfg.ShutdownTimeout = p.duration("SHUTDOWN_TIMEOUT", "30s")
+	cfg.AlertFailureRate = p.percent("ALERT_FAILURE_RATE", "50")
+	cfg.AlertMinEvents = p.positiveInt("ALERT_MIN_EVENTS", "10")
+	cfg.AlertWindow = p.duration("ALERT_WINDOW", "5m")
+	cfg.LogLevel = p.oneOf("LOG_LEVEL", "info", "debug", "info", "warn", "error")
+	cfg.LogFormat = p.oneOf("LOG_FORMAT", "json", "json", "text")
+
+	cfg.BotMode = p.str("BOT_MODE", BotModePolling)
+	cfg.WebhookPublicURL = strings.TrimRight(p.str("WEBHOOK_PUBLIC_URL", ""), "/")
+	cfg.WebhookPath = p.str("WEBHOOK_PATH", "/telegram/webhook")
+	cfg.WebhookSecret = p.str("WEBHOOK_SECRET", "")
+	cfg.WebhookCert = p.str("WEBHOOK_CERT", "")
+	cfg.WebhookMaxConnections = p.positiveInt("WEBHOOK_MAX_CONNECTIONS", "40")
+	cfg.WebhookUnregister = p.boolean("WEBHOOK_UNREGISTER", true)
+	p.webhook(cfg)
+
+	hostname, _ := os.Hostname()
+	cfg.ClusterMode = p.boolean("CLUSTER_MODE", false)
+	cfg.InstanceID = p.str("INSTANCE_ID", fmt.Sprintf("%s-%d", hostname, os.Getpid()))
+	cfg.WorkerConcurrency = p.positiveInt("WORKER_CONCURRENCY", "4")
+
+	if cfg.CallbackSecret == "" {
+		cfg.CallbackSecret = cfg.TelegramBotToken
+	}
+
+	p.port("PORT", cfg.Port)
+	p.url("TELESTORY_API_URL", cfg.TeleStoryAPIURL, "http", "https")
+	p.databaseURL("DATABASE_URL", cfg.DatabaseURL)
+
+	for _, key := range required {
+		if value, _ := p.lookup(key); value == "" {
+			p.fail(key, "is required")
+		}
+	}
+
+	if len(p.errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration:\n  %s", strings.Join(p.errs, "\n  "))
+	}
+	return cfg, nil
+}
+
+// Summary renders the configuration for the boot log with secrets redacted
+func (c *Config) Summary() string {
+	pairs := [][2]string{
+		{"env file", ".env." + c.Name},
+		{"APP_ENV", c.AppEnv},
+		{"PORT", c.Port},
+		{"DATABASE_URL", redactDatabaseURL(c.DatabaseURL)},
+		{"TELEGRAM_BOT_TOKEN", redact(c.TelegramBotToken)},
+		{"CALLBACK_SECRET", redact(c.CallbackSecret)},
+		{"TELESTORY_API_KEY", redact(c.TeleStoryAPIKey)},
+		{"TELESTORY_API_URL", c.TeleStoryAPIURL},
+		{"ARCHIVE_CHANNEL_ID", strconv.FormatInt(c.ArchiveChannelID, 10)},
+		{"LOG_CHANNEL_ID", strconv.FormatInt(c.LogChannelID, 10)},
+		{"LOG_ROUTES", formatLogRoutes(c.LogRoutes)},
+		{"MAINTENANCE", strconv.FormatBool(c.Maintenance)},
+		{"DOWNLOAD_COOLDOWN", c.DownloadCooldown.String()},
+		{"DAILY_LIMIT", strconv.Itoa(c.DailyLimit)},
+		{"SHUTDOWN_TIMEOUT", c.ShutdownTimeout.String()},
+		{"ALERT_FAILURE_RATE", strconv.Itoa(c.AlertFailureRate) + "%"},
+		{"ALERT_MIN_EVENTS", strconv.Itoa(c.AlertMinEvents)},
+		{"ALERT_WINDOW", c.AlertWindow.String()},
+		{"LOG_LEVEL", c.LogLevel},
+		{"LOG_FORMAT", c.LogFormat},
+		{"BOT_MODE", c.BotMode},
+		{"CLUSTER_MODE", strconv.FormatBool(c.ClusterMode)},
+	}
+	if c.ClusterMode {
+		pairs = append(pairs, [][2]string{
+			{"INSTANCE_ID", c.InstanceID},
+			{"WORKER_CONCURRENCY", strconv.Itoa(c.WorkerConcurrency)},
+		}...)
+	}
+	if c.BotMode == BotModeWebhook {
+		pairs = append(pairs, [][2]string{
+			{"WEBHOOK_URL", c.WebhookURL()},
+			{"WEBHOOK_SECRET", redact(c.WebhookSecret)},
+			{"WEBHOOK_CERT", c.WebhookCert},
+			{"WEBHOOK_MAX_CONNECTIONS", strconv.Itoa(c.WebhookMaxConnections)},
+			{"WEBHOOK_UNREGISTER", strconv.FormatBool(c.WebhookUnregister)},
+		}...)
+	}
+
+	lines := make([]string, len(pairs))
+	for i, kv := range pairs {
+		lines[i] = fmt.Sprintf("  %-19s %s", kv[0], kv[1])
+	}
+	return strings.Join(lines, "\n")
+}
+
+// redact keeps only the last four characters of long secrets
+func redact(secret string) string {
+	switch {
+	case secret == "":
+		return "(unset)"
+	case len(secret) < 12:
+		return "****"
+	default:
+		return "****" + secret[len(secret)-4:]
+	}
+}
+
+var dsnPasswordRx = regexp.MustCompile(`password=\S+`)
+
+func redactDatabaseURL(dsn string) string {
+	if dsn == "" {
+		return "(unset)"
+	}
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		return u.Redacted()
+	}
+	return dsnPasswordRx.ReplaceAllString(dsn, "password=xxxxx")
+}
+
+// parser reads values from the environment, then the YAML file, collecting errors
+type parser struct {
+	file map[string]string
+	errs []string
+}
+
+func (p *parser) lookup(key string) (string, bool) {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return strings.TrimSpace(value), true
+	}
+	value, ok := p.file[key]
+	return value, ok && value != ""
+}
+
+func (p *parser) fail(key, format string, args ...any) {
+	p.errs = append(p.errs, key+" "+fmt.Sprintf(format, args...))
+}
+
+func (p *parser) str(key, def string) string {
+	if value, ok := p.lookup(key); ok {
+		return value
+	}
+	return def
+}
+
+// chatID parses a Telegram chat ID; channels and supergroups are negative (-100...)
+func (p *parser) chatID(key string) int64 {
+	value, ok := p.lookup(key)
+	if !ok {
+		return 0
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id == 0 {
+		p.fail(key, "must be a numeric chat ID, got %q", value)
+		return 0
+	}
+	return id
+}
+
+func (p *parser) boolean(key string, def bool) bool {
+	value, ok := p.lookup(key)
+	if !ok {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		p.fail(key, "must be true or false, got %q", value)
+	}
+	return b
+}
+
+func (p *parser) duration(key, def string) time.Duration {
+	value := p.str(key, def)
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		p.fail(key, "must be a duration such as 30s or 2m, got %q", value)
+		return 0
+	}
+	return d
+}
+
+func (p *parser) positiveInt(key, def string) int {
+	value := p.str(key, def)
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		p.fail(key, "must be a positive integer, got %q", value)
+		return 0
+	}
+	return n
+}
+
+func (p *parser) percent(key, def string) int {
+	value := p.str(key, def)
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > 100 {
+		p.fail(key, "must be a percentage between 1 and 100, got %q", value)
+		return 0
+	}
+	return n
+}
+
+// logRoutes parses "category:chat_id" pairs separated by commas; "off" as the chat disables a category
+func (p *parser) logRoutes(key string) map[string]int64 {
+	routes := make(map[string]int64)
+	value, ok := p.lookup(key)
+	if !ok {
+		return routes
+	}
+	for _, pair := range strings.Split(value, ",") {
+		category, chat, found := strings.Cut(strings.TrimSpace(pair), ":")
+		if !found || !slices.Contains(LogCategories, category) {
+			p.fail(key, "must be category:chat_id pairs with categories %s, got %q", strings.Join(LogCategories, ", "), pair)
+			continue
+		}
+		if chat == "off" {
+			routes[category] = 0
+			continue
+		}
+		id, err := strconv.ParseInt(chat, 10, 64)
+		if err != nil || id == 0 {
+			p.fail(key, "has an invalid chat ID for %s: %q", category, chat)
+			continue
+		}
+		routes[category] = id
+	}
+	return routes
+}
+
+func formatLogRoutes(routes map[string]int64) string {
+	pairs := make([]string, 0, len(routes))
+	for _, category := range LogCategories {
+		if id, ok := routes[category]; ok {
+			chat := strconv.FormatInt(id, 10)
+			if id == 0 {
+				chat = "off"
+			}
+			pairs = append(pairs, category+":"+chat)
+		}
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (p *parser) oneOf(key, def string, allowed ...string) string {
+	value := strings.ToLower(p.str(key, def))
+	if !slices.Contains(allowed, value) {
+		p.fail(key, "must be one of %s, got %q", strings.Join(allowed, ", "), value)
+	}
+	return value
+}
+
+func (p *parser) port(key, value string) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > 65535 {
+		p.fail(key, "must be a port number, got %q", value)
+	}
+}
+
+func (p *parser) url(key, value string, schemes ...string) {
+	if value == "" {
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		p.fail(key, "must be an absolute URL, got %q", value)
+		return
+	}
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return
+		}
+	}
+	p.fail(key, "must use one of the schemes %s, got %q", strings.Join(schemes, ", "), u.Scheme)
+}
+
+// databaseURL accepts postgres:// URLs and lib/pq "key=value" connection strings
+func (p *parser) databaseURL(key, value string) {
+	if value == "" || !strings.Contains(value, "://") {
+		return
+	}
+	p.url(key, value, "postgres", "postgresql")
+}
+
+// webhook validates the webhook settings when BOT_MODE is webhook
+func (p *parser) webhook(cfg *Config) {
+	switch cfg.BotMode {
+	case BotModePolling:
+		return
+	case BotModeWebhook:
+	default:
+		p.fail("BOT_MODE", "must be %s or %s, got %q", BotModePolling, BotModeWebhook, cfg.BotMode)
+		return
+	}
+
+	if cfg.WebhookPublicURL == "" {
+		p.fail("WEBHOOK_PUBLIC_URL", "is required in webhook mode")
+	} else {
+		p.url("WEBHOOK_PUBLIC_URL", cfg.WebhookPublicURL, "https")
+	}
+	if !strings.HasPrefix(cfg.WebhookPath, "/") {
+		p.fail("WEBHOOK_PATH", "must start with /, got %q", cfg.WebhookPath)
+	}
+	if !webhookSecretRx.MatchString(cfg.WebhookSecret) {
+		p.fail("WEBHOOK_SECRET", "is required in webhook mode and may only contain A-Z, a-z, 0-9, _ and -")
+	}
+	if cfg.WebhookCert != "" {
+		if _, err := os.Stat(cfg.WebhookCert); err != nil {
+			p.fail("WEBHOOK_CERT", "cannot be read: %v", err)
+		}
+	}
+	if cfg.WebhookMaxConnections > 100 {
+		p.fail("WEBHOOK_MAX_CONNECTIONS", "must be between 1 and 100, got %d", cfg.WebhookMaxConnections)
+	}
+}
+
+// readYAML reads a flat "key: value" YAML file. Keys are matched case-insensitively
+// against the environment variable names; nested mappings and lists are not supported.
+func readYAML(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+		if line != strings.TrimLeft(line, " \t") || strings.HasPrefix(trimmed, "- ") {
+			return nil, fmt.Errorf("%s:%d: only top-level \"key: value\" pairs are supported", path, n)
+		}
+
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("%s:%d: expected \"key: value\"", path, n)
+		}
+		values[strings.ToUpper(strings.TrimSpace(key))] = yamlScalar(value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return values, nil
+}
+
+// yamlScalar unquotes a scalar and strips a trailing comment
+func yamlScalar(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') {
+		if end := strings.IndexByte(value[1:], value[0]); end >= 0 {
+			return value[1 : end+1]
+		}
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value)
+}
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// configKeys are the variables Load reads
+var configKeys = []string{
+	"CONFIG_FILE", "APP_ENV", "PORT", "DATABASE_URL", "TELEGRAM_BOT_TOKEN", "CALLBACK_SECRET",
+	"TELESTORY_API_KEY", "TELESTORY_API_URL", "ARCHIVE_CHANNEL_ID", "LOG_CHANNEL_ID", "LOG_ROUTES",
+	"MAINTENANCE", "DOWNLOAD_COOLDOWN", "DAILY_LIMIT", "SHUTDOWN_TIMEOUT", "ALERT_FAILURE_RATE",
+	"ALERT_MIN_EVENTS", "ALERT_WINDOW", "LOG_LEVEL", "LOG_FORMAT", "BOT_MODE", "WEBHOOK_PUBLIC_URL",
+	"WEBHOOK_PATH", "WEBHOOK_SECRET", "WEBHOOK_CERT", "WEBHOOK_MAX_CONNECTIONS", "WEBHOOK_UNREGISTER",
+	"CLUSTER_MODE", "INSTANCE_ID", "WORKER_CONCURRENCY",
+}
+
+// testDir runs the test in an empty directory with none of the config variables set.
+// Values Load copies from .env files into the environment are undone when the test ends.
+func testDir(t *testing.T, files map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(dir)
+	for _, key := range configKeys {
+		t.Setenv(key, "") // restores the original value afterwards
+		os.Unsetenv(key)
+	}
+}
+
+func TestReadYAML(t *testing.T) {
+	for _, tc := range []struct {
+		name, content string
+		want          map[string]string
+		err           string
+	}{
+		{"plain values", "port: 8081\napp_env: production\n", map[string]string{"PORT": "8081", "APP_ENV": "production"}, ""},
+		{"quoted values", "a: \"x: y\"\nb: 'z # not a comment'\n", map[string]string{"A": "x: y", "B": "z # not a comment"}, ""},
+		{"comments", "---\n# header\ndaily_limit: 5 # per day\ntoken: abc#def\n", map[string]string{"DAILY_LIMIT": "5", "TOKEN": "abc#def"}, ""},
+		{"empty values", "log_channel_id:\nlog_routes: \"\"\n", map[string]string{"LOG_CHANNEL_ID": "", "LOG_ROUTES": ""}, ""},
+		{"nested mapping", "db:\n  url: x\n", nil, "config.yaml:2: only top-level"},
+		{"list", "- a\n", nil, "config.yaml:1: only top-level"},
+		{"missing colon", "port 8080\n", nil, "config.yaml:1: expected"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testDir(t, map[string]string{"config.yaml": tc.content})
+			got, err := readYAML("config.yaml")
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got error %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(got, tc.want) {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	testDir(t, map[string]string{
+		".env.test":   "PORT=9001\nDAILY_LIMIT=7\n",
+		"config.yaml": "port: 9002\ndaily_limit: 8\ndownload_cooldown: 45s\n",
+	})
+	t.Setenv("PORT", "9000")
+
+	cfg, err := Load("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != "9000" {
+		t.Errorf("PORT %q, want the environment value 9000", cfg.Port)
+	}
+	if cfg.DailyLimit != 7 {
+		t.Errorf("DAILY_LIMIT %d, want the .env value 7", cfg.DailyLimit)
+	}
+	if cfg.DownloadCooldown != 45*time.Second {
+		t.Errorf("DOWNLOAD_COOLDOWN %s, want the YAML value 45s", cfg.DownloadCooldown)
+	}
+}
+
+func TestLoadValidation(t *testing.T) {
+	valid := map[string]string{
+		"DATABASE_URL":       "postgres://localhost/telestory",
+		"TELEGRAM_BOT_TOKEN": "123:abc",
+		"TELESTORY_API_KEY":  "key",
+		"TELESTORY_API_URL":  "https://api.example.com",
+		"ARCHIVE_CHANNEL_ID": "-1001234567890",
+	}
+	for _, tc := range []struct {
+		name string
+		env  map[string]string
+		err  string // empty when the configuration is valid
+	}{
+		{"valid", nil, ""},
+		{"missing required key", map[string]string{"TELESTORY_API_KEY": ""}, "TELESTORY_API_KEY is required"},
+		{"chat ID not a number", map[string]string{"ARCHIVE_CHANNEL_ID": "@archive"}, "ARCHIVE_CHANNEL_ID must be a numeric chat ID"},
+		{"zero chat ID", map[string]string{"LOG_CHANNEL_ID": "0"}, "LOG_CHANNEL_ID must be a numeric chat ID"},
+		{"route chat ID", map[string]string{"LOG_ROUTES": "search:abc"}, "LOG_ROUTES has an invalid chat ID for search"},
+		{"route category", map[string]string{"LOG_ROUTES": "spam:-100"}, "LOG_ROUTES must be category:chat_id pairs"},
+		{"duration without unit", map[string]string{"DOWNLOAD_COOLDOWN": "30"}, "DOWNLOAD_COOLDOWN must be a duration"},
+		{"negative duration", map[string]string{"SHUTDOWN_TIMEOUT": "-1s"}, "SHUTDOWN_TIMEOUT must be a duration"},
+		{"invalid duration", map[string]string{"ALERT_WINDOW": "soon"}, "ALERT_WINDOW must be a duration"},
+		{"zero limit", map[string]string{"DAILY_LIMIT": "0"}, "DAILY_LIMIT must be a positive integer"},
+		{"port out of range", map[string]string{"PORT": "70000"}, "PORT must be a port number"},
+		{"API URL scheme", map[string]string{"TELESTORY_API_URL": "ftp://api.example.com"}, "TELESTORY_API_URL must use one of the schemes"},
+		{"database URL scheme", map[string]string{"DATABASE_URL": "mysql://localhost/db"}, "DATABASE_URL must use one of the schemes"},
+		{"webhook without URL", map[string]string{"BOT_MODE": "webhook", "WEBHOOK_SECRET": "s"}, "WEBHOOK_PUBLIC_URL is required"},
+		{"missing config file", map[string]string{"CONFIG_FILE": "missing.yaml"}, "failed to open missing.yaml"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testDir(t, nil)
+			for key, value := range valid {
+				t.Setenv(key, value)
+			}
+			for key, value := range tc.env {
+				t.Setenv(key, value)
+			}
+
+			_, err := Load("test", ServerRequired...)
+			if tc.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("got error %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,375 @@
+package controllers
+
+import (
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/models"
+	"github.com/bbr/telestory-api-based/internal/repositories"
+	"github.com/bbr/telestory-api-based/internal/services"
+)
+
+// Pagination of the admin list endpoints
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+	maxRequestBody   = 64 << 10
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+// OpenAPI serves the spec of the admin API; it is public so tools can discover the API
+func (c *HTTPController) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// pageParams reads ?limit= (default 50, up to 200) and ?offset=
+func pageParams(r *http.Request) (limit, offset int, err error) {
+	limit, ok := queryInt(r, "limit", defaultPageLimit, maxPageLimit)
+	if !ok {
+		return 0, 0, fmt.Errorf("limit must be a positive integer")
+	}
+	if value := r.URL.Query().Get("offset"); value != "" {
+		offset, err = strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("offset must be a non-negative integer")
+		}
+	}
+	return limit, offset, nil
+}
+
+// writePage writes one page of a list endpoint; items is never null
+func writePage[T any](w http.ResponseWriter, items []T, total, limit, offset int) {
+	if items == nil {
+		items = []T{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"items": items, "total": total, "limit": limit, "offset": offset})
+}
+
+// readJSON decodes a small request body, rejecting unknown fields
+func readJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid JSON body: %v", err)
+	}
+	return nil
+}
+
+func (c *HTTPController) apiFailed(w http.ResponseWriter, r *http.Request, err error) {
+	slog.ErrorContext(r.Context(), "Admin API request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
+
+// userJSON is a user as the API returns it; nullable times are null instead of sql.NullTime objects
+type userJSON struct {
+	ID                int64      `json:"id"`
+	FirstName         string     `json:"first_name"`
+	LastName          string     `json:"last_name"`
+	Username          string     `json:"username"`
+	PhoneNumber       string     `json:"phone_number"`
+	LanguageCode      string     `json:"language_code"`
+	IsTelegramPremium bool       `json:"is_telegram_premium"`
+	IsBotPremium      bool       `json:"is_bot_premium"`
+	PremiumExpiresAt  *time.Time `json:"premium_expires_at"`
+	Role              string     `json:"role"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+	LastActiveAt      *time.Time `json:"last_active_at"`
+}
+
+func newUserJSON(u *models.User) userJSON {
+	return userJSON{
+		ID:                u.ID,
+		FirstName:         u.FirstName,
+		LastName:          u.LastName,
+		Username:          u.Username,
+		PhoneNumber:       u.PhoneNumber,
+		LanguageCode:      u.LanguageCode,
+		IsTelegramPremium: u.IsTelegramPremium,
+		IsBotPremium:      u.IsBotPremium(),
+		PremiumExpiresAt:  timePtr(u.PremiumExpiresAt),
+		Role:              u.Role,
+		CreatedAt:         u.CreatedAt,
+		UpdatedAt:         u.UpdatedAt,
+		LastActiveAt:      timePtr(u.LastActiveAt),
+	}
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// pathID reads a positive integer path value
+func pathID(r *http.Request, name string) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue(name), 10, 64)
+	return id, err == nil && id > 0
+}
+
+// ListUsers searches users with ?q= (ID, username or name), ?role=, ?language= and ?premium=true|false
+func (c *HTTPController) ListUsers(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := pageParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q := r.URL.Query()
+	filter := repositories.UserFilter{
+		Query:    strings.TrimSpace(q.Get("q")),
+		Role:     q.Get("role"),
+		Language: q.Get("language"),
+	}
+	if value := q.Get("premium"); value != "" {
+		premium, err := strconv.ParseBool(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "premium must be true or false")
+			return
+		}
+		filter.Premium = &premium
+	}
+
+	users, total, err := c.Users.SearchUsers(r.Context(), filter, limit, offset)
+	if err != nil {
+		c.apiFailed(w, r, err)
+		return
+	}
+	items := make([]userJSON, len(users))
+	for i := range users {
+		items[i] = newUserJSON(&users[i])
+	}
+	writePage(w, items, total, limit, offset)
+}
+
+func (c *HTTPController) GetUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid user ID")
+		return
+	}
+	user, err := c.Users.GetUser(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if err != nil {
+		c.apiFailed(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newUserJSON(user))
+}
+
+// UpdateUser changes the role and/or bot premium of a user; "premium_expires_at": null removes premium
+func (c *HTTPController) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid user ID")
+		return
+	}
+	var body struct {
+		Role             *string         `json:"role"`
+		PremiumExpiresAt json.RawMessage `json:"premium_expires_at"`
+	}
+	if err := readJSON(w, r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	update := services.UserUpdate{Role: body.Role}
+	if body.PremiumExpiresAt != nil {
+		var expiresAt time.Time
+		if string(body.PremiumExpiresAt) != "null" {
+			if err := json.Unmarshal(body.PremiumExpiresAt, &expiresAt); err != nil {
+				writeError(w, http.StatusBadRequest, "premium_expires_at must be an RFC 3339 time or null")
+				return
+			}
+		}
+		update.PremiumExpiresAt = &expiresAt
+	}
+	if err := services.ValidateUserUpdate(update); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, err := c.Users.UpdateUser(r.Context(), id, update)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	if err != nil {
+		c.apiFailed(w, r, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "Admin API updated user", "key_id", apiKey(r).ID, "user_id", id,
+		"role", user.Role, "premium_expires_at", timePtr(user.PremiumExpiresAt))
+	writeJSON(w, http.StatusOK, newUserJSON(user))
+}
+
+// ListDownloads lists downloads of all users with ?user_id=, ?status= and ?from=/?to= dates
+func (c *HTTPController) ListDownloads(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := pageParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q := r.URL.Query()
+	filter := repositories.DownloadFilter{Status: q.Get("status"), From: q.Get("from"), To: q.Get("to")}
+	if value := q.Get("user_id"); value != "" {
+		if filter.UserID, err = strconv.ParseInt(value, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, "user_id must be an integer")
+			return
+		}
+	}
+	for _, date := range []string{filter.From, filter.To} {
+		if _, err := time.Parse(time.DateOnly, date); date != "" && err != nil {
+			writeError(w, http.StatusBadRequest, "from and to must be YYYY-MM-DD dates")
+			return
+		}
+	}
+
+	downloads, total, err := c.Downloads.SearchDownloads(r.Context(), filter, limit, offset)
+	if err != nil {
+		c.apiFailed(w, r, err)
+		return
+	}
+	writePage(w, downloads, total, limit, offset)
+}
+
+// settingJSON is the effective value of a runtime setting
+type settingJSON struct {
+	Key        string `json:"key"`
+	Value      string `json:"value"`
+	Overridden bool   `json:"overridden"`
+}
+
+func (c *HTTPController) setting(key string) settingJSON {
+	value, overridden, _ := c.Runtime.Get(key)
+	return settingJSON{Key: key, Value: value, Overridden: overridden}
+}
+
+// ListSettings returns every runtime setting with its effective value
+func (c *HTTPController) ListSettings(w http.ResponseWriter, r *http.Request) {
+	keys := c.Runtime.Keys()
+	settings := make([]settingJSON, len(keys))
+	for i, key := range keys {
+		settings[i] = c.setting(key)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"settings": settings})
+}
+
+// SetSetting overrides a runtime setting on every instance
+func (c *HTTPController) SetSetting(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if _, _, err := c.Runtime.Get(key); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	var body struct {
+		Value string `json:"value"`
+	}
+	if err := readJSON(w, r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := c.Runtime.Validate(key, body.Value); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := c.Runtime.Set(r.Context(), key, body.Value, apiKey(r).CreatedBy); err != nil {
+		c.apiFailed(w, r, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "Admin API set runtime setting", "key_id", apiKey(r).ID, "key", key, "value", body.Value)
+	writeJSON(w, http.StatusOK, c.setting(key))
+}
+
+// ResetSetting removes an override so the startup configuration applies again
+func (c *HTTPController) ResetSetting(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if _, _, err := c.Runtime.Get(key); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err := c.Runtime.Reset(r.Context(), key); err != nil {
+		c.apiFailed(w, r, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "Admin API reset runtime setting", "key_id", apiKey(r).ID, "key", key)
+	writeJSON(w, http.StatusOK, c.setting(key))
+}
+
+func (c *HTTPController) ListBroadcasts(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := pageParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	broadcasts, total, err := c.Broadcasts.List(r.Context(), limit, offset)
+	if err != nil {
+		c.apiFailed(w, r, err)
+		return
+	}
+	writePage(w, broadcasts, total, limit, offset)
+}
+
+// CreateBroadcast starts sending a message to all users, or to the users of one language. It answers
+// 202 at once and the progress is polled with GetBroadcast
+func (c *HTTPController) CreateBroadcast(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Message   string `json:"message"`
+		Language  string `json:"language"`
+		ParseMode string `json:"parse_mode"`
+	}
+	if err := readJSON(w, r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := services.ValidateBroadcast(body.Message, body.Language, body.ParseMode); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	key := apiKey(r)
+	broadcast, err := c.Broadcasts.Start(r.Context(), body.Message, body.Language, body.ParseMode, key.Name)
+	if errors.Is(err, services.ErrBroadcastsStopped) {
+		writeError(w, http.StatusServiceUnavailable, "shutting down")
+		return
+	}
+	if err != nil {
+		c.apiFailed(w, r, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "Admin API started broadcast", "key_id", key.ID, "broadcast_id", broadcast.ID, "language", body.Language)
+	writeJSON(w, http.StatusAccepted, broadcast)
+}
+
+func (c *HTTPController) GetBroadcast(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok || id > 1<<31-1 {
+		writeError(w, http.StatusBadRequest, "invalid broadcast ID")
+		return
+	}
+	broadcast, err := c.Broadcasts.Get(r.Context(), int(id))
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "broadcast not found")
+		return
+	}
+	if err != nil {
+		c.apiFailed(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, broadcast)
+}
@@ -0,0 +1,221 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/config"
+	"github.com/bbr/telestory-api-based/internal/dbtest"
+	"github.com/bbr/telestory-api-based/internal/models"
+	"github.com/bbr/telestory-api-based/internal/repositories"
+	"github.com/bbr/telestory-api-based/internal/services"
+)
+
+// newAdminAPI wires the admin API on db. A nil db is enough for requests that fail
+// before any query, e.g. on validation.
+func newAdminAPI(db *sql.DB) *HTTPController {
+	userRepo := repositories.NewUserRepository(db)
+	runtime := services.NewRuntimeSettings(repositories.NewRuntimeSettingRepository(db), &config.Config{DailyLimit: 5, DownloadCooldown: time.Minute})
+	c := NewHTTPController(nil, nil, nil,
+		services.NewAdminKeyService(repositories.NewAdminKeyRepository(db)),
+		services.NewUserService(userRepo, repositories.NewDownloadRepository(db), runtime),
+		nil,
+		runtime,
+		services.NewBroadcastService(repositories.NewBroadcastRepository(db), userRepo, nil),
+	)
+	c.SetupRoutes()
+	return c
+}
+
+// serve sends a request through the mux with the given Authorization header
+func serve(c *HTTPController, method, target, authorization string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	w := httptest.NewRecorder()
+	c.Handler().ServeHTTP(w, r)
+	return w
+}
+
+// call runs a handler as if requireAdmin had let the request through; pathValues are name, value pairs
+func call(h http.HandlerFunc, method, target, body string, pathValues ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(pathValues); i += 2 {
+		r.SetPathValue(pathValues[i], pathValues[i+1])
+	}
+	r = r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, &models.AdminAPIKey{ID: 1, Name: "test"}))
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+func TestRequireAdminRejectsMissingCredentials(t *testing.T) {
+	c := newAdminAPI(nil)
+	for name, authorization := range map[string]string{
+		"missing header":   "",
+		"wrong scheme":     "Basic dXNlcjpwYXNz",
+		"empty key":        "Bearer ",
+		"wrong key prefix": "Bearer abc_" + strings.Repeat("x", 43),
+	} {
+		w := serve(c, http.MethodGet, "/api/settings", authorization)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: got %d, want 401", name, w.Code)
+		}
+		if w.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("%s: missing WWW-Authenticate", name)
+		}
+	}
+}
+
+func TestRequireAdminChecksKeys(t *testing.T) {
+	db := dbtest.Open(t)
+	c := newAdminAPI(db)
+	ctx := context.Background()
+
+	users := repositories.NewUserRepository(db)
+	userID := time.Now().UnixNano() % 1_000_000_000_000
+	if err := users.Insert(ctx, &models.User{ID: userID, FirstName: "Test"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.UpdateRole(ctx, userID, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	key, record, err := c.AdminKeys.Create(ctx, "test", userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM admin_api_keys WHERE id = $1`, record.ID)
+		db.Exec(`DELETE FROM users WHERE id = $1`, userID)
+	})
+
+	if w := serve(c, http.MethodGet, "/api/settings", "Bearer "+services.AdminKeyPrefix+"unknown"); w.Code != http.StatusUnauthorized {
+		t.Errorf("unknown key: got %d, want 401", w.Code)
+	}
+	if w := serve(c, http.MethodGet, "/api/settings", "Bearer "+key); w.Code != http.StatusOK {
+		t.Errorf("valid key: got %d, want 200: %s", w.Code, w.Body)
+	}
+
+	if _, err := users.UpdateRole(ctx, userID, "user"); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(c, http.MethodGet, "/api/settings", "Bearer "+key); w.Code != http.StatusUnauthorized {
+		t.Errorf("key of a demoted admin: got %d, want 401", w.Code)
+	}
+}
+
+func TestPageParams(t *testing.T) {
+	for _, tc := range []struct {
+		query         string
+		limit, offset int
+		ok            bool
+	}{
+		{"", defaultPageLimit, 0, true},
+		{"limit=10&offset=20", 10, 20, true},
+		{"limit=1", 1, 0, true},
+		{"limit=1000", maxPageLimit, 0, true},
+		{"offset=0", defaultPageLimit, 0, true},
+		{"limit=0", 0, 0, false},
+		{"limit=-5", 0, 0, false},
+		{"limit=ten", 0, 0, false},
+		{"offset=-1", 0, 0, false},
+		{"offset=1.5", 0, 0, false},
+	} {
+		limit, offset, err := pageParams(httptest.NewRequest(http.MethodGet, "/api/users?"+tc.query, nil))
+		if (err == nil) != tc.ok || limit != tc.limit || offset != tc.offset {
+			t.Errorf("%q: got %d, %d, %v; want %d, %d, ok=%v", tc.query, limit, offset, err, tc.limit, tc.offset, tc.ok)
+		}
+	}
+}
+
+func TestUpdateUserValidation(t *testing.T) {
+	c := newAdminAPI(nil)
+	for _, tc := range []struct {
+		name, id, body string
+	}{
+		{"id not a number", "abc", `{"role":"admin"}`},
+		{"zero id", "0", `{"role":"admin"}`},
+		{"empty update", "1", `{}`},
+		{"unknown role", "1", `{"role":"owner"}`},
+		{"bad premium time", "1", `{"premium_expires_at":"tomorrow"}`},
+		{"unknown field", "1", `{"is_banned":true}`},
+		{"invalid JSON", "1", `{"role":`},
+	} {
+		w := call(c.UpdateUser, http.MethodPatch, "/api/users/"+tc.id, tc.body, "id", tc.id)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want 400: %s", tc.name, w.Code, w.Body)
+		}
+	}
+}
+
+func TestSettingsValidation(t *testing.T) {
+	c := newAdminAPI(nil)
+	for _, tc := range []struct {
+		name, method, key, body string
+		status                  int
+	}{
+		{"set unknown key", http.MethodPut, "nope", `{"value":"1"}`, http.StatusNotFound},
+		{"set invalid value", http.MethodPut, services.SettingDailyLimit, `{"value":"many"}`, http.StatusBadRequest},
+		{"set invalid duration", http.MethodPut, services.SettingDownloadCooldown, `{"value":"soon"}`, http.StatusBadRequest},
+		{"set unknown field", http.MethodPut, services.SettingDailyLimit, `{"value":"3","by":"me"}`, http.StatusBadRequest},
+		{"reset unknown key", http.MethodDelete, "nope", ``, http.StatusNotFound},
+	} {
+		handler := c.SetSetting
+		if tc.method == http.MethodDelete {
+			handler = c.ResetSetting
+		}
+		w := call(handler, tc.method, "/api/settings/"+tc.key, tc.body, "key", tc.key)
+		if w.Code != tc.status {
+			t.Errorf("%s: got %d, want %d: %s", tc.name, w.Code, tc.status, w.Body)
+		}
+	}
+}
+
+func TestSetAndResetSetting(t *testing.T) {
+	db := dbtest.Open(t)
+	c := newAdminAPI(db)
+	key := services.SettingDailyLimit
+	t.Cleanup(func() { c.Runtime.Reset(context.Background(), key) })
+
+	var got settingJSON
+	w := call(c.SetSetting, http.MethodPut, "/api/settings/"+key, `{"value":"7"}`, "key", key)
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &got) != nil || got.Value != "7" || !got.Overridden {
+		t.Fatalf("set: got %d %s", w.Code, w.Body)
+	}
+
+	w = call(c.ResetSetting, http.MethodDelete, "/api/settings/"+key, "", "key", key)
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &got) != nil || got.Value != "5" || got.Overridden {
+		t.Fatalf("reset: got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestCreateBroadcastValidation(t *testing.T) {
+	c := newAdminAPI(nil)
+	for _, tc := range []struct {
+		name, body string
+	}{
+		{"empty message", `{"message":"  "}`},
+		{"message too long", `{"message":"` + strings.Repeat("a", 4097) + `"}`},
+		{"unknown language", `{"message":"hi","language":"de"}`},
+		{"unknown parse mode", `{"message":"hi","parse_mode":"Markdown"}`},
+		{"unknown field", `{"message":"hi","silent":true}`},
+		{"invalid JSON", `hi`},
+	} {
+		w := call(c.CreateBroadcast, http.MethodPost, "/api/broadcasts", tc.body)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want 400: %s", tc.name, w.Code, w.Body)
+		}
+	}
+
+	c.Broadcasts.Close()
+	if w := call(c.CreateBroadcast, http.MethodPost, "/api/broadcasts", `{"message":"hi"}`); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("after close: got %d, want 503: %s", w.Code, w.Body)
+	}
+}
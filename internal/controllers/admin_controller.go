@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"fmt"
+	"html"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/models"
+	"github.com/bbr/telestory-api-based/internal/services"
+	tele "gopkg.in/telebot.v3"
+)
+
+// adminUser returns the sender if they are an admin; everyone else is ignored silently
+func (c *TelegramController) adminUser(ctx tele.Context) (*models.User, bool) {
+	reqCtx := requestContext(ctx)
+	user, err := c.UserService.RegisterUser(reqCtx, ctx.Sender())
+	if err != nil {
+		slog.ErrorContext(reqCtx, "Error registering user", "error", err)
+		return nil, false
+	}
+	if user.Role != "admin" {
+		slog.WarnContext(reqCtx, "Admin command denied", "role", user.Role, "command", ctx.Text())
+		return nil, false
+	}
+	return user, true
+}
+
+// SetHandler handles "/set <key> <value>" and "/set <key> reset"
+func (c *TelegramController) SetHandler(ctx tele.Context) error {
+	reqCtx := requestContext(ctx)
+	user, ok := c.adminUser(ctx)
+	if !ok {
+		return nil
+	}
+
+	args := ctx.Args()
+	if len(args) != 2 {
+		return ctx.Send("Usage: /set <key> <value>, or /set <key> reset\nKeys: " + strings.Join(c.Runtime.Keys(), ", "))
+	}
+	key, value := args[0], args[1]
+
+	var err error
+	if value == "reset" {
+		err = c.Runtime.Reset(reqCtx, key)
+	} else {
+		err = c.Runtime.Set(reqCtx, key, value, user.ID)
+	}
+	if err != nil {
+		return ctx.Send(err.Error())
+	}
+
+	slog.InfoContext(reqCtx, "Admin set runtime setting", "key", key, "value", value)
+	c.LogService.LogAdminAction(ctx.Sender(), fmt.Sprintf("/set %s %s", key, value))
+	effective, _, _ := c.Runtime.Get(key)
+	return ctx.Send(fmt.Sprintf("✅ `%s` = `%s`", key, effective), tele.ModeMarkdown)
+}
+
+// GetHandler handles "/get <key>"
+func (c *TelegramController) GetHandler(ctx tele.Context) error {
+	if _, ok := c.adminUser(ctx); !ok {
+		return nil
+	}
+
+	args := ctx.Args()
+	if len(args) != 1 {
+		return ctx.Send("Usage: /get <key>\nKeys: " + strings.Join(c.Runtime.Keys(), ", "))
+	}
+
+	value, overridden, err := c.Runtime.Get(args[0])
+	if err != nil {
+		return ctx.Send(err.Error())
+	}
+	return ctx.Send(fmt.Sprintf("`%s` = `%s` (%s)", args[0], value, settingSource(overridden)), tele.ModeMarkdown)
+}
+
+// SettingsDumpHandler lists every runtime setting with its effective value
+func (c *TelegramController) SettingsDumpHandler(ctx tele.Context) error {
+	if _, ok := c.adminUser(ctx); !ok {
+		return nil
+	}
+
+	lines := []string{"*Runtime settings*", ""}
+	for _, key := range c.Runtime.Keys() {
+		value, overridden, _ := c.Runtime.Get(key)
+		lines = append(lines, fmt.Sprintf("`%s` = `%s` (%s)", key, value, settingSource(overridden)))
+	}
+	return ctx.Send(strings.Join(lines, "\n"), tele.ModeMarkdown)
+}
+
+// RollupHandler handles "/rollup <from> [to]" and "/rollup 7d|30d", rebuilding the daily stats rollups
+func (c *TelegramController) RollupHandler(ctx tele.Context) error {
+	reqCtx := requestContext(ctx)
+	if _, ok := c.adminUser(ctx); !ok {
+		return nil
+	}
+
+	args := ctx.Args()
+	view, err := services.ParseStatsView(args, time.Now())
+	if len(args) == 0 || err != nil {
+		return ctx.Send(fmt.Sprintf("Usage: /rollup <from> [to] with YYYY-MM-DD dates, or /rollup 7d|30d (up to %d days). Today is always computed live.", services.MaxStatsDays))
+	}
+
+	days, err := c.AnalyticsService.RebuildStats(reqCtx, view.From, view.To)
+	if err != nil {
+		slog.ErrorContext(reqCtx, "Failed to rebuild daily stats", "error", err)
+		return ctx.Send(fmt.Sprintf("❌ Rebuilt %d days before failing: %v", days, err))
+	}
+
+	slog.InfoContext(reqCtx, "Admin rebuilt daily stats", "from", view.From.Format(time.DateOnly), "to", view.To.Format(time.DateOnly), "days", days)
+	c.LogService.LogAdminAction(ctx.Sender(), "/rollup "+strings.Join(args, " "))
+	return ctx.Send(fmt.Sprintf("✅ Rebuilt %d days", days))
+}
+
+// APIKeyHandler handles "/apikey create <name>", "/apikey list" and "/apikey revoke <id>".
+// Keys are only created in private chats, since the key is shown in the reply.
+func (c *TelegramController) APIKeyHandler(ctx tele.Context) error {
+	reqCtx := requestContext(ctx)
+	user, ok := c.adminUser(ctx)
+	if !ok {
+		return nil
+	}
+
+	usage := "Usage: /apikey create <name>, /apikey list or /apikey revoke <id>"
+	args := ctx.Args()
+	if len(args) == 0 {
+		return ctx.Send(usage)
+	}
+
+	switch {
+	case args[0] == "create" && len(args) > 1:
+		if ctx.Chat().Type != tele.ChatPrivate {
+			return ctx.Send("API keys are only created in a private chat with the bot.")
+		}
+		name := strings.Join(args[1:], " ")
+		key, record, err := c.AdminKeyService.Create(reqCtx, name, user.ID)
+		if err != nil {
+			slog.ErrorContext(reqCtx, "Failed to create API key", "error", err)
+			return ctx.Send("❌ " + err.Error())
+		}
+		slog.InfoContext(reqCtx, "Admin created API key", "key_id", record.ID, "name", record.Name)
+		c.LogService.LogAdminAction(ctx.Sender(), fmt.Sprintf("/apikey create %s (#%d)", record.Name, record.ID))
+		return ctx.Send(fmt.Sprintf("✅ API key #%d <b>%s</b>\n\n<code>%s</code>\n\nIt is shown only once. Send it as <code>Authorization: Bearer &lt;key&gt;</code>.",
+			record.ID, html.EscapeString(record.Name), key), tele.ModeHTML)
+
+	case args[0] == "list" && len(args) == 1:
+		keys, err := c.AdminKeyService.List(reqCtx)
+		if err != nil {
+			slog.ErrorContext(reqCtx, "Failed to list API keys", "error", err)
+			return ctx.Send("❌ Failed to list API keys")
+		}
+		if len(keys) == 0 {
+			return ctx.Send("No API keys yet.")
+		}
+		lines := []string{"API keys", ""}
+		for _, k := range keys {
+			state := "never used"
+			switch {
+			case k.RevokedAt != nil:
+				state = "revoked " + k.RevokedAt.Format(time.DateOnly)
+			case k.LastUsedAt != nil:
+				state = "last used " + k.LastUsedAt.Format(time.DateTime)
+			}
+			lines = append(lines, fmt.Sprintf("#%d %s (%s…), by %d, created %s, %s",
+				k.ID, k.Name, k.Prefix, k.CreatedBy, k.CreatedAt.Format(time.DateOnly), state))
+		}
+		return ctx.Send(strings.Join(lines, "\n"))
+
+	case args[0] == "revoke" && len(args) == 2:
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			return ctx.Send(usage)
+		}
+		revoked, err := c.AdminKeyService.Revoke(reqCtx, id)
+		if err != nil {
+			slog.ErrorContext(reqCtx, "Failed to revoke API key", "error", err)
+			return ctx.Send("❌ Failed to revoke the key")
+		}
+		if !revoked {
+			return ctx.Send(fmt.Sprintf("No live API key #%d", id))
+		}
+		slog.InfoContext(reqCtx, "Admin revoked API key", "key_id", id)
+		c.LogService.LogAdminAction(ctx.Sender(), fmt.Sprintf("/apikey revoke %d", id))
+		return ctx.Send(fmt.Sprintf("✅ API key #%d revoked", id))
+
+	default:
+		return ctx.Send(usage)
+	}
+}
+
+func settingSource(overridden bool) string {
+	if overridden {
+		return "override"
+	}
+	return "config"
+}
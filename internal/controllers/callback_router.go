@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/callback"
+	tele "gopkg.in/telebot.v3"
+)
+
+// CallbackRoute configures how payloads of one unique prefix are verified
+type CallbackRoute struct {
+	// MaxAge after which buttons are treated as stale, zero means never
+	MaxAge time.Duration
+	// AllowLegacy accepts unsigned "a|b" payloads from buttons sent before signing existed
+	AllowLegacy bool
+}
+
+type callbackRoute struct {
+	CallbackRoute
+	handle func(ctx tele.Context, data string) error
+}
+
+// CallbackRouter dispatches inline button callbacks by their unique prefix
+type CallbackRouter struct {
+	Signer *callback.Signer
+	// Stale is called when a button is expired, forged or from an unknown version
+	Stale func(ctx tele.Context) error
+
+	routes map[string]*callbackRoute
+}
+
+func NewCallbackRouter(signer *callback.Signer) *CallbackRouter {
+	return &CallbackRouter{
+		Signer: signer,
+		Stale:  func(ctx tele.Context) error { return ctx.Respond() },
+		routes: make(map[string]*callbackRoute),
+	}
+}
+
+// HandleCallback registers a typed handler for buttons created with Signer.Button(text, unique, payload)
+func HandleCallback[T any, P interface {
+	*T
+	callback.Payload
+}](r *CallbackRouter, unique string, route CallbackRoute, handler func(ctx tele.Context, payload P) error) {
+	rt := &callbackRoute{CallbackRoute: route}
+	rt.handle = func(ctx tele.Context, data string) error {
+		payload := P(new(T))
+		err := r.Signer.Decode(unique, data, route.MaxAge, payload)
+		if errors.Is(err, callback.ErrUnsigned) && route.AllowLegacy {
+			err = payload.Parse(strings.Split(data, "|"))
+		}
+		if err != nil {
+			slog.WarnContext(requestContext(ctx), "Rejected callback", "unique", unique, "error", err)
+			return r.Stale(ctx)
+		}
+		return handler(ctx, payload)
+	}
+	r.routes[unique] = rt
+}
+
+// Dispatch is registered on tele.OnCallback and routes every callback query
+func (r *CallbackRouter) Dispatch(ctx tele.Context) error {
+	unique, data := splitCallbackData(ctx.Callback().Data)
+
+	rt, ok := r.routes[unique]
+	if !ok {
+		slog.WarnContext(requestContext(ctx), "No callback route", "unique", unique)
+		return r.Stale(ctx)
+	}
+	return rt.handle(ctx, data)
+}
+
+// routeName returns the unique of a registered route for callback data, for metrics labels
+func (r *CallbackRouter) routeName(data string) string {
+	unique, _ := splitCallbackData(data)
+	if _, ok := r.routes[unique]; ok {
+		return unique
+	}
+	return "unknown"
+}
+
+// splitCallbackData splits "\funique|data" into its parts
+func splitCallbackData(data string) (string, string) {
+	data = strings.TrimPrefix(data, "\f")
+	unique, payload, _ := strings.Cut(data, "|")
+	return unique, payload
+}
@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/bbr/telestory-api-based/internal/export"
+	"github.com/bbr/telestory-api-based/internal/services"
+)
+
+// ExportData streams /api/export/{dataset}?format=csv|xlsx with the range parameters of the stats
+// endpoints. The response is written as it is generated, so it has no Content-Length.
+func (c *HTTPController) ExportData(w http.ResponseWriter, r *http.Request) {
+	dataset, format := r.PathValue("dataset"), r.URL.Query().Get("format")
+	if format == "" {
+		format = export.Formats[0]
+	}
+	view, err := statsView(r)
+	if err == nil {
+		err = services.ValidateExport(dataset, format)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", export.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, services.ExportName(dataset, format, view, 0)))
+	rows, err := c.Export.Write(r.Context(), dataset, format, view, w)
+	if err != nil {
+		// The status is already sent; aborting the connection tells the client the body is incomplete
+		slog.ErrorContext(r.Context(), "Failed to stream export", "dataset", dataset, "rows", rows, "error", err)
+		panic(http.ErrAbortHandler)
+	}
+	slog.InfoContext(r.Context(), "Exported data over HTTP", "dataset", dataset, "format", format, "view", view.Key(), "rows", rows)
+}
@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/export"
+	"github.com/bbr/telestory-api-based/internal/services"
+	tele "gopkg.in/telebot.v3"
+)
+
+// ExportHandler handles "/export <dataset> [format] [today|7d|30d | <from> [to]]" and sends the
+// export as documents, split into several files when it is too large for one upload
+func (c *TelegramController) ExportHandler(ctx tele.Context) error {
+	reqCtx := requestContext(ctx)
+	if _, ok := c.adminUser(ctx); !ok {
+		return nil
+	}
+
+	usage := fmt.Sprintf("Usage: /export <%s> [%s] [today|7d|30d | <from> [to]]\nDates are YYYY-MM-DD, up to %d days; the default is the last 7 days as %s.",
+		strings.Join(services.ExportDatasets, "|"), strings.Join(export.Formats, "|"), services.MaxStatsDays, export.Formats[0])
+	args := ctx.Args()
+	if len(args) == 0 {
+		return ctx.Send(usage)
+	}
+	dataset, format := args[0], export.Formats[0]
+	args = args[1:]
+	if len(args) > 0 && slices.Contains(export.Formats, args[0]) {
+		format, args = args[0], args[1:]
+	}
+	view, err := services.ParseStatsView(args, time.Now())
+	if err == nil {
+		err = services.ValidateExport(dataset, format)
+	}
+	if err != nil {
+		return ctx.Send(err.Error() + "\n\n" + usage)
+	}
+
+	ctx.Notify(tele.UploadingDocument)
+	files, err := c.ExportService.WriteFiles(reqCtx, dataset, format, view)
+	if err != nil {
+		slog.ErrorContext(reqCtx, "Failed to export", "dataset", dataset, "error", err)
+		return ctx.Send("❌ Export failed.")
+	}
+	defer services.RemoveExportFiles(files)
+
+	slog.InfoContext(reqCtx, "Admin exported data", "dataset", dataset, "format", format, "view", view.Key(), "files", len(files))
+	c.LogService.LogAdminAction(ctx.Sender(), "/export "+strings.Join(ctx.Args(), " "))
+	for i, f := range files {
+		caption := fmt.Sprintf("%s %s – %s: %d rows", dataset, view.From.Format(time.DateOnly), view.To.Format(time.DateOnly), f.Rows)
+		if len(files) > 1 {
+			caption += fmt.Sprintf(" (part %d/%d)", i+1, len(files))
+		}
+		doc := &tele.Document{File: tele.FromDisk(f.Path), FileName: f.Name, Caption: caption, MIME: export.ContentType(format)}
+		if err := ctx.Send(doc); err != nil {
+			slog.ErrorContext(reqCtx, "Failed to send export file", "file", f.Name, "error", err)
+			return ctx.Send("❌ Failed to send " + f.Name)
+		}
+	}
+	return nil
+}
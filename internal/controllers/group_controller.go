@@ -0,0 +1,183 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/bbr/telestory-api-based/internal/i18n"
+	"github.com/bbr/telestory-api-based/internal/models"
+	"github.com/bbr/telestory-api-based/internal/services"
+	tele "gopkg.in/telebot.v3"
+)
+
+// groupSettingsUnique is the callback unique of the /groupsettings menu
+const groupSettingsUnique = "gset"
+
+// groupSettingsPayload is the callback payload of the /groupsettings menu
+type groupSettingsPayload struct {
+	Delivery string
+}
+
+func (p *groupSettingsPayload) Fields() []string {
+	return []string{p.Delivery}
+}
+
+func (p *groupSettingsPayload) Parse(fields []string) error {
+	if len(fields) != 1 {
+		return fmt.Errorf("group settings payload: expected 1 field, got %d", len(fields))
+	}
+	p.Delivery = fields[0]
+	return nil
+}
+
+// groupLanguage picks a supported language for a member who never chose one
+func groupLanguage(teleUser *tele.User) string {
+	if _, ok := i18n.Locales[teleUser.LanguageCode]; ok {
+		return teleUser.LanguageCode
+	}
+	return i18n.LangEN
+}
+
+// StoryCommandHandler handles "/story <target>" (and "/story@bot <target>" in groups)
+func (c *TelegramController) StoryCommandHandler(ctx tele.Context) error {
+	target := strings.TrimSpace(ctx.Message().Payload)
+	if target == "" && services.IsGroup(ctx.Chat()) {
+		return c.groupInstruction(ctx)
+	}
+	if target == "" {
+		msg := i18n.GetMessage(c.senderLanguage(requestContext(ctx), ctx.Sender()), "instruction")
+		return ctx.Send(msg, &tele.SendOptions{ParseMode: tele.ModeMarkdown})
+	}
+	return c.handleSearch(ctx, target)
+}
+
+// GroupTextHandler only reacts to messages that mention the bot, e.g. "@bot username"
+func (c *TelegramController) GroupTextHandler(ctx tele.Context) error {
+	fields := strings.Fields(ctx.Text())
+	if len(fields) == 0 || !strings.EqualFold(fields[0], "@"+c.Bot.Me.Username) {
+		return nil
+	}
+	if len(fields) != 2 {
+		return c.groupInstruction(ctx)
+	}
+	return c.handleSearch(ctx, fields[1])
+}
+
+func (c *TelegramController) groupInstruction(ctx tele.Context) error {
+	msg := fmt.Sprintf(i18n.GetMessage(groupLanguage(ctx.Sender()), "group_instruction"), c.Bot.Me.Username, c.Bot.Me.Username)
+	return ctx.Reply(msg, &tele.SendOptions{ParseMode: tele.ModeMarkdown})
+}
+
+// sendProcessing sends the processing message where the results will be delivered and sets req.ChatID.
+// It returns a nil message when the request was answered otherwise.
+func (c *TelegramController) sendProcessing(ctx tele.Context, user *models.User, req *services.StoryRequest) (*tele.Message, error) {
+	reqCtx := requestContext(ctx)
+	processingMsg := i18n.GetMessage(user.LanguageCode, "processing")
+	if !services.IsGroup(ctx.Chat()) {
+		return c.Bot.Send(ctx.Sender(), processingMsg)
+	}
+
+	chat, err := c.ChatService.RegisterChat(reqCtx, ctx.Chat())
+	if err != nil {
+		return nil, err
+	}
+
+	if chat.Delivery != models.DeliveryPrivate {
+		req.ChatID = chat.ID
+		return c.Bot.Reply(ctx.Message(), processingMsg)
+	}
+
+	// Private delivery only works if the member has started the bot
+	sentMsg, err := c.Bot.Send(ctx.Sender(), processingMsg)
+	if err != nil {
+		slog.WarnContext(requestContext(ctx), "Cannot message user privately", "error", err)
+		menu := &tele.ReplyMarkup{}
+		startURL := fmt.Sprintf("https://t.me/%s?start=%s%s", c.Bot.Me.Username, inlineFetchPrefix, services.NormalizeTarget(req.Input))
+		menu.Inline(menu.Row(menu.URL(i18n.GetMessage(user.LanguageCode, "group_open_bot"), startURL)))
+		return nil, ctx.Reply(i18n.GetMessage(user.LanguageCode, "group_start_bot_first"), menu)
+	}
+
+	ctx.Reply(i18n.GetMessage(user.LanguageCode, "group_sent_private"))
+	return sentMsg, nil
+}
+
+// GroupSettingsHandler shows the group settings menu to group admins
+func (c *TelegramController) GroupSettingsHandler(ctx tele.Context) error {
+	reqCtx := requestContext(ctx)
+	langCode := c.senderLanguage(reqCtx, ctx.Sender())
+
+	if !services.IsGroup(ctx.Chat()) {
+		return ctx.Send(i18n.GetMessage(langCode, "group_only"))
+	}
+
+	isAdmin, err := c.ChatService.IsChatAdmin(c.Bot, ctx.Chat(), ctx.Sender())
+	if err != nil {
+		slog.ErrorContext(reqCtx, "Error checking chat admin", "error", err)
+		return ctx.Reply("An error occurred. Please try again.")
+	}
+	if !isAdmin {
+		return ctx.Reply(i18n.GetMessage(langCode, "group_admin_only"))
+	}
+
+	chat, err := c.ChatService.RegisterChat(reqCtx, ctx.Chat())
+	if err != nil {
+		slog.ErrorContext(reqCtx, "Error registering chat", "error", err)
+		return ctx.Reply("An error occurred. Please try again.")
+	}
+
+	menu, err := c.groupSettingsMenu(langCode, chat.Delivery)
+	if err != nil {
+		return err
+	}
+	return ctx.Send(i18n.GetMessage(langCode, "group_settings"), menu, tele.ModeMarkdown)
+}
+
+func (c *TelegramController) GroupSettingsCallback(ctx tele.Context, payload *groupSettingsPayload) error {
+	reqCtx := requestContext(ctx)
+	langCode := c.senderLanguage(reqCtx, ctx.Sender())
+
+	// Re-check: anyone in the group can press the buttons
+	isAdmin, err := c.ChatService.IsChatAdmin(c.Bot, ctx.Chat(), ctx.Sender())
+	if err != nil || !isAdmin {
+		return ctx.Respond(&tele.CallbackResponse{Text: i18n.GetMessage(langCode, "group_admin_only"), ShowAlert: true})
+	}
+
+	if err := c.ChatService.UpdateDelivery(reqCtx, ctx.Chat().ID, payload.Delivery); err != nil {
+		slog.ErrorContext(reqCtx, "Error updating group delivery", "error", err)
+		return ctx.Respond(&tele.CallbackResponse{Text: "Error updating settings"})
+	}
+
+	ctx.Respond(&tele.CallbackResponse{Text: i18n.GetMessage(langCode, "settings_saved")})
+	menu, err := c.groupSettingsMenu(langCode, payload.Delivery)
+	if err != nil {
+		return err
+	}
+	_, err = c.Bot.Edit(ctx.Message(), i18n.GetMessage(langCode, "group_settings"), menu, tele.ModeMarkdown)
+	return err
+}
+
+func (c *TelegramController) groupSettingsMenu(langCode, delivery string) (*tele.ReplyMarkup, error) {
+	label := func(key, value string) string {
+		if value == delivery {
+			return "✅ " + i18n.GetMessage(langCode, key)
+		}
+		return i18n.GetMessage(langCode, key)
+	}
+
+	menu := &tele.ReplyMarkup{}
+	kb := c.Callbacks.Signer.Keyboard()
+	btnGroup := kb.Button(label("group_delivery_group", models.DeliveryGroup), groupSettingsUnique, &groupSettingsPayload{Delivery: models.DeliveryGroup})
+	btnPrivate := kb.Button(label("group_delivery_private", models.DeliveryPrivate), groupSettingsUnique, &groupSettingsPayload{Delivery: models.DeliveryPrivate})
+	menu.Inline(menu.Row(btnGroup), menu.Row(btnPrivate))
+	return menu, kb.Err()
+}
+
+// senderLanguage returns the stored language of a user, or their Telegram language
+func (c *TelegramController) senderLanguage(reqCtx context.Context, teleUser *tele.User) string {
+	if user, err := c.UserService.RegisterUser(reqCtx, teleUser); err == nil && user.LanguageCode != "" {
+		return user.LanguageCode
+	}
+	return groupLanguage(teleUser)
+}
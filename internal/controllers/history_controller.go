@@ -0,0 +1,180 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/bbr/telestory-api-based/internal/i18n"
+	"github.com/bbr/telestory-api-based/internal/models"
+	"github.com/bbr/telestory-api-based/internal/services"
+	tele "gopkg.in/telebot.v3"
+)
+
+// historyUnique is the callback unique of the /history menu
+const historyUnique = "hist"
+
+// /history actions
+const (
+	historyPage   = "p"
+	historyResend = "s"
+	historyDelete = "d"
+)
+
+// historyPayload is the callback payload of the /history menu
+type historyPayload struct {
+	Action     string
+	DownloadID int
+	Page       int
+}
+
+func (p *historyPayload) Fields() []string {
+	return []string{p.Action, strconv.Itoa(p.DownloadID), strconv.Itoa(p.Page)}
+}
+
+func (p *historyPayload) Parse(fields []string) error {
+	if len(fields) != 3 {
+		return fmt.Errorf("history payload: expected 3 fields, got %d", len(fields))
+	}
+	downloadID, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return fmt.Errorf("history payload: invalid download ID: %v", err)
+	}
+	page, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return fmt.Errorf("history payload: invalid page: %v", err)
+	}
+	p.Action, p.DownloadID, p.Page = fields[0], downloadID, page
+	return nil
+}
+
+func (c *TelegramController) HistoryHandler(ctx tele.Context) error {
+	reqCtx := requestContext(ctx)
+	user, err := c.UserService.RegisterUser(reqCtx, ctx.Sender())
+	if err != nil {
+		slog.ErrorContext(reqCtx, "Error registering user", "error", err)
+		return ctx.Send("An error occurred. Please try again.")
+	}
+
+	if user.LanguageCode == "" {
+		return c.showLanguageMenu(ctx)
+	}
+
+	text, menu, err := c.historyPage(reqCtx, user, 0)
+	if err != nil {
+		slog.ErrorContext(reqCtx, "Error loading history", "error", err)
+		return ctx.Send("An error occurred. Please try again.")
+	}
+	return ctx.Send(text, menu, tele.ModeMarkdown)
+}
+
+func (c *TelegramController) HistoryCallback(ctx tele.Context, payload *historyPayload) error {
+	reqCtx := requestContext(ctx)
+	user, err := c.UserService.RegisterUser(reqCtx, ctx.Sender())
+	if err != nil {
+		slog.ErrorContext(reqCtx, "Error registering user", "error", err)
+		return ctx.Respond()
+	}
+
+	page := payload.Page
+	switch payload.Action {
+	case historyResend:
+		sent, denied, err := c.DownloadService.ResendDownload(reqCtx, c.Bot, user, payload.DownloadID)
+		if err != nil {
+			slog.ErrorContext(reqCtx, "Error re-sending download", "download_id", payload.DownloadID, "error", err)
+			return ctx.Respond(&tele.CallbackResponse{Text: "Error re-sending stories"})
+		}
+		if denied != "" {
+			return ctx.Respond(&tele.CallbackResponse{Text: denied, ShowAlert: true})
+		}
+		if sent == 0 {
+			return ctx.Respond(&tele.CallbackResponse{Text: i18n.GetMessage(user.LanguageCode, "history_nothing_to_resend"), ShowAlert: true})
+		}
+		return ctx.Respond(&tele.CallbackResponse{Text: fmt.Sprintf(i18n.GetMessage(user.LanguageCode, "history_resent"), sent)})
+
+	case historyDelete:
+		if _, err := c.DownloadService.DeleteHistoryEntry(reqCtx, user.ID, payload.DownloadID); err != nil {
+			slog.ErrorContext(reqCtx, "Error deleting history entry", "download_id", payload.DownloadID, "error", err)
+			return ctx.Respond(&tele.CallbackResponse{Text: "Error deleting entry"})
+		}
+		ctx.Respond(&tele.CallbackResponse{Text: i18n.GetMessage(user.LanguageCode, "history_deleted")})
+
+	default:
+		ctx.Respond(&tele.CallbackResponse{})
+	}
+
+	text, menu, err := c.historyPage(reqCtx, user, page)
+	if err != nil {
+		slog.ErrorContext(reqCtx, "Error loading history", "error", err)
+		return nil
+	}
+	_, err = c.Bot.Edit(ctx.Message(), text, menu, tele.ModeMarkdown)
+	if err == tele.ErrSameMessageContent {
+		return nil
+	}
+	return err
+}
+
+// historyPage renders one page of the user's history, clamping page to the available range
+func (c *TelegramController) historyPage(reqCtx context.Context, user *models.User, page int) (string, *tele.ReplyMarkup, error) {
+	lang := user.LanguageCode
+
+	downloads, total, err := c.DownloadService.History(reqCtx, user.ID, page)
+	if err != nil {
+		return "", nil, err
+	}
+
+	pages := (total + services.HistoryPageSize - 1) / services.HistoryPageSize
+	if pages == 0 {
+		// An empty inline keyboard removes the buttons when editing
+		empty := &tele.ReplyMarkup{}
+		empty.Inline()
+		return i18n.GetMessage(lang, "history_empty"), empty, nil
+	}
+	if page >= pages {
+		// The last entry of the last page was deleted
+		return c.historyPage(reqCtx, user, pages-1)
+	}
+
+	loc := c.UserService.SettingsOrDefault(reqCtx, user.ID).Location()
+	menu := &tele.ReplyMarkup{}
+	kb := c.Callbacks.Signer.Keyboard()
+	btn := func(label, action string, downloadID, page int) tele.Btn {
+		return kb.Button(label, historyUnique, &historyPayload{Action: action, DownloadID: downloadID, Page: page})
+	}
+
+	var lines []string
+	var rows []tele.Row
+	for i, d := range downloads {
+		n := page*services.HistoryPageSize + i + 1
+		icon := "✅"
+		if d.Status != "success" {
+			icon = "❌"
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s `%s` — %s (%d)", n, icon, d.Input, d.CreatedAt.In(loc).Format("2006-01-02 15:04"), d.StoryCount))
+
+		row := tele.Row{}
+		if d.StoryCount > 0 {
+			row = append(row, btn(fmt.Sprintf("🔁 %d", n), historyResend, d.ID, page))
+		}
+		row = append(row, btn(fmt.Sprintf("🗑 %d", n), historyDelete, d.ID, page))
+		rows = append(rows, row)
+	}
+
+	if pages > 1 {
+		rows = append(rows, menu.Row(
+			btn("◀️", historyPage, 0, (page+pages-1)%pages),
+			btn(fmt.Sprintf("%d/%d", page+1, pages), historyPage, 0, page),
+			btn("▶️", historyPage, 0, (page+1)%pages),
+		))
+	}
+	if err := kb.Err(); err != nil {
+		return "", nil, err
+	}
+	menu.Inline(rows...)
+
+	text := fmt.Sprintf(i18n.GetMessage(lang, "history_title"), total) + "\n\n" + strings.Join(lines, "\n")
+	return text, menu, nil
+}
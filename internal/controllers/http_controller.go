@@ -1,21 +1,149 @@
 package controllers
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"strings"
+
+	"github.com/bbr/telestory-api-based/internal/logging"
+	"github.com/bbr/telestory-api-based/internal/metrics"
+	"github.com/bbr/telestory-api-based/internal/models"
+	"github.com/bbr/telestory-api-based/internal/services"
 )
 
-type HTTPController struct{}
+type HTTPController struct {
+	Mux        *http.ServeMux
+	Health     *services.HealthService
+	Analytics  *services.AnalyticsService
+	Export     *services.ExportService
+	AdminKeys  *services.AdminKeyService
+	Users      *services.UserService
+	Downloads  *services.DownloadService
+	Runtime    *services.RuntimeSettings
+	Broadcasts *services.BroadcastService
+}
 
-func NewHTTPController() *HTTPController {
-	return &HTTPController{}
+func NewHTTPController(health *services.HealthService, analytics *services.AnalyticsService, exportService *services.ExportService, adminKeys *services.AdminKeyService, userService *services.UserService, downloadService *services.DownloadService, runtime *services.RuntimeSettings, broadcasts *services.BroadcastService) *HTTPController {
+	return &HTTPController{
+		Mux:        http.NewServeMux(),
+		Health:     health,
+		Analytics:  analytics,
+		Export:     exportService,
+		AdminKeys:  adminKeys,
+		Users:      userService,
+		Downloads:  downloadService,
+		Runtime:    runtime,
+		Broadcasts: broadcasts,
+	}
 }
 
 func (c *HTTPController) SetupRoutes() {
-	http.HandleFunc("/health", c.HealthCheck)
+	c.Mux.HandleFunc("/health", c.HealthCheck)
+	c.Mux.HandleFunc("/livez", c.Livez)
+	c.Mux.HandleFunc("/readyz", c.Readyz)
+	c.Mux.Handle("/metrics", metrics.Default.Handler())
+	c.Mux.HandleFunc("GET /openapi.json", c.OpenAPI)
+
+	// Admin API, authenticated with keys from /apikey
+	c.Mux.HandleFunc("GET /api/users", c.requireAdmin(c.ListUsers))
+	c.Mux.HandleFunc("GET /api/users/{id}", c.requireAdmin(c.GetUser))
+	c.Mux.HandleFunc("PATCH /api/users/{id}", c.requireAdmin(c.UpdateUser))
+	c.Mux.HandleFunc("GET /api/downloads", c.requireAdmin(c.ListDownloads))
+	c.Mux.HandleFunc("GET /api/stats/daily", c.requireAdmin(c.StatsDaily))
+	c.Mux.HandleFunc("GET /api/stats/targets", c.requireAdmin(c.StatsTargets))
+	c.Mux.HandleFunc("GET /api/stats/languages", c.requireAdmin(c.StatsLanguages))
+	c.Mux.HandleFunc("GET /api/stats/funnel", c.requireAdmin(c.StatsFunnel))
+	c.Mux.HandleFunc("GET /api/stats/retention", c.requireAdmin(c.StatsRetention))
+	c.Mux.HandleFunc("GET /api/export/{dataset}", c.requireAdmin(c.ExportData))
+	c.Mux.HandleFunc("GET /api/settings", c.requireAdmin(c.ListSettings))
+	c.Mux.HandleFunc("PUT /api/settings/{key}", c.requireAdmin(c.SetSetting))
+	c.Mux.HandleFunc("DELETE /api/settings/{key}", c.requireAdmin(c.ResetSetting))
+	c.Mux.HandleFunc("GET /api/broadcasts", c.requireAdmin(c.ListBroadcasts))
+	c.Mux.HandleFunc("POST /api/broadcasts", c.requireAdmin(c.CreateBroadcast))
+	c.Mux.HandleFunc("GET /api/broadcasts/{id}", c.requireAdmin(c.GetBroadcast))
+}
+
+type apiKeyContextKey struct{}
+
+// apiKey returns the key that authenticated a request behind requireAdmin
+func apiKey(r *http.Request) *models.AdminAPIKey {
+	key, _ := r.Context().Value(apiKeyContextKey{}).(*models.AdminAPIKey)
+	return key
+}
+
+// requireAdmin rejects requests without "Authorization: Bearer <key>" of a live admin API key
+func (c *HTTPController) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		key, err := c.AdminKeys.Authenticate(r.Context(), token)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to authenticate API key", "error", err)
+			writeError(w, http.StatusServiceUnavailable, "failed to check credentials")
+			return
+		}
+		if key == nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key)))
+	}
+}
+
+// Handler is the mux wrapped with request IDs; an incoming X-Request-ID is kept
+func (c *HTTPController) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if len(id) > 64 {
+			id = ""
+		}
+		ctx := logging.WithRequestID(r.Context(), id)
+		w.Header().Set("X-Request-ID", logging.RequestID(ctx))
+		c.Mux.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Handle mounts an additional handler, e.g. the Telegram webhook
+func (c *HTTPController) Handle(pattern string, handler http.Handler) {
+	c.Mux.Handle(pattern, handler)
 }
 
 func (c *HTTPController) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintln(w, "OK")
 }
+
+// Livez only reports that the process is serving HTTP
+func (c *HTTPController) Livez(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": services.HealthOK})
+}
+
+// Readyz returns the readiness breakdown, with 503 when any check is degraded
+func (c *HTTPController) Readyz(w http.ResponseWriter, r *http.Request) {
+	report := c.Health.Ready(r.Context())
+	status := http.StatusOK
+	if report.Status != services.HealthOK {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("Failed to write JSON response", "error", err)
+	}
+}
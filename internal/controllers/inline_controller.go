@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/bbr/telestory-api-based/internal/i18n"
+	"github.com/bbr/telestory-api-based/internal/services"
+	tele "gopkg.in/telebot.v3"
+)
+
+// inlineFetchPrefix prefixes the /start payload of the "open bot to fetch" button
+const inlineFetchPrefix = "fetch_"
+
+const (
+	inlinePageSize  = 50 // Telegram allows at most 50 results per answer
+	inlineCacheTime = 60
+)
+
+// InlineQueryHandler answers "@bot username" with stories cached in the archive channel
+func (c *TelegramController) InlineQueryHandler(ctx tele.Context) error {
+	reqCtx := requestContext(ctx)
+	query := ctx.Query()
+	input := strings.TrimSpace(query.Text)
+
+	// 1. Get/Register User
+	user, err := c.UserService.RegisterUser(reqCtx, ctx.Sender())
+	if err != nil {
+		slog.ErrorContext(reqCtx, "Error registering user", "error", err)
+		return ctx.Answer(&tele.QueryResponse{CacheTime: 0, IsPersonal: true})
+	}
+	langCode := user.LanguageCode
+	if langCode == "" {
+		langCode = query.Sender.LanguageCode
+	}
+
+	if !isValidSearchInput(input) {
+		return ctx.Answer(&tele.QueryResponse{
+			CacheTime:  inlineCacheTime,
+			IsPersonal: true,
+			Button:     &tele.QueryResponseButton{Text: i18n.GetMessage(langCode, "inline_hint"), Start: "inline"},
+		})
+	}
+
+	// 2. Check Limits
+	allowed, reason, err := c.UserService.CanUseInline(reqCtx, user)
+	if err != nil {
+		slog.ErrorContext(reqCtx, "Error checking inline limits", "error", err)
+		return ctx.Answer(&tele.QueryResponse{CacheTime: 0, IsPersonal: true})
+	}
+	if !allowed {
+		return ctx.Answer(&tele.QueryResponse{
+			CacheTime:  inlineCacheTime,
+			IsPersonal: true,
+			Button:     &tele.QueryResponseButton{Text: reason, Start: "limit"},
+		})
+	}
+
+	// 3. Look up the archive cache
+	offset, _ := strconv.Atoi(query.Offset)
+	stories, err := c.DownloadService.CachedStories(reqCtx, input, inlinePageSize, offset)
+	if err != nil {
+		slog.ErrorContext(reqCtx, "Error loading cached stories", "error", err)
+		return ctx.Answer(&tele.QueryResponse{CacheTime: 0, IsPersonal: true})
+	}
+
+	// Nothing cached yet: offer to fetch through the bot
+	if len(stories) == 0 && offset == 0 {
+		target := services.NormalizeTarget(input)
+		return ctx.Answer(&tele.QueryResponse{
+			CacheTime:  inlineCacheTime,
+			IsPersonal: true,
+			Button: &tele.QueryResponseButton{
+				Text:  fmt.Sprintf(i18n.GetMessage(langCode, "inline_fetch"), target),
+				Start: inlineFetchPrefix + target,
+			},
+		})
+	}
+
+	// 4. Build cached results
+	loc := c.UserService.SettingsOrDefault(reqCtx, user.ID).Location()
+	results := make(tele.Results, 0, len(stories))
+	for _, st := range stories {
+		storyDate := st.StoryDate.In(loc).Format("2006-01-02 15:04")
+		caption := st.Caption
+		if caption == "" {
+			caption = fmt.Sprintf(i18n.GetMessage(langCode, "story_from"), input)
+		}
+		caption = services.CaptionWithFooter(caption, "\n\n📅 "+storyDate)
+
+		var result tele.Result
+		if st.MediaType == "video" {
+			result = &tele.VideoResult{Cache: st.FileID, Title: storyDate, Caption: caption}
+		} else {
+			result = &tele.PhotoResult{Cache: st.FileID, Title: storyDate, Caption: caption}
+		}
+		result.SetResultID(strconv.Itoa(st.ID))
+		results = append(results, result)
+	}
+
+	nextOffset := ""
+	if len(stories) == inlinePageSize {
+		nextOffset = strconv.Itoa(offset + inlinePageSize)
+	}
+
+	return ctx.Answer(&tele.QueryResponse{
+		Results:    results,
+		CacheTime:  inlineCacheTime,
+		IsPersonal: true,
+		NextOffset: nextOffset,
+	})
+}
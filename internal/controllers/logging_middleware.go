@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/logging"
+	tele "gopkg.in/telebot.v3"
+)
+
+// requestContextKey stores the logging context of an update in tele.Context
+const requestContextKey = "request_ctx"
+
+// LoggingMiddleware gives each update a request ID and logs failed handlers with it
+func (c *TelegramController) LoggingMiddleware(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(ctx tele.Context) error {
+		reqCtx := logging.WithRequestID(context.Background(), "")
+		reqCtx = logging.With(reqCtx, "update_id", ctx.Update().ID)
+		if sender := ctx.Sender(); sender != nil {
+			reqCtx = logging.With(reqCtx, "user_id", sender.ID)
+		}
+		if chat := ctx.Chat(); chat != nil && chat.Type != tele.ChatPrivate {
+			reqCtx = logging.With(reqCtx, "chat_id", chat.ID)
+		}
+		ctx.Set(requestContextKey, reqCtx)
+
+		start := time.Now()
+		err := next(ctx)
+		attrs := []any{"update", c.updateLabel(ctx), "duration_ms", time.Since(start).Milliseconds()}
+		if err != nil {
+			slog.ErrorContext(reqCtx, "Update handler failed", append(attrs, "error", err)...)
+		} else {
+			slog.DebugContext(reqCtx, "Update handled", attrs...)
+		}
+		return err
+	}
+}
+
+// requestContext returns the logging context LoggingMiddleware attached to the update
+func requestContext(ctx tele.Context) context.Context {
+	if reqCtx, ok := ctx.Get(requestContextKey).(context.Context); ok {
+		return reqCtx
+	}
+	return context.Background()
+}
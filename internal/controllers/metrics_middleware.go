@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"strings"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/metrics"
+	tele "gopkg.in/telebot.v3"
+)
+
+// commandKey is the context key under which command handlers record their command
+const commandKey = "command"
+
+// handleCommand registers a command handler. Updates it handles are labelled with the command;
+// other slash commands are "/other" to bound label values.
+func (c *TelegramController) handleCommand(command string, h tele.HandlerFunc) {
+	c.Bot.Handle(command, h, func(next tele.HandlerFunc) tele.HandlerFunc {
+		return func(ctx tele.Context) error {
+			ctx.Set(commandKey, command)
+			return next(ctx)
+		}
+	})
+}
+
+// MetricsMiddleware counts and times every handled update
+func (c *TelegramController) MetricsMiddleware(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(ctx tele.Context) error {
+		start := time.Now()
+		err := next(ctx)
+		command := c.updateLabel(ctx)
+
+		result := "ok"
+		if err != nil {
+			result = "error"
+		}
+		metrics.UpdatesTotal.With(command, result).Inc()
+		metrics.UpdateDuration.With(command).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// updateLabel names the kind of update with a bounded set of values; call it after the handler ran
+func (c *TelegramController) updateLabel(ctx tele.Context) string {
+	switch {
+	case ctx.Callback() != nil:
+		return "callback:" + c.Callbacks.routeName(ctx.Callback().Data)
+	case ctx.Query() != nil:
+		return "inline_query"
+	case ctx.Message() == nil:
+		return "other"
+	}
+
+	msg := ctx.Message()
+	switch {
+	case strings.HasPrefix(msg.Text, "/"):
+		if command, ok := ctx.Get(commandKey).(string); ok {
+			return command
+		}
+		return "/other"
+	case msg.Text != "":
+		return "text"
+	default:
+		return "media"
+	}
+}
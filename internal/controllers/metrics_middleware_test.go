@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/bbr/telestory-api-based/internal/callback"
+	"github.com/bbr/telestory-api-based/internal/metrics"
+	tele "gopkg.in/telebot.v3"
+)
+
+func TestUpdateLabelUsesRegisteredCommands(t *testing.T) {
+	bot, err := tele.NewBot(tele.Settings{Offline: true, Synchronous: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bot.Me.Username = "story_bot"
+	c := &TelegramController{Bot: bot, Callbacks: NewCallbackRouter(callback.NewSigner("test"))}
+	bot.Use(c.MetricsMiddleware)
+	ok := func(tele.Context) error { return nil }
+	c.handleCommand("/start", ok)
+	bot.Handle(tele.OnText, ok)
+
+	for _, tc := range []struct {
+		text, label string
+	}{
+		{"/start", "/start"},
+		{"/start payload", "/start"},
+		{"/unregistered", "/other"},
+		{"/start@story_bot", "/start"},
+		{"hello", "text"},
+	} {
+		before := metrics.UpdatesTotal.With(tc.label, "ok").Value()
+		bot.ProcessUpdate(tele.Update{Message: &tele.Message{Text: tc.text, Chat: &tele.Chat{ID: 1, Type: tele.ChatPrivate}, Sender: &tele.User{ID: 1}}})
+		if got := metrics.UpdatesTotal.With(tc.label, "ok").Value() - before; got != 1 {
+			t.Errorf("%q: counted %v updates as %q, want 1", tc.text, got, tc.label)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package controllers
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/bbr/telestory-api-based/internal/i18n"
+	"github.com/bbr/telestory-api-based/internal/models"
+	tele "gopkg.in/telebot.v3"
+)
+
+// settingsUnique is the callback unique of the /settings menu
+const settingsUnique = "uset"
+
+// Fields of the /settings menu; an empty value opens the field's sub-menu
+const (
+	settingLanguage = "lang"
+	settingStyle    = "style"
+	settingCaption  = "caption"
+	settingArchive  = "archive"
+	settingTimezone = "tz"
+	settingNotify   = "notify"
+	settingBack     = "menu"
+)
+
+// settingsTimezones are offered in the timezone sub-menu
+var settingsTimezones = []string{
+	"UTC",
+	"Asia/Tashkent",
+	"Asia/Almaty",
+	"Europe/Moscow",
+	"Europe/Istanbul",
+	"Asia/Dubai",
+	"Europe/London",
+	"Europe/Berlin",
+	"America/New_York",
+	"America/Los_Angeles",
+}
+
+// settingsPayload is the callback payload of the /settings menu
+type settingsPayload struct {
+	Field string
+	Value string
+}
+
+func (p *settingsPayload) Fields() []string {
+	return []string{p.Field, p.Value}
+}
+
+func (p *settingsPayload) Parse(fields []string) error {
+	if len(fields) != 2 {
+		return fmt.Errorf("settings payload: expected 2 fields, got %d", len(fields))
+	}
+	p.Field, p.Value = fields[0], fields[1]
+	return nil
+}
+
+func (c *TelegramController) SettingsHandler(ctx tele.Context) error {
+	reqCtx := requestContext(ctx)
+	user, err := c.UserService.RegisterUser(reqCtx, ctx.Sender())
+	if err != nil {
+		slog.ErrorContext(reqCtx, "Error registering user", "error", err)
+		return ctx.Send("An error occurred. Please try again.")
+	}
+
+	if user.LanguageCode == "" {
+		return c.showLanguageMenu(ctx)
+	}
+
+	settings, err := c.UserService.GetSettings(reqCtx, user.ID)
+	if err != nil {
+		slog.ErrorContext(reqCtx, "Error loading settings", "error", err)
+		return ctx.Send("An error occurred. Please try again.")
+	}
+
+	text, menu, err := c.settingsMenu(user, settings)
+	if err != nil {
+		return err
+	}
+	return ctx.Send(text, menu, tele.ModeMarkdown)
+}
+
+func (c *TelegramController) SettingsCallback(ctx tele.Context, payload *settingsPayload) error {
+	reqCtx := requestContext(ctx)
+	user, err := c.UserService.RegisterUser(reqCtx, ctx.Sender())
+	if err != nil {
+		slog.ErrorContext(reqCtx, "Error registering user", "error", err)
+		return ctx.Respond(&tele.CallbackResponse{Text: "Error updating settings"})
+	}
+
+	settings, err := c.UserService.GetSettings(reqCtx, user.ID)
+	if err != nil {
+		slog.ErrorContext(reqCtx, "Error loading settings", "error", err)
+		return ctx.Respond(&tele.CallbackResponse{Text: "Error updating settings"})
+	}
+
+	// Sub-menus
+	if payload.Value == "" && (payload.Field == settingLanguage || payload.Field == settingTimezone) {
+		ctx.Respond(&tele.CallbackResponse{})
+		text, menu, err := c.settingsSubMenu(user.LanguageCode, payload.Field)
+		if err != nil {
+			return err
+		}
+		_, err = c.Bot.Edit(ctx.Message(), text, menu, tele.ModeMarkdown)
+		return err
+	}
+
+	switch payload.Field {
+	case settingLanguage:
+		if _, ok := i18n.Locales[payload.Value]; !ok {
+			return ctx.Respond()
+		}
+		if err := c.UserService.UpdateLanguage(reqCtx, user.ID, payload.Value); err != nil {
+			slog.ErrorContext(reqCtx, "Error updating language", "error", err)
+			return ctx.Respond(&tele.CallbackResponse{Text: "Error updating language"})
+		}
+		user.LanguageCode = payload.Value
+	case settingStyle:
+		settings.DeliveryStyle = payload.Value
+	case settingCaption:
+		settings.CaptionFormat = payload.Value
+	case settingArchive:
+		settings.IncludeArchive = payload.Value == "1"
+	case settingTimezone:
+		settings.Timezone = payload.Value
+	case settingNotify:
+		settings.Notifications = payload.Value == "1"
+	}
+
+	if payload.Field != settingLanguage && payload.Field != settingBack {
+		if err := c.UserService.UpdateSettings(reqCtx, settings); err != nil {
+			slog.ErrorContext(reqCtx, "Error updating settings", "error", err)
+			return ctx.Respond(&tele.CallbackResponse{Text: "Error updating settings"})
+		}
+	}
+
+	if payload.Field == settingBack {
+		ctx.Respond(&tele.CallbackResponse{})
+	} else {
+		ctx.Respond(&tele.CallbackResponse{Text: i18n.GetMessage(user.LanguageCode, "settings_saved")})
+	}
+
+	text, menu, err := c.settingsMenu(user, settings)
+	if err != nil {
+		return err
+	}
+	_, err = c.Bot.Edit(ctx.Message(), text, menu, tele.ModeMarkdown)
+	if err == tele.ErrSameMessageContent {
+		return nil
+	}
+	return err
+}
+
+// settingsMenu renders the current settings with one button per field
+func (c *TelegramController) settingsMenu(user *models.User, settings *models.UserSettings) (string, *tele.ReplyMarkup, error) {
+	lang := user.LanguageCode
+	onOff := func(on bool) string {
+		if on {
+			return i18n.GetMessage(lang, "settings_on")
+		}
+		return i18n.GetMessage(lang, "settings_off")
+	}
+
+	text := fmt.Sprintf(i18n.GetMessage(lang, "settings_title"),
+		i18n.LanguageNames[lang],
+		i18n.GetMessage(lang, "settings_style_"+settings.DeliveryStyle),
+		i18n.GetMessage(lang, "settings_caption_"+settings.CaptionFormat),
+		onOff(settings.IncludeArchive),
+		settings.Timezone,
+		onOff(settings.Notifications),
+	)
+
+	nextStyle := models.DeliveryStyleAlbum
+	if settings.DeliveryStyle == models.DeliveryStyleAlbum {
+		nextStyle = models.DeliveryStyleIndividual
+	}
+
+	nextCaption := models.CaptionFull
+	switch settings.CaptionFormat {
+	case models.CaptionFull:
+		nextCaption = models.CaptionDate
+	case models.CaptionDate:
+		nextCaption = models.CaptionNone
+	}
+
+	flip := func(on bool) string {
+		if on {
+			return "0"
+		}
+		return "1"
+	}
+
+	menu := &tele.ReplyMarkup{}
+	kb := c.Callbacks.Signer.Keyboard()
+	btn := func(key, field, value string) tele.Btn {
+		return kb.Button(i18n.GetMessage(lang, key), settingsUnique, &settingsPayload{Field: field, Value: value})
+	}
+	menu.Inline(
+		menu.Row(btn("settings_btn_language", settingLanguage, "")),
+		menu.Row(btn("settings_btn_style", settingStyle, nextStyle)),
+		menu.Row(btn("settings_btn_caption", settingCaption, nextCaption)),
+		menu.Row(btn("settings_btn_archive", settingArchive, flip(settings.IncludeArchive))),
+		menu.Row(btn("settings_btn_timezone", settingTimezone, "")),
+		menu.Row(btn("settings_btn_notify", settingNotify, flip(settings.Notifications))),
+	)
+	return text, menu, kb.Err()
+}
+
+// settingsSubMenu renders the language or timezone choices
+func (c *TelegramController) settingsSubMenu(lang, field string) (string, *tele.ReplyMarkup, error) {
+	menu := &tele.ReplyMarkup{}
+	kb := c.Callbacks.Signer.Keyboard()
+	var rows []tele.Row
+	var text string
+
+	switch field {
+	case settingLanguage:
+		text = i18n.GetMessage(lang, "settings_choose_language")
+		for _, code := range i18n.SupportedLanguages {
+			btn := kb.Button(i18n.LanguageNames[code], settingsUnique, &settingsPayload{Field: settingLanguage, Value: code})
+			rows = append(rows, menu.Row(btn))
+		}
+	case settingTimezone:
+		text = i18n.GetMessage(lang, "settings_choose_timezone")
+		for i := 0; i < len(settingsTimezones); i += 2 {
+			row := tele.Row{}
+			for _, tz := range settingsTimezones[i:min(i+2, len(settingsTimezones))] {
+				row = append(row, kb.Button(tz, settingsUnique, &settingsPayload{Field: settingTimezone, Value: tz}))
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	back := kb.Button(i18n.GetMessage(lang, "settings_back"), settingsUnique, &settingsPayload{Field: settingBack})
+	rows = append(rows, menu.Row(back))
+	menu.Inline(rows...)
+	return text, menu, kb.Err()
+}
@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/services"
+)
+
+// statsView reads the range of a stats request from ?view=today|7d|30d or ?from=YYYY-MM-DD[&to=YYYY-MM-DD];
+// the default is the last 7 days
+func statsView(r *http.Request) (services.StatsView, error) {
+	q := r.URL.Query()
+	var args []string
+	switch {
+	case q.Get("from") != "":
+		args = append(args, q.Get("from"))
+		if to := q.Get("to"); to != "" {
+			args = append(args, to)
+		}
+	case q.Get("view") != "":
+		args = append(args, q.Get("view"))
+	}
+	return services.ParseStatsView(args, time.Now())
+}
+
+// queryInt reads a positive integer parameter, clamped to max
+func queryInt(r *http.Request, key string, def, max int) (int, bool) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, false
+	}
+	return min(n, max), true
+}
+
+// viewJSON is the range part of every ranged stats response
+func viewJSON(view services.StatsView) map[string]any {
+	return map[string]any{
+		"view": view.Key(),
+		"from": view.From.Format(time.DateOnly),
+		"to":   view.To.Format(time.DateOnly),
+	}
+}
+
+func (c *HTTPController) statsFailed(w http.ResponseWriter, r *http.Request, err error) {
+	slog.ErrorContext(r.Context(), "Failed to get stats", "path", r.URL.Path, "error", err)
+	writeError(w, http.StatusInternalServerError, "failed to fetch analytics")
+}
+
+// StatsDaily returns the daily series and totals of a range
+func (c *HTTPController) StatsDaily(w http.ResponseWriter, r *http.Request) {
+	view, err := statsView(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	stats, err := c.Analytics.Stats(r.Context(), view)
+	if err != nil {
+		c.statsFailed(w, r, err)
+		return
+	}
+
+	resp := viewJSON(view)
+	resp["days"] = stats.Days
+	resp["totals"] = stats.Totals()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// StatsTargets returns the most requested targets of a range, ?limit= up to services.MaxTopTargets
+func (c *HTTPController) StatsTargets(w http.ResponseWriter, r *http.Request) {
+	view, err := statsView(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit, ok := queryInt(r, "limit", services.DefaultTopTargets, services.MaxTopTargets)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "limit must be a positive integer")
+		return
+	}
+	targets, err := c.Analytics.TopTargets(r.Context(), view, limit)
+	if err != nil {
+		c.statsFailed(w, r, err)
+		return
+	}
+
+	resp := viewJSON(view)
+	resp["targets"] = targets
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// StatsLanguages returns all users by language
+func (c *HTTPController) StatsLanguages(w http.ResponseWriter, r *http.Request) {
+	languages, err := c.Analytics.Languages(r.Context())
+	if err != nil {
+		c.statsFailed(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"languages": languages})
+}
+
+// StatsFunnel returns the conversion of the users who signed up in a range
+func (c *HTTPController) StatsFunnel(w http.ResponseWriter, r *http.Request) {
+	view, err := statsView(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	funnel, err := c.Analytics.Funnel(r.Context(), view)
+	if err != nil {
+		c.statsFailed(w, r, err)
+		return
+	}
+
+	resp := viewJSON(view)
+	resp["funnel"] = funnel
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// StatsRetention returns weekly signup cohorts, ?weeks= up to services.MaxRetentionWeeks
+func (c *HTTPController) StatsRetention(w http.ResponseWriter, r *http.Request) {
+	weeks, ok := queryInt(r, "weeks", services.DefaultRetentionWeeks, services.MaxRetentionWeeks)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "weeks must be a positive integer")
+		return
+	}
+	cohorts, err := c.Analytics.Retention(r.Context(), weeks)
+	if err != nil {
+		c.statsFailed(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"weeks": weeks, "cohorts": cohorts})
+}
@@ -0,0 +1,273 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/i18n"
+	"github.com/bbr/telestory-api-based/internal/models"
+	"github.com/bbr/telestory-api-based/internal/services"
+	tele "gopkg.in/telebot.v3"
+)
+
+// statsUnique is the callback unique of the /stats buttons
+const statsUnique = "stats"
+
+// statsCharts is the report of the daily charts and summary
+const statsCharts = "charts"
+
+// statsPayload is the callback payload of the /stats buttons
+type statsPayload struct {
+	Report string // statsCharts or one of services.StatsReports
+	View   string // services.StatsView key
+}
+
+func (p *statsPayload) Fields() []string {
+	return []string{p.Report, p.View}
+}
+
+func (p *statsPayload) Parse(fields []string) error {
+	if len(fields) != 2 {
+		return fmt.Errorf("stats payload: expected 2 fields, got %d", len(fields))
+	}
+	if fields[0] != statsCharts && !slices.Contains(services.StatsReports, fields[0]) {
+		return fmt.Errorf("stats payload: unknown report %q", fields[0])
+	}
+	p.Report, p.View = fields[0], fields[1]
+	return nil
+}
+
+// StatsHandler handles "/stats [report] [today|7d|30d]" and "/stats [report] <from> [to]" with YYYY-MM-DD dates
+func (c *TelegramController) StatsHandler(ctx tele.Context) error {
+	user, ok := c.adminUser(ctx)
+	if !ok {
+		return nil
+	}
+
+	args := ctx.Args()
+	report := statsCharts
+	if len(args) > 0 && slices.Contains(services.StatsReports, args[0]) {
+		report, args = args[0], args[1:]
+	}
+	view, err := services.ParseStatsView(args, time.Now())
+	if err != nil {
+		return ctx.Send(fmt.Sprintf(i18n.GetMessage(user.LanguageCode, "stats_usage"), services.MaxStatsDays), tele.ModeMarkdown)
+	}
+	if report == statsCharts {
+		return c.sendStats(ctx, user, view)
+	}
+	return c.sendStatsReport(ctx, user, report, view)
+}
+
+// StatsCallback switches the charts to another view, replacing the old summary so its buttons
+// stay at the bottom, or sends a report for the view of the summary
+func (c *TelegramController) StatsCallback(ctx tele.Context, payload *statsPayload) error {
+	user, ok := c.adminUser(ctx)
+	if !ok {
+		return ctx.Respond()
+	}
+	ctx.Respond()
+
+	view, err := services.ParseStatsKey(payload.View, time.Now())
+	if err != nil {
+		return err
+	}
+	if payload.Report != statsCharts {
+		return c.sendStatsReport(ctx, user, payload.Report, view)
+	}
+	if err := ctx.Delete(); err != nil {
+		slog.WarnContext(requestContext(ctx), "Failed to delete stats summary", "error", err)
+	}
+	return c.sendStats(ctx, user, view)
+}
+
+// sendStats sends the charts of a view as an album, followed by the summary with the view buttons
+func (c *TelegramController) sendStats(ctx tele.Context, user *models.User, view services.StatsView) error {
+	reqCtx := requestContext(ctx)
+	lang := user.LanguageCode
+
+	stats, err := c.AnalyticsService.Stats(reqCtx, view)
+	if err != nil {
+		slog.ErrorContext(reqCtx, "Failed to get stats", "error", err)
+		return ctx.Send("Failed to fetch analytics.")
+	}
+	charts, err := c.AnalyticsService.Charts(stats)
+	if err != nil {
+		slog.ErrorContext(reqCtx, "Failed to render stats charts", "error", err)
+		return ctx.Send("Failed to fetch analytics.")
+	}
+
+	album := make(tele.Album, 0, len(charts))
+	for _, chart := range charts {
+		album = append(album, &tele.Photo{
+			File:    tele.FromReader(bytes.NewReader(chart.PNG)),
+			Caption: i18n.GetMessage(lang, "stats_chart_"+chart.Series),
+		})
+	}
+	if _, err := ctx.Bot().SendAlbum(ctx.Recipient(), album); err != nil {
+		slog.ErrorContext(reqCtx, "Failed to send stats charts", "error", err)
+	}
+
+	totals := stats.Totals()
+	successRate := "—"
+	if rate, ok := totals.SuccessRate(); ok {
+		successRate = fmt.Sprintf("%.1f%%", rate)
+	}
+	text := fmt.Sprintf(i18n.GetMessage(lang, "stats_summary"),
+		statsViewTitle(lang, view),
+		totals.NewUsers, totals.ActiveUsers, totals.Requests, successRate, totals.StoriesDelivered)
+
+	menu := &tele.ReplyMarkup{}
+	kb := c.Callbacks.Signer.Keyboard()
+	views, reports := tele.Row{}, tele.Row{}
+	for _, name := range services.StatsViews {
+		label := i18n.GetMessage(lang, "stats_view_"+name)
+		if name == view.Name {
+			label = "• " + label
+		}
+		views = append(views, kb.Button(label, statsUnique, &statsPayload{Report: statsCharts, View: name}))
+	}
+	for _, report := range services.StatsReports {
+		reports = append(reports, kb.Button(i18n.GetMessage(lang, "stats_btn_"+report), statsUnique, &statsPayload{Report: report, View: view.Key()}))
+	}
+	if err := kb.Err(); err != nil {
+		return err
+	}
+	menu.Inline(views, reports)
+
+	return ctx.Send(text, menu, tele.ModeMarkdown)
+}
+
+// statsViewTitle names a view with its dates
+func statsViewTitle(lang string, view services.StatsView) string {
+	dates := view.From.Format(time.DateOnly)
+	if !view.To.Equal(view.From) {
+		dates += " – " + view.To.Format(time.DateOnly)
+	}
+	if view.Name == "" {
+		return dates
+	}
+	return fmt.Sprintf("%s (%s)", i18n.GetMessage(lang, "stats_view_"+view.Name), dates)
+}
+
+// sendStatsReport sends one of services.StatsReports as text; languages and retention ignore the view
+func (c *TelegramController) sendStatsReport(ctx tele.Context, user *models.User, report string, view services.StatsView) error {
+	reqCtx := requestContext(ctx)
+	lang := user.LanguageCode
+
+	var text string
+	var err error
+	switch report {
+	case services.StatsTargets:
+		text, err = c.targetsReport(reqCtx, lang, view)
+	case services.StatsLanguages:
+		text, err = c.languagesReport(reqCtx, lang)
+	case services.StatsFunnel:
+		text, err = c.funnelReport(reqCtx, lang, view)
+	case services.StatsRetention:
+		text, err = c.retentionReport(reqCtx, lang)
+	default:
+		err = fmt.Errorf("unknown stats report %q", report)
+	}
+	if err != nil {
+		slog.ErrorContext(reqCtx, "Failed to get stats report", "report", report, "error", err)
+		return ctx.Send("Failed to fetch analytics.")
+	}
+	return ctx.Send(text, tele.ModeMarkdown)
+}
+
+func (c *TelegramController) targetsReport(reqCtx context.Context, lang string, view services.StatsView) (string, error) {
+	targets, err := c.AnalyticsService.TopTargets(reqCtx, view, services.DefaultTopTargets)
+	if err != nil {
+		return "", err
+	}
+
+	lines := []string{fmt.Sprintf(i18n.GetMessage(lang, "stats_targets_title"), statsViewTitle(lang, view)), ""}
+	if len(targets) == 0 {
+		lines = append(lines, i18n.GetMessage(lang, "stats_empty"))
+	}
+	for i, t := range targets {
+		lines = append(lines, fmt.Sprintf(i18n.GetMessage(lang, "stats_targets_line"),
+			i+1, strings.ReplaceAll(t.Target, "`", "'"), t.Requests, t.Requesters, percent(t.Succeeded, t.Requests)))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func (c *TelegramController) languagesReport(reqCtx context.Context, lang string) (string, error) {
+	languages, err := c.AnalyticsService.Languages(reqCtx)
+	if err != nil {
+		return "", err
+	}
+
+	total := 0
+	for _, l := range languages {
+		total += l.Users
+	}
+	lines := []string{i18n.GetMessage(lang, "stats_languages_title"), ""}
+	if len(languages) == 0 {
+		lines = append(lines, i18n.GetMessage(lang, "stats_empty"))
+	}
+	for _, l := range languages {
+		name, ok := i18n.LanguageNames[l.LanguageCode]
+		switch {
+		case l.LanguageCode == "":
+			name = i18n.GetMessage(lang, "stats_language_none")
+		case !ok:
+			name = "`" + l.LanguageCode + "`"
+		}
+		lines = append(lines, fmt.Sprintf("%s — %d (%s)", name, l.Users, percent(l.Users, total)))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func (c *TelegramController) funnelReport(reqCtx context.Context, lang string, view services.StatsView) (string, error) {
+	f, err := c.AnalyticsService.Funnel(reqCtx, view)
+	if err != nil {
+		return "", err
+	}
+
+	step := func(n int) string {
+		return fmt.Sprintf("%d (%s)", n, percent(n, f.Started))
+	}
+	return fmt.Sprintf(i18n.GetMessage(lang, "stats_funnel"), statsViewTitle(lang, view),
+		f.Started, step(f.ChoseLanguage), step(f.FirstSearch), step(f.FirstSuccess), step(f.Premium)), nil
+}
+
+func (c *TelegramController) retentionReport(reqCtx context.Context, lang string) (string, error) {
+	cohorts, err := c.AnalyticsService.Retention(reqCtx, services.DefaultRetentionWeeks)
+	if err != nil {
+		return "", err
+	}
+
+	title := fmt.Sprintf(i18n.GetMessage(lang, "stats_retention_title"), services.DefaultRetentionWeeks)
+	if len(cohorts) == 0 {
+		return title + "\n\n" + i18n.GetMessage(lang, "stats_empty"), nil
+	}
+
+	// A fixed-width table: one row per signup week, one column per week since signup
+	rows := []string{fmt.Sprintf("%-10s %6s ", "week", "users")}
+	for w := range cohorts[0].Retained {
+		rows[0] += fmt.Sprintf(" %4s", fmt.Sprintf("w%d", w))
+	}
+	for _, cohort := range cohorts {
+		row := fmt.Sprintf("%s %6d ", cohort.Week.Format(time.DateOnly), cohort.Users)
+		for _, n := range cohort.Retained {
+			row += fmt.Sprintf(" %4s", percent(n, cohort.Users))
+		}
+		rows = append(rows, row)
+	}
+	return title + "\n\n```\n" + strings.Join(rows, "\n") + "\n```", nil
+}
+
+// percent formats part of whole without decimals, or a dash when whole is zero
+func percent(part, whole int) string {
+	if whole == 0 {
+		return "—"
+	}
+	return fmt.Sprintf("%.0f%%", float64(part)*100/float64(whole))
+}
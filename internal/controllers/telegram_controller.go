@@ -1,39 +1,75 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
 	"strings"
+	"time"
 
 	"github.com/bbr/telestory-api-based/internal/i18n"
+	"github.com/bbr/telestory-api-based/internal/logging"
 	"github.com/bbr/telestory-api-based/internal/services"
 	tele "gopkg.in/telebot.v3"
 )
 
 type TelegramController struct {
 	Bot              *tele.Bot
+	Runtime          *services.RuntimeSettings
+	Callbacks        *CallbackRouter
 	UserService      *services.UserService
 	DownloadService  *services.DownloadService
 	LogService       *services.LogService
 	AnalyticsService *services.AnalyticsService
+	ExportService    *services.ExportService
+	AdminKeyService  *services.AdminKeyService
+	ChatService      *services.ChatService
 }
 
-func NewTelegramController(bot *tele.Bot, userService *services.UserService, downloadService *services.DownloadService, logService *services.LogService, analyticsService *services.AnalyticsService) *TelegramController {
+func NewTelegramController(bot *tele.Bot, runtime *services.RuntimeSettings, callbacks *CallbackRouter, userService *services.UserService, downloadService *services.DownloadService, logService *services.LogService, analyticsService *services.AnalyticsService, exportService *services.ExportService, adminKeyService *services.AdminKeyService, chatService *services.ChatService) *TelegramController {
 	return &TelegramController{
 		Bot:              bot,
+		Runtime:          runtime,
+		Callbacks:        callbacks,
 		UserService:      userService,
 		DownloadService:  downloadService,
 		LogService:       logService,
 		AnalyticsService: analyticsService,
+		ExportService:    exportService,
+		AdminKeyService:  adminKeyService,
+		ChatService:      chatService,
 	}
 }
 
 func (c *TelegramController) SetupHandlers() {
-	c.Bot.Handle("/start", c.StartHandler)
-	c.Bot.Handle("/stats", c.StatsHandler)
+	// Must come before Handle, telebot applies middleware at registration
+	c.Bot.Use(c.LoggingMiddleware, c.MetricsMiddleware)
+
+	c.handleCommand("/start", c.StartHandler)
+	c.handleCommand("/stats", c.StatsHandler)
+	c.handleCommand("/story", c.StoryCommandHandler)
+	c.handleCommand("/groupsettings", c.GroupSettingsHandler)
+	c.handleCommand("/settings", c.SettingsHandler)
+	c.handleCommand("/history", c.HistoryHandler)
+	c.handleCommand("/set", c.SetHandler)
+	c.handleCommand("/get", c.GetHandler)
+	c.handleCommand("/settings_dump", c.SettingsDumpHandler)
+	c.handleCommand("/rollup", c.RollupHandler)
+	c.handleCommand("/export", c.ExportHandler)
+	c.handleCommand("/apikey", c.APIKeyHandler)
 	c.Bot.Handle(tele.OnText, c.TextHandler)
-	c.Bot.Handle(tele.OnCallback, c.LanguageCallback)
+	c.Bot.Handle(tele.OnQuery, c.InlineQueryHandler)
+	c.Bot.Handle(tele.OnCallback, c.Callbacks.Dispatch)
+
+	// Inline button routes
+	c.Callbacks.Stale = c.StaleCallback
+	HandleCallback(c.Callbacks, languageUnique, CallbackRoute{AllowLegacy: true}, c.LanguageCallback)
+	HandleCallback(c.Callbacks, services.StoryModeUnique, CallbackRoute{MaxAge: services.SelectionTTL}, c.StoryModeCallback)
+	HandleCallback(c.Callbacks, services.StoryPickerUnique, CallbackRoute{MaxAge: services.SelectionTTL}, c.StoryPickerCallback)
+	HandleCallback(c.Callbacks, groupSettingsUnique, CallbackRoute{MaxAge: time.Hour}, c.GroupSettingsCallback)
+	HandleCallback(c.Callbacks, settingsUnique, CallbackRoute{MaxAge: 24 * time.Hour}, c.SettingsCallback)
+	HandleCallback(c.Callbacks, historyUnique, CallbackRoute{MaxAge: 24 * time.Hour}, c.HistoryCallback)
+	HandleCallback(c.Callbacks, statsUnique, CallbackRoute{MaxAge: 24 * time.Hour}, c.StatsCallback)
 
 	// Unsupported inputs
 	c.Bot.Handle(tele.OnPhoto, c.UnsupportedHandler)
@@ -41,23 +77,48 @@ func (c *TelegramController) SetupHandlers() {
 	c.Bot.Handle(tele.OnDocument, c.UnsupportedHandler)
 }
 
+// languageUnique is the callback unique of the language menu
+const languageUnique = "lang"
+
+// languagePayload is the callback payload of the language menu
+type languagePayload struct {
+	Lang string
+}
+
+func (p *languagePayload) Fields() []string {
+	return []string{p.Lang}
+}
+
+func (p *languagePayload) Parse(fields []string) error {
+	if len(fields) != 1 {
+		return fmt.Errorf("language payload: expected 1 field, got %d", len(fields))
+	}
+	p.Lang = fields[0]
+	return nil
+}
+
 func (c *TelegramController) showLanguageMenu(ctx tele.Context) error {
 	menu := &tele.ReplyMarkup{}
-	btnEn := menu.Data("🇺🇸 English", "lang", "en")
-	btnUz := menu.Data("🇺🇿 O'zbek", "lang", "uz")
-	btnRu := menu.Data("🇷🇺 Русский", "lang", "ru")
-
-	menu.Inline(
-		menu.Row(btnEn),
-		menu.Row(btnUz),
-		menu.Row(btnRu),
-	)
+	kb := c.Callbacks.Signer.Keyboard()
+	var rows []tele.Row
+	for _, lang := range i18n.SupportedLanguages {
+		rows = append(rows, menu.Row(kb.Button(i18n.LanguageNames[lang], languageUnique, &languagePayload{Lang: lang})))
+	}
+	if err := kb.Err(); err != nil {
+		return err
+	}
+	menu.Inline(rows...)
 	return ctx.Send(i18n.GetMessage("en", "welcome"), menu)
 }
 
 func (c *TelegramController) UnsupportedHandler(ctx tele.Context) error {
+	// Media in groups is not meant for the bot
+	if services.IsGroup(ctx.Chat()) {
+		return nil
+	}
+
 	teleUser := ctx.Sender()
-	user, err := c.UserService.RegisterUser(teleUser)
+	user, err := c.UserService.RegisterUser(requestContext(ctx), teleUser)
 	if err != nil {
 		return nil
 	}
@@ -68,14 +129,18 @@ func (c *TelegramController) UnsupportedHandler(ctx tele.Context) error {
 }
 
 func (c *TelegramController) StartHandler(ctx tele.Context) error {
+	reqCtx := requestContext(ctx)
+	if services.IsGroup(ctx.Chat()) {
+		return c.groupInstruction(ctx)
+	}
+
 	teleUser := ctx.Sender()
 
 	// Log new user to admin channel
 	c.LogService.LogNewUser(teleUser)
 
 	// Register user first
-	log.Println("user: ", teleUser)
-	user, err := c.UserService.RegisterUser(teleUser)
+	user, err := c.UserService.RegisterUser(reqCtx, teleUser)
 	if err != nil {
 		return ctx.Send("Welcome!")
 	}
@@ -84,31 +149,30 @@ func (c *TelegramController) StartHandler(ctx tele.Context) error {
 		return c.showLanguageMenu(ctx)
 	}
 
+	// Deep link from the inline mode "open bot to fetch" button
+	if target, ok := strings.CutPrefix(ctx.Message().Payload, inlineFetchPrefix); ok && target != "" {
+		return c.handleSearch(ctx, target)
+	}
+
 	// 2. If Language IS set, show instructions directly
 	msg := i18n.GetMessage(user.LanguageCode, "instruction")
 	return ctx.Send(msg, &tele.SendOptions{ParseMode: tele.ModeMarkdown})
 }
 
-func (c *TelegramController) LanguageCallback(ctx tele.Context) error {
-	// Get the language code from callback data
-	// Callback data format is "unique|data", e.g., "lang|uz"
-	callbackData := ctx.Callback().Data
-	parts := strings.Split(callbackData, "|")
+func (c *TelegramController) LanguageCallback(ctx tele.Context, payload *languagePayload) error {
+	reqCtx := requestContext(ctx)
+	langCode := payload.Lang
+	userID := ctx.Sender().ID
 
-	var langCode string
-	if len(parts) == 2 {
-		langCode = parts[1] // Extract "uz" from "lang|uz"
-	} else {
-		langCode = callbackData // Fallback if format is different
+	if _, ok := i18n.Locales[langCode]; !ok {
+		return ctx.Respond()
 	}
 
-	userID := ctx.Sender().ID
-
-	log.Printf("Language selected: %s for user %d", langCode, userID)
+	slog.InfoContext(reqCtx, "Language selected", "language", langCode)
 
 	// 1. Update Lang in DB
-	if err := c.UserService.UpdateLanguage(userID, langCode); err != nil {
-		log.Printf("Error updating language: %v", err)
+	if err := c.UserService.UpdateLanguage(reqCtx, userID, langCode); err != nil {
+		slog.ErrorContext(reqCtx, "Error updating language", "error", err)
 		return ctx.Respond(&tele.CallbackResponse{Text: "Error updating language"})
 	}
 
@@ -143,85 +207,120 @@ func isValidSearchInput(input string) bool {
 }
 
 func (c *TelegramController) TextHandler(ctx tele.Context) error {
-	input := ctx.Text()
+	if services.IsGroup(ctx.Chat()) {
+		return c.GroupTextHandler(ctx)
+	}
+	return c.handleSearch(ctx, ctx.Text())
+}
+
+// handleSearch runs a story search for input on behalf of the sender
+func (c *TelegramController) handleSearch(ctx tele.Context, input string) error {
+	reqCtx := requestContext(ctx)
 	teleUser := ctx.Sender()
 
 	// Log the search request
 	c.LogService.LogSearchRequest(teleUser, input)
 
 	// 1. Get/Register User (Ensure we have latest data)
-	user, err := c.UserService.RegisterUser(teleUser)
+	user, err := c.UserService.RegisterUser(reqCtx, teleUser)
 	if err != nil {
-		log.Printf("Error registering user: %v", err)
+		slog.ErrorContext(reqCtx, "Error registering user", "error", err)
 		return ctx.Send("An error occurred. Please try again.")
 	}
 
 	if user.LanguageCode == "" {
-		return c.showLanguageMenu(ctx)
+		if !services.IsGroup(ctx.Chat()) {
+			return c.showLanguageMenu(ctx)
+		}
+		// Don't block the group on a language menu, answer in the member's Telegram language
+		user.LanguageCode = groupLanguage(teleUser)
 	}
 
-	if os.Getenv("MAINTENANCE") == "true" {
+	if c.Runtime.Maintenance() {
 		return ctx.Send(i18n.GetMessage(user.LanguageCode, "maintenance"))
 	}
 
-	if !isValidSearchInput(input) {
+	// A story link asks for one specific story
+	req := services.StoryRequest{Input: input}
+	if username, storyID, ok := services.ParseStoryLink(input); ok {
+		req = services.StoryRequest{Input: username, StoryID: storyID}
+	} else if !isValidSearchInput(input) {
 		return ctx.Send(i18n.GetMessage(user.LanguageCode, "invalid_input"))
 	}
+	reqCtx = logging.With(reqCtx, "target", req.Input)
 
-	// 2. Check Limits
-	allowed, reason, err := c.UserService.CanDownload(user)
+	// 2. Check limits and reserve a slot (also records activity)
+	reservationID, reason, err := c.UserService.ReserveDownload(reqCtx, user)
 	if err != nil {
-		log.Printf("Error checking limits: %v", err)
+		slog.ErrorContext(reqCtx, "Error checking limits", "error", err)
 		return ctx.Send("System error checking limits.")
 	}
-	if !allowed {
+	if reason != "" {
 		return ctx.Send("🚫 " + reason)
 	}
+	req.ReservationID = reservationID
 
 	// 3. Send Processing Message (localized)
-	processingMsg := i18n.GetMessage(user.LanguageCode, "processing")
-	sentMsg, err := c.Bot.Send(teleUser, processingMsg)
+	sentMsg, err := c.sendProcessing(ctx, user, &req)
 	if err != nil {
-		log.Printf("Error sending processing message: %v", err)
+		c.DownloadService.ReleaseReservation(reqCtx, req)
+		slog.ErrorContext(reqCtx, "Error sending processing message", "error", err)
 		return ctx.Send("An error occurred.")
 	}
-
-	// 4. Record Activity
-	if err := c.UserService.RecordActivity(user.ID); err != nil {
-		log.Printf("Error recording activity: %v", err)
+	if sentMsg == nil {
+		c.DownloadService.ReleaseReservation(reqCtx, req)
+		return nil
 	}
 
-	// 5. Process Download (will edit the sentMsg with result)
-	if err := c.DownloadService.ProcessDownloadWithEdit(c.Bot, sentMsg, user, input); err != nil {
-		log.Printf("Error processing download: %v", err)
+	// 4. Process Download (will edit the sentMsg with result)
+	if err := c.DownloadService.ProcessDownloadWithEdit(reqCtx, c.Bot, sentMsg, user, req); err != nil {
+		slog.ErrorContext(reqCtx, "Error processing download", "error", err)
+		c.LogService.LogFailure(teleUser, input, err)
 		return err
 	}
 
 	return nil
 }
 
-func (c *TelegramController) StatsHandler(ctx tele.Context) error {
-	teleUser := ctx.Sender()
+// StaleCallback answers buttons that are expired, forged or no longer routed
+func (c *TelegramController) StaleCallback(ctx tele.Context) error {
+	langCode := c.senderLanguage(requestContext(ctx), ctx.Sender())
+	return ctx.Respond(&tele.CallbackResponse{Text: i18n.GetMessage(langCode, "callback_expired"), ShowAlert: true})
+}
 
-	// 1. Get/Register User
-	user, err := c.UserService.RegisterUser(teleUser)
-	if err != nil {
-		log.Printf("Error registering user: %v", err)
-		return ctx.Send("An error occurred. Please try again.")
-	}
+func (c *TelegramController) StoryModeCallback(ctx tele.Context, payload *services.StoryModePayload) error {
+	// Respond to callback (removes loading state)
+	ctx.Respond(&tele.CallbackResponse{})
 
-	// 2. Check if user is an admin
-	if user.Role != "admin" {
-		log.Printf("User %d (role: %s) attempted to access /stats but was denied.", user.ID, user.Role)
-		return nil // Ignore silently
-	}
+	err := c.DownloadService.ProcessStoryMode(requestContext(ctx), c.Bot, ctx.Message(), ctx.Sender().ID, payload.Token, payload.Mode)
+	return c.handleSelectionError(ctx, err)
+}
+
+func (c *TelegramController) StoryPickerCallback(ctx tele.Context, payload *services.StoryPickerPayload) error {
+	// Respond to callback (removes loading state)
+	ctx.Respond(&tele.CallbackResponse{})
+
+	err := c.DownloadService.ProcessPickerAction(requestContext(ctx), c.Bot, ctx.Message(), ctx.Sender().ID, payload.Token, payload.Action, payload.Arg)
+	return c.handleSelectionError(ctx, err)
+}
 
-	// 3. Fetch analytics report
-	report, err := c.AnalyticsService.GetOverallStats(user.LanguageCode)
+// handleSelectionError replaces a stale story keyboard with an explanation
+func (c *TelegramController) handleSelectionError(ctx tele.Context, err error) error {
+	reqCtx := requestContext(ctx)
+	if errors.Is(err, services.ErrSelectionForbidden) {
+		return nil
+	}
+	if errors.Is(err, services.ErrSelectionExpired) {
+		user, regErr := c.UserService.RegisterUser(reqCtx, ctx.Sender())
+		langCode := ""
+		if regErr == nil {
+			langCode = user.LanguageCode
+		}
+		_, err = c.Bot.Edit(ctx.Message(), i18n.GetMessage(langCode, "selection_expired"))
+		return err
+	}
 	if err != nil {
-		log.Printf("Failed to get stats: %v", err)
-		return ctx.Send("Failed to fetch analytics.")
+		slog.ErrorContext(reqCtx, "Error processing story selection", "error", err)
 	}
-
-	return ctx.Send(report, &tele.SendOptions{ParseMode: tele.ModeMarkdown})
+	return err
 }
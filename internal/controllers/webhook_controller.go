@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/datasources"
+	tele "gopkg.in/telebot.v3"
+)
+
+// webhookQueueTimeout bounds how long a request waits for room in the update queue
+const webhookQueueTimeout = 5 * time.Second
+
+// WebhookController receives Telegram updates over HTTP and queues them for the bot
+type WebhookController struct {
+	Bot         *tele.Bot
+	SecretToken string
+	Heartbeat   *datasources.UpdatesHeartbeat
+
+	// Active reports whether this instance takes updates; in cluster mode only the leader does
+	Active func() bool
+}
+
+func NewWebhookController(bot *tele.Bot, secretToken string, heartbeat *datasources.UpdatesHeartbeat) *WebhookController {
+	return &WebhookController{Bot: bot, SecretToken: secretToken, Heartbeat: heartbeat}
+}
+
+func (c *WebhookController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(c.SecretToken)) != 1 {
+		slog.WarnContext(r.Context(), "Rejected webhook request: invalid secret token", "remote_addr", r.RemoteAddr)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Telegram retries, and reaches the new leader once it has registered its URL
+	if c.Active != nil && !c.Active() {
+		http.Error(w, "not the leader", http.StatusServiceUnavailable)
+		return
+	}
+
+	var update tele.Update
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&update); err != nil {
+		slog.WarnContext(r.Context(), "Cannot decode webhook update", "error", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	// A non-2xx response makes Telegram retry the update later
+	select {
+	case c.Bot.Updates <- update:
+		c.Heartbeat.Beat()
+		w.WriteHeader(http.StatusOK)
+	case <-time.After(webhookQueueTimeout):
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	case <-r.Context().Done():
+	}
+}
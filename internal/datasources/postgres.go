@@ -3,21 +3,36 @@ package datasources
 import (
 	"database/sql"
 	"fmt"
-	"os"
+	"strings"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
-func NewPostgresConnection() (*sql.DB, error) {
-	connStr := os.Getenv("DATABASE_URL")
+func NewPostgresConnection(connStr string) (*sql.DB, error) {
 	if connStr == "" {
 		return nil, fmt.Errorf("DATABASE_URL environment variable is not set")
 	}
 
-	db, err := sql.Open("postgres", connStr)
+	cfg, err := pq.NewConfig(connStr)
 	if err != nil {
 		return nil, err
 	}
+	// Pin the session time zone, so CURRENT_DATE and created_at::date are UTC days
+	// whatever the server default is
+	for name := range cfg.Runtime {
+		if strings.EqualFold(name, "timezone") {
+			delete(cfg.Runtime, name)
+		}
+	}
+	if cfg.Runtime == nil {
+		cfg.Runtime = make(map[string]string)
+	}
+	cfg.Runtime["timezone"] = "UTC"
+	connector, err := pq.NewConnectorConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	db := sql.OpenDB(countedConnector{connector})
 
 	if err := db.Ping(); err != nil {
 		return nil, fmt.Errorf("failed to ping database: %v", err)
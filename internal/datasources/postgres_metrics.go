@@ -0,0 +1,123 @@
+package datasources
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+
+	"github.com/bbr/telestory-api-based/internal/metrics"
+	"github.com/lib/pq"
+)
+
+// countedConnector wraps the pq connector to count queries and database errors
+type countedConnector struct {
+	driver.Connector
+}
+
+func (c countedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	record(err)
+	if err != nil {
+		return nil, err
+	}
+	return &countedConn{conn: conn}, nil
+}
+
+// countedConn forwards to a pq connection, recording the outcome of each round trip
+type countedConn struct {
+	conn driver.Conn
+}
+
+func (c *countedConn) Prepare(query string) (driver.Stmt, error) {
+	stmt, err := c.conn.Prepare(query)
+	record(err)
+	return stmt, err
+}
+
+func (c *countedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmt, err := c.conn.(driver.ConnPrepareContext).PrepareContext(ctx, query)
+	record(err)
+	return stmt, err
+}
+
+func (c *countedConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *countedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *countedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	tx, err := c.conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+	record(err)
+	if err != nil {
+		return nil, err
+	}
+	return countedTx{tx}, nil
+}
+
+func (c *countedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	res, err := c.conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+	record(err)
+	return res, err
+}
+
+func (c *countedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := c.conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+	record(err)
+	return rows, err
+}
+
+func (c *countedConn) Ping(ctx context.Context) error {
+	err := c.conn.(driver.Pinger).Ping(ctx)
+	record(err)
+	return err
+}
+
+func (c *countedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	return c.conn.(driver.NamedValueChecker).CheckNamedValue(nv)
+}
+
+func (c *countedConn) ResetSession(ctx context.Context) error {
+	return c.conn.(driver.SessionResetter).ResetSession(ctx)
+}
+
+func (c *countedConn) IsValid() bool {
+	return c.conn.(driver.Validator).IsValid()
+}
+
+type countedTx struct {
+	driver.Tx
+}
+
+func (tx countedTx) Commit() error {
+	err := tx.Tx.Commit()
+	record(err)
+	return err
+}
+
+// record counts a round trip; only connection, resource and server failures count as errors,
+// since constraint violations and similar are answers the caller expects to handle
+func record(err error) {
+	result := "ok"
+	if isDatabaseFailure(err) {
+		result = "error"
+	}
+	metrics.DBQueries.With(result).Inc()
+}
+
+func isDatabaseFailure(err error) bool {
+	if err == nil || errors.Is(err, driver.ErrSkip) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return true // network errors, timeouts, bad connections
+	}
+	switch pqErr.Code.Class() {
+	case "08", "53", "57", "58", "XX": // connection, resources, operator intervention, system, internal
+		return true
+	}
+	return false
+}
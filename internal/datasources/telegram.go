@@ -2,21 +2,67 @@ package datasources
 
 import (
 	"fmt"
-	"os"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/bbr/telestory-api-based/internal/config"
 	tele "gopkg.in/telebot.v3"
 )
 
-func NewTelegramBot() (*tele.Bot, error) {
-	token := os.Getenv("TELEGRAM_BOT_TOKEN")
+// UpdatesHeartbeat records when Telegram last handed us updates, by getUpdates or webhook
+type UpdatesHeartbeat struct {
+	last atomic.Int64 // unix nanoseconds
+}
+
+// Beat marks a successful update delivery
+func (h *UpdatesHeartbeat) Beat() {
+	h.last.Store(time.Now().UnixNano())
+}
+
+// Last returns the time of the last successful delivery, zero if none yet
+func (h *UpdatesHeartbeat) Last() time.Time {
+	ns := h.last.Load()
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// heartbeatTransport beats on every successful getUpdates call, including empty long polls
+type heartbeatTransport struct {
+	base      http.RoundTripper
+	heartbeat *UpdatesHeartbeat
+}
+
+func (t *heartbeatTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusOK && strings.HasSuffix(req.URL.Path, "/getUpdates") {
+		t.heartbeat.Beat()
+	}
+	return resp, err
+}
+
+func NewTelegramBot(token string, poller tele.Poller, heartbeat *UpdatesHeartbeat) (*tele.Bot, error) {
 	if token == "" {
 		return nil, fmt.Errorf("TELEGRAM_BOT_TOKEN environment variable is not set")
 	}
 
 	pref := tele.Settings{
 		Token:  token,
-		Poller: &tele.LongPoller{Timeout: 10 * time.Second},
+		Poller: poller,
+		// Handler errors are logged with the request ID by the controllers' middleware
+		OnError: func(err error, c tele.Context) {
+			if c == nil {
+				slog.Error("Telegram bot error", "error", err)
+			}
+		},
+		Client: &http.Client{
+			Timeout:   time.Minute,
+			Transport: &heartbeatTransport{base: http.DefaultTransport, heartbeat: heartbeat},
+		},
 	}
 
 	b, err := tele.NewBot(pref)
@@ -26,3 +72,20 @@ func NewTelegramBot() (*tele.Bot, error) {
 
 	return b, nil
 }
+
+// NewPoller returns the long poller, or in webhook mode a webhook that is registered
+// when the bot starts. Webhook requests are served by the HTTP server, not by telebot.
+func NewPoller(cfg *config.Config) tele.Poller {
+	if cfg.BotMode != config.BotModeWebhook {
+		return &tele.LongPoller{Timeout: 10 * time.Second}
+	}
+
+	return &tele.Webhook{
+		SecretToken:    cfg.WebhookSecret,
+		MaxConnections: cfg.WebhookMaxConnections,
+		Endpoint: &tele.WebhookEndpoint{
+			PublicURL: cfg.WebhookURL(),
+			Cert:      cfg.WebhookCert,
+		},
+	}
+}
@@ -0,0 +1,37 @@
+// Package dbtest opens the Postgres database of integration tests
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/bbr/telestory-api-based/internal/datasources"
+	"github.com/bbr/telestory-api-based/internal/migrate"
+	"github.com/bbr/telestory-api-based/migrations"
+)
+
+// Open connects to TEST_DATABASE_URL and migrates it; the test is skipped without it.
+// Use a throwaway database, the tests write to it.
+func Open(t testing.TB) *sql.DB {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := datasources.NewPostgresConnection(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
@@ -0,0 +1,49 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+)
+
+type csvWriter struct {
+	w      *csv.Writer
+	record []string
+}
+
+func newCSV(w io.Writer, header []string) (Writer, error) {
+	// The byte order mark makes Excel read the file as UTF-8
+	if _, err := io.WriteString(w, "\ufeff"); err != nil {
+		return nil, err
+	}
+	c := &csvWriter{w: csv.NewWriter(w)}
+	if err := c.w.Write(header); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *csvWriter) Write(row []any) error {
+	c.record = c.record[:0]
+	for _, v := range row {
+		if n, ok := number(v); ok {
+			c.record = append(c.record, n)
+			continue
+		}
+		c.record = append(c.record, escapeFormula(text(v)))
+	}
+	return c.w.Write(c.record)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// escapeFormula keeps spreadsheets from evaluating user supplied text such as names as formulas
+func escapeFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,83 @@
+// Package export writes tables as CSV or XLSX with the standard library only.
+// Rows are streamed, so an export never has to fit in memory.
+package export
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Formats
+const (
+	CSV  = "csv"
+	XLSX = "xlsx"
+)
+
+// Formats lists the supported formats, the first is the default
+var Formats = []string{CSV, XLSX}
+
+// Writer writes the rows of one table. Values may be strings, integers, floats, bools,
+// time.Time or nil; Close completes the file but does not close the underlying writer.
+type Writer interface {
+	Write(row []any) error
+	Close() error
+}
+
+// New returns a writer of format that starts with a header row
+func New(format string, w io.Writer, header []string) (Writer, error) {
+	switch format {
+	case CSV:
+		return newCSV(w, header)
+	case XLSX:
+		return newXLSX(w, header)
+	default:
+		return nil, fmt.Errorf("unsupported export format %q", format)
+	}
+}
+
+// ContentType is the MIME type of format
+func ContentType(format string) string {
+	if format == XLSX {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// MaxRows is the most data rows one file of format can hold, zero for no limit
+func MaxRows(format string) int {
+	if format == XLSX {
+		return xlsxMaxRows - 1 // the header takes a row
+	}
+	return 0
+}
+
+// timeLayout is how time.Time values are written; XLSX dates would need a style sheet
+const timeLayout = "2006-01-02 15:04:05"
+
+// text renders a non-numeric value
+func text(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.UTC().Format(timeLayout)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// number renders integers and floats, ok is false for anything else
+func number(v any) (string, bool) {
+	switch v := v.(type) {
+	case int, int32, int64, float64:
+		return fmt.Sprint(v), true
+	default:
+		return "", false
+	}
+}
@@ -2,76 +2,325 @@ package i18n
 
 var Locales = map[string]map[string]string{
 	"en": {
-		"welcome":        "🇺🇸 Welcome! Please choose your language:",
-		"registered":     "Language set to English 🇺🇸",
-		"instruction":    "**You can send:**\n- `username` or `@username`\n- `+1234567890`",
-		"processing":     "⏳ Processing...",
-		"error_limit":    "🚫 Daily limit reached (%d/%d). Upgrade to Premium for unlimited searches!",
-		"story_count":    "📊 Found %d stories for `%s`",
-		"no_stories":     "📭 No stories found for `%s`",
-		"fetch_error":    "❌ Error fetching stories: %s",
-		"download_error": "⚠️ Some stories couldn't be downloaded. Sent %d of %d stories.",
-		"downloading":    "📊 Found %d stories. Downloading...",
-		"story_from":     "Story from %s",
-		"cooldown":       "Please wait %d seconds between downloads.",
-		"maintenance":    "🛠 We're currently experiencing some issues and are working to fix them. We'll be back shortly. Sorry for the inconvenience! 🙏",
-		"invalid_input":  "❌ Invalid input! Please send only a valid username (e.g. `@username`) or a phone number (e.g. `+1234567890`).",
-		"stats_report": "📊 **Bot Analytics**\n\n" +
-			"👥 **Total Users:** %d\n" +
-			"🔥 **Active Users (7 Days):** %d\n" +
-			"🔥 **Active Users (30 Days):** %d\n\n" +
-			"📅 **Today's Downloads:** %d\n" +
-			"✅ Success: %d | ❌ Failed: %d\n\n" +
-			"📥 **Total Downloads (All-Time):** %d\n" +
-			"✅ Success: %d | ❌ Failed: %d",
+		"welcome":                 "🇺🇸 Welcome! Please choose your language:",
+		"registered":              "Language set to English 🇺🇸",
+		"instruction":             "**You can send:**\n- `username` or `@username`\n- `+1234567890`\n- `https://t.me/username/s/123`",
+		"processing":              "⏳ Processing...",
+		"error_limit":             "🚫 Daily limit reached (%d/%d). Upgrade to Premium for unlimited searches!",
+		"story_count":             "📊 Found %d stories for `%s`",
+		"no_stories":              "📭 No stories found for `%s`",
+		"fetch_error":             "❌ Could not fetch the stories right now. Please try again later.",
+		"download_error":          "⚠️ Some stories couldn't be downloaded. Sent %d of %d stories.",
+		"delivery_failed":         "❌ Could not deliver the stories. Please send the username again.",
+		"downloading":             "📊 Found %d stories. Downloading...",
+		"story_from":              "Story from %s",
+		"cooldown":                "Please wait %d seconds between downloads.",
+		"maintenance":             "🛠 We're currently experiencing some issues and are working to fix them. We'll be back shortly. Sorry for the inconvenience! 🙏",
+		"invalid_input":           "❌ Invalid input! Please send only a valid username (e.g. `@username`) or a phone number (e.g. `+1234567890`).",
+		"choose_mode":             "📊 Found %d active stories for `%s`. What would you like to receive?",
+		"no_active_stories":       "📭 `%s` has no active stories right now. You can still check the archive.",
+		"mode_latest":             "🆕 Latest story only",
+		"mode_active":             "📌 All active stories (%d)",
+		"mode_archive":            "🗂 Active + archive",
+		"story_not_found":         "📭 Story #%d not found for `%s`",
+		"selection_expired":       "⌛ This selection has expired. Please send the username again.",
+		"picker_title":            "🗂 %d stories for `%s`. Selected: %d\nTap stories to select them.",
+		"picker_all":              "✅ All",
+		"picker_range":            "📅 Date range",
+		"picker_download":         "⬇️ Download (%d)",
+		"picker_cancel":           "✖️ Cancel",
+		"picker_nothing_selected": "⚠️ Select at least one story first.",
+		"picker_range_start":      "📅 Tap the first story of the range.",
+		"picker_range_end":        "📅 Now tap the last story of the range.",
+		"callback_expired":        "⌛ This button has expired. Please start again.",
+		"inline_hint":             "Type a username to share their stories",
+		"inline_fetch":            "🔎 Open bot to fetch %s",
+		"inline_limit":            "🚫 Daily limit reached (%d/%d)",
+		"group_instruction":       "👥 In groups, send `/story@%s username` or `@%s username`.",
+		"group_open_bot":          "🤖 Open bot",
+		"group_start_bot_first":   "📬 This group receives stories privately. Please start the bot first, then try again.",
+		"group_sent_private":      "📬 Sending the stories to you privately.",
+		"group_only":              "👥 This command only works in groups.",
+		"group_admin_only":        "🚫 Only group admins can change these settings.",
+		"group_settings":          "⚙️ **Group settings**\n\nWhere should requested stories be delivered?",
+		"group_delivery_group":    "👥 Post in this group",
+		"group_delivery_private":  "📬 Send privately to the requester",
+		"settings_saved":          "✅ Saved",
+		"settings_title": "⚙️ **Settings**\n\n" +
+			"🌐 Language: %s\n" +
+			"📦 Delivery: %s\n" +
+			"📝 Captions: %s\n" +
+			"🗂 Archived stories: %s\n" +
+			"🕒 Timezone: `%s`\n" +
+			"🔔 Notifications: %s",
+		"settings_style_individual": "one by one",
+		"settings_style_album":      "albums",
+		"settings_caption_full":     "caption + date",
+		"settings_caption_date":     "date only",
+		"settings_caption_none":     "none",
+		"settings_on":               "on",
+		"settings_off":              "off",
+		"settings_btn_language":     "🌐 Language",
+		"settings_btn_style":        "📦 Delivery style",
+		"settings_btn_caption":      "📝 Caption format",
+		"settings_btn_archive":      "🗂 Archived stories",
+		"settings_btn_timezone":     "🕒 Timezone",
+		"settings_btn_notify":       "🔔 Notifications",
+		"settings_choose_language":  "🌐 Choose your language:",
+		"settings_choose_timezone":  "🕒 Choose your timezone:",
+		"settings_back":             "⬅️ Back",
+		"history_title":             "📜 **Your history** (%d)",
+		"history_empty":             "📜 Your history is empty.",
+		"history_resent":            "🔁 Sent %d stories again",
+		"history_nothing_to_resend": "Nothing to re-send for this entry.",
+		"history_deleted":           "🗑 Deleted",
+		"shutdown_restarting":       "🔄 The bot is restarting. Please send your request again in a minute.",
+		"shutdown_interrupted":      "🔄 The bot was restarted while downloading stories of %s. Please send the request again in a minute.",
+		"stats_summary": "📊 **Bot Analytics** — %s\n\n" +
+			"👤 **New Users:** %d\n" +
+			"🔥 **Active Users:** %d\n" +
+			"📥 **Requests:** %d\n" +
+			"✅ **Success Rate:** %s\n" +
+			"🎞 **Stories Delivered:** %d",
+		"stats_usage":           "Usage: `/stats [targets|funnel|languages|retention] [today|7d|30d]` or `/stats [report] YYYY-MM-DD [YYYY-MM-DD]` (up to %d days)",
+		"stats_btn_targets":     "🎯 Targets",
+		"stats_btn_funnel":      "🔻 Funnel",
+		"stats_btn_languages":   "🌐 Languages",
+		"stats_btn_retention":   "📅 Retention",
+		"stats_empty":           "No data for this period.",
+		"stats_targets_title":   "🎯 **Top Targets** — %s",
+		"stats_targets_line":    "%d. `%s` — %d requests, %d users, %s success",
+		"stats_languages_title": "🌐 **Users by Language**",
+		"stats_language_none":   "❔ Not chosen",
+		"stats_funnel": "🔻 **Funnel** — users who started in %s\n\n" +
+			"👋 Started: %d\n" +
+			"🌐 Chose language: %s\n" +
+			"🔍 First search: %s\n" +
+			"✅ First success: %s\n" +
+			"⭐ Premium: %s",
+		"stats_retention_title":    "📅 **Retention** — share of each signup week's users who sent a request N weeks later (last %d weeks)",
+		"stats_view_today":         "Today",
+		"stats_view_7d":            "7 days",
+		"stats_view_30d":           "30 days",
+		"stats_chart_new_users":    "👤 New users per day",
+		"stats_chart_active_users": "🔥 Active users per day",
+		"stats_chart_requests":     "📥 Requests per day",
+		"stats_chart_success_rate": "✅ Success rate per day",
+		"stats_chart_stories":      "🎞 Stories delivered per day",
 	},
 	"uz": {
-		"welcome":        "🇺🇿 Xush kelibsiz! Tilni tanlang:",
-		"registered":     "O'zbek tili tanlandi 🇺🇿",
-		"instruction":    "**Yuborishingiz mumkin:**\n- `username` yoki `@username`\n- `+998901234567`",
-		"processing":     "⏳ Qidirilmoqda...",
-		"error_limit":    "🚫 Limit tugadi (%d/%d). Cheksiz qidirish uchun Premium oling!",
-		"story_count":    "📊 %d ta hikoya topildi — `%s`",
-		"no_stories":     "📭 `%s` uchun hikoya topilmadi",
-		"fetch_error":    "❌ Hikoyalarni yuklashda xatolik: %s",
-		"download_error": "⚠️ Ba'zi hikoyalar yuklanmadi. %d/%d ta hikoya yuborildi.",
-		"downloading":    "📊 %d ta hikoya topildi. Yuklanmoqda...",
-		"story_from":     "%s dan hikoya",
-		"cooldown":       "Iltimos, yuklashlar orasida %d soniya kuting.",
-		"maintenance":    "🛠 Hozirda muammolarni hal qilishga harakat qilyapmiz. Tez orada qayta ishga tushamiz. Noqulaylik uchun uzr! 🙏",
-		"invalid_input":  "❌ Noto'g'ri format! Iltimos, faqat username (masalan, `@username`) yoki telefon raqami (masalan, `+998901234567`) yuboring.",
-		"stats_report": "📊 **Bot Statistikasi**\n\n" +
-			"👥 **Jami Foydalanuvchilar:** %d\n" +
-			"🔥 **Faol Foydalanuvchilar (7 kun):** %d\n" +
-			"🔥 **Faol Foydalanuvchilar (30 kun):** %d\n\n" +
-			"📅 **Bugungi Yuklashlar:** %d\n" +
-			"✅ Muvaffaqiyatli: %d | ❌ Xatoliklar: %d\n\n" +
-			"📥 **Jami Yuklashlar (Barcha vaqt):** %d\n" +
-			"✅ Muvaffaqiyatli: %d | ❌ Xatoliklar: %d",
+		"welcome":                 "🇺🇿 Xush kelibsiz! Tilni tanlang:",
+		"registered":              "O'zbek tili tanlandi 🇺🇿",
+		"instruction":             "**Yuborishingiz mumkin:**\n- `username` yoki `@username`\n- `+998901234567`\n- `https://t.me/username/s/123`",
+		"processing":              "⏳ Qidirilmoqda...",
+		"error_limit":             "🚫 Limit tugadi (%d/%d). Cheksiz qidirish uchun Premium oling!",
+		"story_count":             "📊 %d ta hikoya topildi — `%s`",
+		"no_stories":              "📭 `%s` uchun hikoya topilmadi",
+		"fetch_error":             "❌ Hozir hikoyalarni yuklab bo'lmadi. Iltimos, keyinroq qayta urinib ko'ring.",
+		"download_error":          "⚠️ Ba'zi hikoyalar yuklanmadi. %d/%d ta hikoya yuborildi.",
+		"delivery_failed":         "❌ Hikoyalarni yuborib bo'lmadi. Iltimos, username ni qayta yuboring.",
+		"downloading":             "📊 %d ta hikoya topildi. Yuklanmoqda...",
+		"story_from":              "%s dan hikoya",
+		"cooldown":                "Iltimos, yuklashlar orasida %d soniya kuting.",
+		"maintenance":             "🛠 Hozirda muammolarni hal qilishga harakat qilyapmiz. Tez orada qayta ishga tushamiz. Noqulaylik uchun uzr! 🙏",
+		"invalid_input":           "❌ Noto'g'ri format! Iltimos, faqat username (masalan, `@username`) yoki telefon raqami (masalan, `+998901234567`) yuboring.",
+		"choose_mode":             "📊 `%[2]s` uchun %[1]d ta faol hikoya topildi. Qaysilarini olishni xohlaysiz?",
+		"no_active_stories":       "📭 `%s` da hozir faol hikoya yo'q. Arxivni tekshirishingiz mumkin.",
+		"mode_latest":             "🆕 Faqat oxirgi hikoya",
+		"mode_active":             "📌 Barcha faol hikoyalar (%d)",
+		"mode_archive":            "🗂 Faol + arxiv",
+		"story_not_found":         "📭 `%[2]s` uchun #%[1]d hikoya topilmadi",
+		"selection_expired":       "⌛ Bu tanlov muddati tugadi. Iltimos, username ni qayta yuboring.",
+		"picker_title":            "🗂 `%[2]s` uchun %[1]d ta hikoya. Tanlangan: %[3]d\nTanlash uchun hikoyalarni bosing.",
+		"picker_all":              "✅ Hammasi",
+		"picker_range":            "📅 Sana oralig'i",
+		"picker_download":         "⬇️ Yuklash (%d)",
+		"picker_cancel":           "✖️ Bekor qilish",
+		"picker_nothing_selected": "⚠️ Avval kamida bitta hikoyani tanlang.",
+		"picker_range_start":      "📅 Oraliqning birinchi hikoyasini bosing.",
+		"picker_range_end":        "📅 Endi oraliqning oxirgi hikoyasini bosing.",
+		"callback_expired":        "⌛ Bu tugma eskirgan. Iltimos, qaytadan boshlang.",
+		"inline_hint":             "Hikoyalarini ulashish uchun username yozing",
+		"inline_fetch":            "🔎 %s ni botda yuklash",
+		"inline_limit":            "🚫 Limit tugadi (%d/%d)",
+		"group_instruction":       "👥 Guruhlarda `/story@%s username` yoki `@%s username` yuboring.",
+		"group_open_bot":          "🤖 Botni ochish",
+		"group_start_bot_first":   "📬 Bu guruhda hikoyalar shaxsiy yuboriladi. Avval botni ishga tushiring, so'ng qayta urinib ko'ring.",
+		"group_sent_private":      "📬 Hikoyalar sizga shaxsiy yuborilmoqda.",
+		"group_only":              "👥 Bu buyruq faqat guruhlarda ishlaydi.",
+		"group_admin_only":        "🚫 Bu sozlamalarni faqat guruh adminlari o'zgartira oladi.",
+		"group_settings":          "⚙️ **Guruh sozlamalari**\n\nSo'ralgan hikoyalar qayerga yuborilsin?",
+		"group_delivery_group":    "👥 Shu guruhga",
+		"group_delivery_private":  "📬 So'rovchiga shaxsiy",
+		"settings_saved":          "✅ Saqlandi",
+		"settings_title": "⚙️ **Sozlamalar**\n\n" +
+			"🌐 Til: %s\n" +
+			"📦 Yuborish: %s\n" +
+			"📝 Izohlar: %s\n" +
+			"🗂 Arxiv hikoyalari: %s\n" +
+			"🕒 Vaqt mintaqasi: `%s`\n" +
+			"🔔 Bildirishnomalar: %s",
+		"settings_style_individual": "birma-bir",
+		"settings_style_album":      "albom",
+		"settings_caption_full":     "izoh + sana",
+		"settings_caption_date":     "faqat sana",
+		"settings_caption_none":     "yo'q",
+		"settings_on":               "yoqilgan",
+		"settings_off":              "o'chirilgan",
+		"settings_btn_language":     "🌐 Til",
+		"settings_btn_style":        "📦 Yuborish usuli",
+		"settings_btn_caption":      "📝 Izoh formati",
+		"settings_btn_archive":      "🗂 Arxiv hikoyalari",
+		"settings_btn_timezone":     "🕒 Vaqt mintaqasi",
+		"settings_btn_notify":       "🔔 Bildirishnomalar",
+		"settings_choose_language":  "🌐 Tilni tanlang:",
+		"settings_choose_timezone":  "🕒 Vaqt mintaqasini tanlang:",
+		"settings_back":             "⬅️ Orqaga",
+		"history_title":             "📜 **Tarixingiz** (%d)",
+		"history_empty":             "📜 Tarixingiz bo'sh.",
+		"history_resent":            "🔁 %d ta hikoya qayta yuborildi",
+		"history_nothing_to_resend": "Bu yozuv uchun qayta yuboriladigan narsa yo'q.",
+		"history_deleted":           "🗑 O'chirildi",
+		"shutdown_restarting":       "🔄 Bot qayta ishga tushmoqda. Iltimos, bir daqiqadan so'ng so'rovingizni qayta yuboring.",
+		"shutdown_interrupted":      "🔄 %s hikoyalari yuklanayotganda bot qayta ishga tushdi. Iltimos, bir daqiqadan so'ng so'rovni qayta yuboring.",
+		"stats_summary": "📊 **Bot Statistikasi** — %s\n\n" +
+			"👤 **Yangi Foydalanuvchilar:** %d\n" +
+			"🔥 **Faol Foydalanuvchilar:** %d\n" +
+			"📥 **So'rovlar:** %d\n" +
+			"✅ **Muvaffaqiyat Darajasi:** %s\n" +
+			"🎞 **Yuborilgan Hikoyalar:** %d",
+		"stats_usage":           "Foydalanish: `/stats [targets|funnel|languages|retention] [today|7d|30d]` yoki `/stats [hisobot] YYYY-MM-DD [YYYY-MM-DD]` (%d kungacha)",
+		"stats_btn_targets":     "🎯 Nishonlar",
+		"stats_btn_funnel":      "🔻 Voronka",
+		"stats_btn_languages":   "🌐 Tillar",
+		"stats_btn_retention":   "📅 Qaytish",
+		"stats_empty":           "Bu davr uchun ma'lumot yo'q.",
+		"stats_targets_title":   "🎯 **Eng Ko'p So'ralganlar** — %s",
+		"stats_targets_line":    "%d. `%s` — %d so'rov, %d foydalanuvchi, %s muvaffaqiyatli",
+		"stats_languages_title": "🌐 **Tillar Bo'yicha Foydalanuvchilar**",
+		"stats_language_none":   "❔ Tanlanmagan",
+		"stats_funnel": "🔻 **Voronka** — %s davrida boshlagan foydalanuvchilar\n\n" +
+			"👋 Boshladi: %d\n" +
+			"🌐 Til tanladi: %s\n" +
+			"🔍 Birinchi qidiruv: %s\n" +
+			"✅ Birinchi muvaffaqiyat: %s\n" +
+			"⭐ Premium: %s",
+		"stats_retention_title":    "📅 **Qaytish** — har bir ro'yxatdan o'tish haftasi foydalanuvchilaridan N hafta o'tib so'rov yuborganlar ulushi (oxirgi %d hafta)",
+		"stats_view_today":         "Bugun",
+		"stats_view_7d":            "7 kun",
+		"stats_view_30d":           "30 kun",
+		"stats_chart_new_users":    "👤 Kunlik yangi foydalanuvchilar",
+		"stats_chart_active_users": "🔥 Kunlik faol foydalanuvchilar",
+		"stats_chart_requests":     "📥 Kunlik so'rovlar",
+		"stats_chart_success_rate": "✅ Kunlik muvaffaqiyat darajasi",
+		"stats_chart_stories":      "🎞 Kunlik yuborilgan hikoyalar",
 	},
 	"ru": {
-		"welcome":        "🇷🇺 Добро пожаловать! Выберите язык:",
-		"registered":     "Язык выбран: Русский 🇷🇺",
-		"instruction":    "**Вы можете отправить:**\n- `username` или `@username`\n- `+79001234567`",
-		"processing":     "⏳ Обработка...",
-		"error_limit":    "🚫 Лимит исчерпан (%d/%d). Купите Premium для безлимитного поиска!",
-		"story_count":    "📊 Найдено %d историй для `%s`",
-		"no_stories":     "📭 Истории не найдены для `%s`",
-		"fetch_error":    "❌ Ошибка загрузки историй: %s",
-		"download_error": "⚠️ Некоторые истории не удалось загрузить. Отправлено %d из %d историй.",
-		"downloading":    "📊 Найдено %d историй. Загрузка...",
-		"story_from":     "История от %s",
-		"cooldown":       "Пожалуйста, подождите %d секунд между загрузками.",
-		"maintenance":    "🛠 Мы работаем над устранением неполадок и скоро вернёмся. Извините за неудобства! 🙏",
-		"invalid_input":  "❌ Неверный ввод! Пожалуйста, отправьте только имя пользователя (например, `@username`) или номер телефона (например, `+79001234567`).",
-		"stats_report": "📊 **Аналитика Бота**\n\n" +
-			"👥 **Всего Пользователей:** %d\n" +
-			"🔥 **Активные Пользователи (7 Дней):** %d\n" +
-			"🔥 **Активные Пользователи (30 Дней):** %d\n\n" +
-			"📅 **Загрузки за Сегодня:** %d\n" +
-			"✅ Успешно: %d | ❌ Ошибки: %d\n\n" +
-			"📥 **Всего Загрузок (За всё время):** %d\n" +
-			"✅ Успешно: %d | ❌ Ошибки: %d",
+		"welcome":                 "🇷🇺 Добро пожаловать! Выберите язык:",
+		"registered":              "Язык выбран: Русский 🇷🇺",
+		"instruction":             "**Вы можете отправить:**\n- `username` или `@username`\n- `+79001234567`\n- `https://t.me/username/s/123`",
+		"processing":              "⏳ Обработка...",
+		"error_limit":             "🚫 Лимит исчерпан (%d/%d). Купите Premium для безлимитного поиска!",
+		"story_count":             "📊 Найдено %d историй для `%s`",
+		"no_stories":              "📭 Истории не найдены для `%s`",
+		"fetch_error":             "❌ Сейчас не удалось загрузить истории. Пожалуйста, попробуйте позже.",
+		"download_error":          "⚠️ Некоторые истории не удалось загрузить. Отправлено %d из %d историй.",
+		"delivery_failed":         "❌ Не удалось доставить истории. Пожалуйста, отправьте имя пользователя ещё раз.",
+		"downloading":             "📊 Найдено %d историй. Загрузка...",
+		"story_from":              "История от %s",
+		"cooldown":                "Пожалуйста, подождите %d секунд между загрузками.",
+		"maintenance":             "🛠 Мы работаем над устранением неполадок и скоро вернёмся. Извините за неудобства! 🙏",
+		"invalid_input":           "❌ Неверный ввод! Пожалуйста, отправьте только имя пользователя (например, `@username`) или номер телефона (например, `+79001234567`).",
+		"choose_mode":             "📊 Найдено %d активных историй для `%s`. Что вы хотите получить?",
+		"no_active_stories":       "📭 У `%s` сейчас нет активных историй. Можно проверить архив.",
+		"mode_latest":             "🆕 Только последнюю",
+		"mode_active":             "📌 Все активные истории (%d)",
+		"mode_archive":            "🗂 Активные + архив",
+		"story_not_found":         "📭 История #%d не найдена для `%s`",
+		"selection_expired":       "⌛ Этот выбор устарел. Пожалуйста, отправьте имя пользователя ещё раз.",
+		"picker_title":            "🗂 %d историй для `%s`. Выбрано: %d\nНажмите на истории, чтобы выбрать их.",
+		"picker_all":              "✅ Все",
+		"picker_range":            "📅 Диапазон дат",
+		"picker_download":         "⬇️ Скачать (%d)",
+		"picker_cancel":           "✖️ Отмена",
+		"picker_nothing_selected": "⚠️ Сначала выберите хотя бы одну историю.",
+		"picker_range_start":      "📅 Нажмите на первую историю диапазона.",
+		"picker_range_end":        "📅 Теперь нажмите на последнюю историю диапазона.",
+		"callback_expired":        "⌛ Эта кнопка устарела. Пожалуйста, начните заново.",
+		"inline_hint":             "Введите имя пользователя, чтобы поделиться историями",
+		"inline_fetch":            "🔎 Открыть бота и загрузить %s",
+		"inline_limit":            "🚫 Лимит исчерпан (%d/%d)",
+		"group_instruction":       "👥 В группах отправьте `/story@%s username` или `@%s username`.",
+		"group_open_bot":          "🤖 Открыть бота",
+		"group_start_bot_first":   "📬 В этой группе истории отправляются лично. Сначала запустите бота, затем попробуйте снова.",
+		"group_sent_private":      "📬 Отправляю истории вам в личные сообщения.",
+		"group_only":              "👥 Эта команда работает только в группах.",
+		"group_admin_only":        "🚫 Только администраторы группы могут менять эти настройки.",
+		"group_settings":          "⚙️ **Настройки группы**\n\nКуда отправлять запрошенные истории?",
+		"group_delivery_group":    "👥 В эту группу",
+		"group_delivery_private":  "📬 Лично запросившему",
+		"settings_saved":          "✅ Сохранено",
+		"settings_title": "⚙️ **Настройки**\n\n" +
+			"🌐 Язык: %s\n" +
+			"📦 Отправка: %s\n" +
+			"📝 Подписи: %s\n" +
+			"🗂 Архивные истории: %s\n" +
+			"🕒 Часовой пояс: `%s`\n" +
+			"🔔 Уведомления: %s",
+		"settings_style_individual": "по одной",
+		"settings_style_album":      "альбомами",
+		"settings_caption_full":     "подпись + дата",
+		"settings_caption_date":     "только дата",
+		"settings_caption_none":     "без подписи",
+		"settings_on":               "вкл",
+		"settings_off":              "выкл",
+		"settings_btn_language":     "🌐 Язык",
+		"settings_btn_style":        "📦 Способ отправки",
+		"settings_btn_caption":      "📝 Формат подписи",
+		"settings_btn_archive":      "🗂 Архивные истории",
+		"settings_btn_timezone":     "🕒 Часовой пояс",
+		"settings_btn_notify":       "🔔 Уведомления",
+		"settings_choose_language":  "🌐 Выберите язык:",
+		"settings_choose_timezone":  "🕒 Выберите часовой пояс:",
+		"settings_back":             "⬅️ Назад",
+		"history_title":             "📜 **Ваша история** (%d)",
+		"history_empty":             "📜 Ваша история пуста.",
+		"history_resent":            "🔁 Повторно отправлено историй: %d",
+		"history_nothing_to_resend": "Для этой записи нечего отправлять.",
+		"history_deleted":           "🗑 Удалено",
+		"shutdown_restarting":       "🔄 Бот перезапускается. Пожалуйста, отправьте запрос снова через минуту.",
+		"shutdown_interrupted":      "🔄 Бот был перезапущен во время загрузки историй %s. Пожалуйста, отправьте запрос снова через минуту.",
+		"stats_summary": "📊 **Аналитика Бота** — %s\n\n" +
+			"👤 **Новые Пользователи:** %d\n" +
+			"🔥 **Активные Пользователи:** %d\n" +
+			"📥 **Запросы:** %d\n" +
+			"✅ **Успешность:** %s\n" +
+			"🎞 **Отправлено Историй:** %d",
+		"stats_usage":           "Использование: `/stats [targets|funnel|languages|retention] [today|7d|30d]` или `/stats [отчёт] YYYY-MM-DD [YYYY-MM-DD]` (до %d дней)",
+		"stats_btn_targets":     "🎯 Цели",
+		"stats_btn_funnel":      "🔻 Воронка",
+		"stats_btn_languages":   "🌐 Языки",
+		"stats_btn_retention":   "📅 Удержание",
+		"stats_empty":           "Нет данных за этот период.",
+		"stats_targets_title":   "🎯 **Популярные Цели** — %s",
+		"stats_targets_line":    "%d. `%s` — %d запросов, %d пользователей, успешно %s",
+		"stats_languages_title": "🌐 **Пользователи по Языкам**",
+		"stats_language_none":   "❔ Не выбран",
+		"stats_funnel": "🔻 **Воронка** — пользователи, начавшие в %s\n\n" +
+			"👋 Начали: %d\n" +
+			"🌐 Выбрали язык: %s\n" +
+			"🔍 Первый поиск: %s\n" +
+			"✅ Первый успех: %s\n" +
+			"⭐ Премиум: %s",
+		"stats_retention_title":    "📅 **Удержание** — доля пользователей каждой недели регистрации, отправивших запрос через N недель (последние %d недель)",
+		"stats_view_today":         "Сегодня",
+		"stats_view_7d":            "7 дней",
+		"stats_view_30d":           "30 дней",
+		"stats_chart_new_users":    "👤 Новые пользователи по дням",
+		"stats_chart_active_users": "🔥 Активные пользователи по дням",
+		"stats_chart_requests":     "📥 Запросы по дням",
+		"stats_chart_success_rate": "✅ Успешность по дням",
+		"stats_chart_stories":      "🎞 Отправлено историй по дням",
 	},
 }
 
@@ -97,3 +346,13 @@ const (
 	LangUZ = "uz"
 	LangRU = "ru"
 )
+
+// LanguageNames are the labels shown on language buttons
+var LanguageNames = map[string]string{
+	LangEN: "🇺🇸 English",
+	LangUZ: "🇺🇿 O'zbek",
+	LangRU: "🇷🇺 Русский",
+}
+
+// SupportedLanguages lists the language codes in menu order
+var SupportedLanguages = []string{LangEN, LangUZ, LangRU}
@@ -0,0 +1,128 @@
+// Package logging configures the slog default logger and carries request attributes in contexts.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"regexp"
+)
+
+// Setup installs the default logger. Output of the standard log package goes through it as well.
+func Setup(level, format string) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		lvl = slog.LevelInfo
+	}
+	slog.SetDefault(slog.New(NewHandler(os.Stderr, lvl, format)))
+}
+
+// NewHandler returns a handler that adds context attributes and redacts secrets
+func NewHandler(w io.Writer, level slog.Level, format string) slog.Handler {
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redactAttr}
+	if format == "text" {
+		return &contextHandler{slog.NewTextHandler(w, opts)}
+	}
+	return &contextHandler{slog.NewJSONHandler(w, opts)}
+}
+
+type ctxKey struct{}
+
+// With returns a copy of ctx whose attributes, given as slog key-value pairs, are added to every record logged with it
+func With(ctx context.Context, args ...any) context.Context {
+	attrs := attrsFrom(ctx)
+	merged := make([]slog.Attr, 0, len(attrs)+len(args)/2)
+	merged = append(merged, attrs...)
+	r := slog.Record{}
+	r.Add(args...)
+	r.Attrs(func(a slog.Attr) bool {
+		merged = append(merged, a)
+		return true
+	})
+	return context.WithValue(ctx, ctxKey{}, merged)
+}
+
+// WithRequestID starts a new request scope on ctx
+func WithRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		id = NewRequestID()
+	}
+	return With(ctx, "request_id", id)
+}
+
+// RequestID returns the request ID carried by ctx, if any
+func RequestID(ctx context.Context) string {
+	for _, a := range attrsFrom(ctx) {
+		if a.Key == "request_id" {
+			return a.Value.String()
+		}
+	}
+	return ""
+}
+
+// NewRequestID returns a random 16 character hex ID
+func NewRequestID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func attrsFrom(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(ctxKey{}).([]slog.Attr)
+	return attrs
+}
+
+// contextHandler adds the attributes stored by With to each record and redacts the message
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	// Messages from the standard log package are preformatted and may embed secrets
+	r.Message = Redact(r.Message)
+	if attrs := attrsFrom(ctx); len(attrs) > 0 {
+		r = r.Clone()
+		r.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{h.Handler.WithGroup(name)}
+}
+
+var (
+	// secretParamRx matches credentials passed as query parameters
+	secretParamRx = regexp.MustCompile(`(?i)\b((?:api_?key|access_token|token|secret|password|signature|sig)=)[^&\s"']+`)
+	// botTokenRx matches Telegram bot tokens, e.g. inside api.telegram.org/bot<token>/ URLs
+	botTokenRx = regexp.MustCompile(`\d{6,}:[A-Za-z0-9_-]{30,}`)
+)
+
+// Redact masks API keys and tokens in s
+func Redact(s string) string {
+	s = secretParamRx.ReplaceAllString(s, "${1}REDACTED")
+	return botTokenRx.ReplaceAllString(s, "REDACTED")
+}
+
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	switch v := a.Value.Any().(type) {
+	case string:
+		return slog.String(a.Key, Redact(v))
+	case error:
+		return slog.String(a.Key, Redact(v.Error()))
+	case *url.URL:
+		return slog.String(a.Key, Redact(v.String()))
+	}
+	return a
+}
@@ -0,0 +1,33 @@
+package metrics
+
+// Application metrics, exposed on /metrics
+var (
+	UpdatesTotal = NewCounterVec("telestory_updates_total",
+		"Telegram updates handled, by command or update kind and result.", "command", "result")
+	UpdateDuration = NewHistogramVec("telestory_update_duration_seconds",
+		"Time spent handling a Telegram update.", DefaultBuckets, "command")
+
+	APIRequests = NewCounterVec("telestory_api_requests_total",
+		"TeleStory API requests by result class (ok, api_error, http_4xx, http_5xx, timeout, network_error, decode_error).", "result")
+	APIDuration = NewHistogramVec("telestory_api_request_duration_seconds",
+		"TeleStory API request latency.", DefaultBuckets, "result")
+
+	MediaDownloads = NewCounterVec("telestory_media_downloads_total",
+		"Story media downloads by result.", "result")
+	MediaDownloadBytes = NewCounterVec("telestory_media_download_bytes_total",
+		"Bytes of story media downloaded.")
+	MediaDownloadDuration = NewHistogramVec("telestory_media_download_duration_seconds",
+		"Time to download one story media file.", DefaultBuckets)
+
+	ArchiveUploadDuration = NewHistogramVec("telestory_archive_upload_duration_seconds",
+		"Time to upload one story to the archive channel, by result.", DefaultBuckets, "result")
+
+	QuotaRejections = NewCounterVec("telestory_quota_rejections_total",
+		"Requests refused by the cooldown or the daily limit.", "reason")
+
+	DBQueries = NewCounterVec("telestory_db_queries_total",
+		"Database round trips; result is error for connection, resource and server failures.", "result")
+
+	LogEventsDropped = NewCounterVec("telestory_log_events_dropped_total",
+		"Admin log channel events dropped because the send queue was full.", "category")
+)
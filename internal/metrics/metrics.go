@@ -0,0 +1,329 @@
+// Package metrics implements the subset of the Prometheus text exposition format the app needs:
+// labelled counters, labelled histograms and gauges computed at scrape time.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets suit request latencies in seconds
+var DefaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// collector is one metric family
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Registry holds metric families and renders them for scraping
+type Registry struct {
+	mu         sync.Mutex
+	collectors map[string]collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]collector)}
+}
+
+// Default is the registry the package-level constructors register with
+var Default = NewRegistry()
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.collectors[c.name()]; ok {
+		panic("metrics: duplicate metric " + c.name())
+	}
+	r.collectors[c.name()] = c
+}
+
+// WriteText writes every family in the Prometheus text format, sorted by name
+func (r *Registry) WriteText(w io.Writer) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	collectors := r.collectors
+	r.mu.Unlock()
+
+	sort.Strings(names)
+	for _, name := range names {
+		collectors[name].write(w)
+	}
+}
+
+// Handler serves the registry
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+// vec maps label values to children
+type vec[T any] struct {
+	metric string
+	help   string
+	labels []string
+	create func() *T
+
+	mu       sync.Mutex
+	children map[string]*T
+	values   map[string][]string
+}
+
+func (v *vec[T]) name() string { return v.metric }
+
+func (v *vec[T]) with(values []string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.metric, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	child, ok := v.children[key]
+	if !ok {
+		child = v.create()
+		v.children[key] = child
+		v.values[key] = append([]string(nil), values...)
+	}
+	return child
+}
+
+// each visits children sorted by label values
+func (v *vec[T]) each(fn func(labels string, child *T)) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	type entry struct {
+		labels string
+		child  *T
+	}
+	entries := make([]entry, len(keys))
+	for i, key := range keys {
+		entries[i] = entry{labels: formatLabels(v.labels, v.values[key]), child: v.children[key]}
+	}
+	v.mu.Unlock()
+
+	for _, e := range entries {
+		fn(e.labels, e.child)
+	}
+}
+
+// sum adds value(child) over the children whose label values match; nil matches all
+func (v *vec[T]) sum(match func(values []string) bool, value func(child *T) float64) float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	total := 0.0
+	for key, child := range v.children {
+		if match == nil || match(v.values[key]) {
+			total += value(child)
+		}
+	}
+	return total
+}
+
+func newVec[T any](name, help string, labels []string, create func() *T) *vec[T] {
+	return &vec[T]{
+		metric:   name,
+		help:     help,
+		labels:   labels,
+		create:   create,
+		children: make(map[string]*T),
+		values:   make(map[string][]string),
+	}
+}
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	bits atomic.Uint64
+}
+
+func (c *Counter) Inc() { c.Add(1) }
+
+func (c *Counter) Add(delta float64) {
+	for {
+		old := c.bits.Load()
+		if c.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+// CounterVec is a counter partitioned by labels
+type CounterVec struct {
+	*vec[Counter]
+}
+
+// NewCounterVec registers a counter family with Default
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, labels, func() *Counter { return &Counter{} })}
+	Default.register(c)
+	return c
+}
+
+// With returns the counter for the label values, in the order the labels were declared
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.with(values)
+}
+
+// Sum adds the counters whose label values match; nil matches all
+func (c *CounterVec) Sum(match func(values []string) bool) float64 {
+	return c.sum(match, (*Counter).Value)
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	writeHeader(w, c.metric, c.help, "counter")
+	c.each(func(labels string, child *Counter) {
+		fmt.Fprintf(w, "%s%s %s\n", c.metric, labels, formatFloat(child.Value()))
+	})
+}
+
+// Histogram counts observations into cumulative buckets
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// Count returns the number of observations
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+// HistogramVec is a histogram partitioned by labels
+type HistogramVec struct {
+	*vec[Histogram]
+}
+
+// NewHistogramVec registers a histogram family with Default; buckets must be sorted
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{newVec(name, help, labels, func() *Histogram {
+		return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+	})}
+	Default.register(h)
+	return h
+}
+
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return h.with(values)
+}
+
+// Count adds the observation counts of the histograms whose label values match; nil matches all
+func (h *HistogramVec) Count(match func(values []string) bool) uint64 {
+	return uint64(h.sum(match, func(child *Histogram) float64 { return float64(child.Count()) }))
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	writeHeader(w, h.metric, h.help, "histogram")
+	h.each(func(labels string, child *Histogram) {
+		child.mu.Lock()
+		counts := append([]uint64(nil), child.counts...)
+		sum, count := child.sum, child.count
+		child.mu.Unlock()
+
+		for i, bound := range child.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metric, withLabel(labels, "le", formatFloat(bound)), counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metric, withLabel(labels, "le", "+Inf"), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metric, labels, formatFloat(sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metric, labels, count)
+	})
+}
+
+// GaugeFunc is a gauge whose value is computed at scrape time
+type GaugeFunc struct {
+	metric string
+	help   string
+	fn     func() (float64, error)
+}
+
+// NewGaugeFunc registers a gauge with Default; scrapes skip it when fn fails
+func NewGaugeFunc(name, help string, fn func() (float64, error)) *GaugeFunc {
+	g := &GaugeFunc{metric: name, help: help, fn: fn}
+	Default.register(g)
+	return g
+}
+
+func (g *GaugeFunc) name() string { return g.metric }
+
+func (g *GaugeFunc) write(w io.Writer) {
+	value, err := g.fn()
+	if err != nil {
+		return
+	}
+	writeHeader(w, g.metric, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.metric, formatFloat(value))
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + labelValueEscaper.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// withLabel appends one label to an already formatted label set
+func withLabel(labels, name, value string) string {
+	pair := name + `="` + value + `"`
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
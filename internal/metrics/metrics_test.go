@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testCounterVec registers with r instead of Default, so tests can reuse metric names
+func testCounterVec(r *Registry, name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, labels, func() *Counter { return &Counter{} })}
+	r.register(c)
+	return c
+}
+
+func testHistogramVec(r *Registry, name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{newVec(name, help, labels, func() *Histogram {
+		return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+	})}
+	r.register(h)
+	return h
+}
+
+func render(r *Registry) string {
+	var b strings.Builder
+	r.WriteText(&b)
+	return b.String()
+}
+
+func TestCounterExposition(t *testing.T) {
+	r := NewRegistry()
+	c := testCounterVec(r, "test_updates_total", "Updates handled.\nBy command.", "command", "result")
+	c.With("/start", "ok").Inc()
+	c.With("/start", "ok").Add(2)
+	c.With(`say "hi"\`, "error").Add(0.5)
+
+	want := `# HELP test_updates_total Updates handled.\nBy command.
+# TYPE test_updates_total counter
+test_updates_total{command="/start",result="ok"} 3
+test_updates_total{command="say \"hi\"\\",result="error"} 0.5
+`
+	if got := render(r); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestCounterWithoutLabels(t *testing.T) {
+	r := NewRegistry()
+	testCounterVec(r, "test_bytes_total", "Bytes.").With().Add(1e9)
+
+	want := "# HELP test_bytes_total Bytes.\n# TYPE test_bytes_total counter\ntest_bytes_total 1e+09\n"
+	if got := render(r); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogramExposition(t *testing.T) {
+	r := NewRegistry()
+	h := testHistogramVec(r, "test_duration_seconds", "Duration.", []float64{0.1, 1}, "result")
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		h.With("ok").Observe(v)
+	}
+
+	want := `# HELP test_duration_seconds Duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{result="ok",le="0.1"} 2
+test_duration_seconds_bucket{result="ok",le="1"} 3
+test_duration_seconds_bucket{result="ok",le="+Inf"} 4
+test_duration_seconds_sum{result="ok"} 3.65
+test_duration_seconds_count{result="ok"} 4
+`
+	if got := render(r); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+	if n := h.Count(nil); n != 4 {
+		t.Fatalf("count %d, want 4", n)
+	}
+}
+
+func TestHistogramWithoutLabels(t *testing.T) {
+	r := NewRegistry()
+	testHistogramVec(r, "test_seconds", "Seconds.", []float64{1}).With().Observe(2)
+
+	out := render(r)
+	for _, line := range []string{`test_seconds_bucket{le="1"} 0`, `test_seconds_bucket{le="+Inf"} 1`, "test_seconds_sum 2", "test_seconds_count 1"} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in\n%s", line, out)
+		}
+	}
+}
+
+func TestFamiliesAndChildrenAreSorted(t *testing.T) {
+	r := NewRegistry()
+	b := testCounterVec(r, "test_b_total", "B.", "kind")
+	testCounterVec(r, "test_a_total", "A.").With().Inc()
+	b.With("z").Inc()
+	b.With("a").Inc()
+
+	out := render(r)
+	if strings.Index(out, "test_a_total") > strings.Index(out, "test_b_total") {
+		t.Errorf("families not sorted by name:\n%s", out)
+	}
+	if strings.Index(out, `kind="a"`) > strings.Index(out, `kind="z"`) {
+		t.Errorf("children not sorted by label values:\n%s", out)
+	}
+}
+
+func TestGaugeFunc(t *testing.T) {
+	r := NewRegistry()
+	r.register(&GaugeFunc{metric: "test_queue_depth", help: "Queue depth.", fn: func() (float64, error) { return 7, nil }})
+	r.register(&GaugeFunc{metric: "test_broken", help: "Broken.", fn: func() (float64, error) { return 0, errors.New("down") }})
+
+	want := "# HELP test_queue_depth Queue depth.\n# TYPE test_queue_depth gauge\ntest_queue_depth 7\n"
+	if got := render(r); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSumMatchesLabels(t *testing.T) {
+	c := testCounterVec(NewRegistry(), "test_total", "Test.", "result")
+	c.With("ok").Add(3)
+	c.With("error").Add(2)
+
+	if got := c.Sum(nil); got != 5 {
+		t.Errorf("sum of all: %v, want 5", got)
+	}
+	if got := c.Sum(func(values []string) bool { return values[0] == "error" }); got != 2 {
+		t.Errorf("sum of errors: %v, want 2", got)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	testCounterVec(r, "test_total", "Test.").With().Inc()
+
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("content type %q", ct)
+	}
+	if !strings.Contains(w.Body.String(), "test_total 1\n") {
+		t.Errorf("body\n%s", w.Body)
+	}
+}
+
+func TestDuplicateMetricPanics(t *testing.T) {
+	r := NewRegistry()
+	testCounterVec(r, "test_total", "Test.")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("registering a duplicate name did not panic")
+		}
+	}()
+	testCounterVec(r, "test_total", "Test.")
+}
+
+func TestWrongLabelCountPanics(t *testing.T) {
+	c := testCounterVec(NewRegistry(), "test_total", "Test.", "result")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("wrong label count did not panic")
+		}
+	}()
+	c.With("ok", "extra")
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+)
+
+// AdminAPIKey authenticates the admin REST API; the key itself is never stored
+type AdminAPIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	CreatedBy  int64      `json:"created_by"` // admin who created the key, zero if they were deleted
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// Broadcast states
+const (
+	BroadcastPending     = "pending"
+	BroadcastRunning     = "running"
+	BroadcastDone        = "done"
+	BroadcastInterrupted = "interrupted" // the sender shut down or crashed while sending
+)
+
+// Broadcast is a message sent to every user, or to the users of one language
+type Broadcast struct {
+	ID           int        `json:"id"`
+	Message      string     `json:"message"`
+	LanguageCode string     `json:"language_code"` // empty for all users
+	ParseMode    string     `json:"parse_mode"`    // empty for plain text
+	Status       string     `json:"status"`
+	Total        int        `json:"total"`
+	Sent         int        `json:"sent"`
+	Failed       int        `json:"failed"`
+	CreatedBy    string     `json:"created_by"`
+	CreatedAt    time.Time  `json:"created_at"`
+	FinishedAt   *time.Time `json:"finished_at"`
+}
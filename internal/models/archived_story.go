@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// ArchivedStory is a story already uploaded to the archive channel, reusable by its FileID
+type ArchivedStory struct {
+	ID               int       `json:"id"`
+	Target           string    `json:"target"`
+	StoryID          int64     `json:"story_id"`
+	MediaType        string    `json:"media_type"` // photo, video
+	FileID           string    `json:"file_id"`
+	Caption          string    `json:"caption"`
+	StoryDate        time.Time `json:"story_date"`
+	ArchiveMessageID int       `json:"archive_message_id"`
+	CreatedAt        time.Time `json:"created_at"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// Where group requests are answered
+const (
+	DeliveryGroup   = "group"
+	DeliveryPrivate = "private"
+)
+
+// Chat is a group the bot has been used in
+type Chat struct {
+	ID        int64     `json:"id"`
+	Title     string    `json:"title"`
+	Type      string    `json:"type"`
+	Delivery  string    `json:"delivery"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+)
+
+// Delivery job states
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// DeliveryJob is a queued story delivery, shared by all instances
+type DeliveryJob struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Payload   []byte    `json:"payload"`
+	Status    string    `json:"status"`
+	Attempts  int       `json:"attempts"`
+	CreatedAt time.Time `json:"created_at"`
+}
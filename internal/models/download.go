@@ -10,4 +10,7 @@ type Download struct {
 	Input     string    `json:"input"`
 	Status    string    `json:"status"`
 	CreatedAt time.Time `json:"created_at"`
+
+	// StoryCount is the number of archived stories linked to the download (history only)
+	StoryCount int `json:"story_count"`
 }
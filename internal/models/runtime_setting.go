@@ -0,0 +1,14 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// RuntimeSetting is an operator override of a configuration value, changeable without a redeploy
+type RuntimeSetting struct {
+	Key       string        `json:"key"`
+	Value     string        `json:"value"`
+	UpdatedBy sql.NullInt64 `json:"updated_by"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
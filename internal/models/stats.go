@@ -0,0 +1,76 @@
+package models
+
+import (
+	"time"
+)
+
+// DailyStat holds the activity of one calendar day
+type DailyStat struct {
+	Day              time.Time `json:"day"`
+	NewUsers         int       `json:"new_users"`
+	ActiveUsers      int       `json:"active_users"` // distinct users who sent a request
+	Requests         int       `json:"requests"`
+	Succeeded        int       `json:"succeeded"`
+	Failed           int       `json:"failed"`
+	StoriesDelivered int       `json:"stories_delivered"`
+}
+
+// SuccessRate is the share of finished requests that succeeded, in percent; ok is false without any
+func (d DailyStat) SuccessRate() (rate float64, ok bool) {
+	if d.Succeeded+d.Failed == 0 {
+		return 0, false
+	}
+	return float64(d.Succeeded) * 100 / float64(d.Succeeded+d.Failed), true
+}
+
+// StatsRange is the daily series of a date range, one entry per day including empty ones
+type StatsRange struct {
+	From        time.Time   `json:"from"`
+	To          time.Time   `json:"to"`
+	Days        []DailyStat `json:"days"`
+	ActiveUsers int         `json:"active_users"` // distinct over the whole range, not the sum of days
+}
+
+// Totals sums the daily series; ActiveUsers is taken from the range
+func (r *StatsRange) Totals() DailyStat {
+	total := DailyStat{Day: r.From, ActiveUsers: r.ActiveUsers}
+	for _, d := range r.Days {
+		total.NewUsers += d.NewUsers
+		total.Requests += d.Requests
+		total.Succeeded += d.Succeeded
+		total.Failed += d.Failed
+		total.StoriesDelivered += d.StoriesDelivered
+	}
+	return total
+}
+
+// TargetStat counts the requests for one normalized target
+type TargetStat struct {
+	Target     string `json:"target"`
+	Requests   int    `json:"requests"`
+	Requesters int    `json:"requesters"` // distinct users
+	Succeeded  int    `json:"succeeded"`
+}
+
+// LanguageStat counts users by language_code; an empty code means no language was chosen
+type LanguageStat struct {
+	LanguageCode string `json:"language_code"`
+	Users        int    `json:"users"`
+}
+
+// Funnel follows the users who signed up in a range through their first steps
+type Funnel struct {
+	Started       int `json:"started"`
+	ChoseLanguage int `json:"chose_language"`
+	FirstSearch   int `json:"first_search"`
+	FirstSuccess  int `json:"first_success"`
+	Premium       int `json:"premium"`
+}
+
+// RetentionCohort is the users who signed up in one week; Retained[i] of them sent a request
+// i weeks later, up to the current week
+type RetentionCohort struct {
+	Week     time.Time `json:"week"`
+	Users    int       `json:"users"`
+	Retained []int     `json:"retained"`
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+)
+
+// How stories are sent to the user
+const (
+	DeliveryStyleIndividual = "individual"
+	DeliveryStyleAlbum      = "album"
+)
+
+// What the caption of a delivered story contains
+const (
+	CaptionFull = "full" // story caption and date
+	CaptionDate = "date" // date only
+	CaptionNone = "none"
+)
+
+// UserSettings holds per-user preferences; the language stays in users.language_code
+type UserSettings struct {
+	UserID         int64     `json:"user_id"`
+	DeliveryStyle  string    `json:"delivery_style"`
+	CaptionFormat  string    `json:"caption_format"`
+	IncludeArchive bool      `json:"include_archive"`
+	Timezone       string    `json:"timezone"`
+	Notifications  bool      `json:"notifications"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// DefaultUserSettings returns the settings of a user who never opened /settings
+func DefaultUserSettings(userID int64) *UserSettings {
+	return &UserSettings{
+		UserID:         userID,
+		DeliveryStyle:  DeliveryStyleIndividual,
+		CaptionFormat:  CaptionFull,
+		IncludeArchive: true,
+		Timezone:       "UTC",
+		Notifications:  true,
+	}
+}
+
+// Location returns the user's timezone, falling back to UTC
+func (s *UserSettings) Location() *time.Location {
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
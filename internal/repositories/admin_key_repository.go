@@ -0,0 +1,90 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/models"
+)
+
+type AdminKeyRepository struct {
+	DB *sql.DB
+}
+
+func NewAdminKeyRepository(db *sql.DB) *AdminKeyRepository {
+	return &AdminKeyRepository{DB: db}
+}
+
+const adminKeyColumns = `id, name, prefix, created_by, created_at, last_used_at, revoked_at`
+
+func scanAdminKey(row interface{ Scan(...any) error }) (*models.AdminAPIKey, error) {
+	key := &models.AdminAPIKey{}
+	var createdBy sql.NullInt64
+	if err := row.Scan(&key.ID, &key.Name, &key.Prefix, &createdBy, &key.CreatedAt, &key.LastUsedAt, &key.RevokedAt); err != nil {
+		return nil, err
+	}
+	key.CreatedBy = createdBy.Int64
+	return key, nil
+}
+
+func (r *AdminKeyRepository) Create(ctx context.Context, name, prefix, hash string, createdBy int64) (*models.AdminAPIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `INSERT INTO admin_api_keys (name, prefix, key_hash, created_by, created_at) VALUES ($1, $2, $3, $4, NOW()) RETURNING ` + adminKeyColumns
+	return scanAdminKey(r.DB.QueryRowContext(ctx, query, name, prefix, hash, createdBy))
+}
+
+// Use returns the live key with the given hash and records that it was used; nil when there is none.
+// A key only lives while its creator is still an admin.
+func (r *AdminKeyRepository) Use(ctx context.Context, hash string) (*models.AdminAPIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `UPDATE admin_api_keys SET last_used_at = NOW()
+		WHERE key_hash = $1 AND revoked_at IS NULL
+		AND created_by IN (SELECT id FROM users WHERE role = 'admin')
+		RETURNING ` + adminKeyColumns
+	key, err := scanAdminKey(r.DB.QueryRowContext(ctx, query, hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return key, err
+}
+
+// List returns every key including revoked ones, newest first
+func (r *AdminKeyRepository) List(ctx context.Context) ([]models.AdminAPIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, `SELECT `+adminKeyColumns+` FROM admin_api_keys ORDER BY id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.AdminAPIKey
+	for rows.Next() {
+		key, err := scanAdminKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// Revoke disables a key; false if it does not exist or is already revoked
+func (r *AdminKeyRepository) Revoke(ctx context.Context, id int) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, `UPDATE admin_api_keys SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,50 @@
+package repositories_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/dbtest"
+	"github.com/bbr/telestory-api-based/internal/repositories"
+)
+
+func TestAdminKeyNeedsAdminCreator(t *testing.T) {
+	db := dbtest.Open(t)
+	repo := repositories.NewAdminKeyRepository(db)
+	users := repositories.NewUserRepository(db)
+	userID := testUser(t, db)
+	ctx := context.Background()
+
+	hash := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	t.Cleanup(func() { db.Exec(`DELETE FROM admin_api_keys WHERE key_hash = $1`, hash) })
+
+	if _, err := users.UpdateRole(ctx, userID, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	created, err := repo.Create(ctx, "test", "tsk_test", hash, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, err := repo.Use(ctx, hash); err != nil || key == nil || key.ID != created.ID {
+		t.Fatalf("key of an admin: %+v, %v", key, err)
+	}
+
+	if _, err := users.UpdateRole(ctx, userID, "user"); err != nil {
+		t.Fatal(err)
+	}
+	if key, err := repo.Use(ctx, hash); err != nil || key != nil {
+		t.Fatalf("key of a demoted admin: %+v, %v", key, err)
+	}
+
+	if _, err := users.UpdateRole(ctx, userID, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := repo.Revoke(ctx, created.ID); err != nil || !ok {
+		t.Fatalf("revoke: %v, %v", ok, err)
+	}
+	if key, err := repo.Use(ctx, hash); err != nil || key != nil {
+		t.Fatalf("revoked key: %+v, %v", key, err)
+	}
+}
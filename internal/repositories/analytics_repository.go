@@ -0,0 +1,280 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/models"
+)
+
+type AnalyticsRepository struct {
+	DB *sql.DB
+}
+
+func NewAnalyticsRepository(db *sql.DB) *AnalyticsRepository {
+	return &AnalyticsRepository{DB: db}
+}
+
+// dailyAggregates returns CTEs new_users, requests and stories with per-day counts of the live
+// tables for the days between the SQL date expressions from and to, inclusive
+func dailyAggregates(from, to string) string {
+	return `
+		new_users AS (
+			SELECT created_at::date AS day, COUNT(*) AS n
+			FROM users
+			WHERE created_at >= ` + from + ` AND created_at < ` + to + ` + 1
+			GROUP BY 1
+		), requests AS (
+			SELECT created_at::date AS day,
+				COUNT(DISTINCT user_id) AS active,
+				COUNT(*) AS total,
+				COUNT(*) FILTER (WHERE status = 'success') AS succeeded,
+				COUNT(*) FILTER (WHERE status = 'failed') AS failed
+			FROM downloads
+			WHERE created_at >= ` + from + ` AND created_at < ` + to + ` + 1
+			GROUP BY 1
+		), stories AS (
+			SELECT d.created_at::date AS day, COUNT(*) AS n
+			FROM download_stories ds
+			JOIN downloads d ON d.id = ds.download_id
+			WHERE d.created_at >= ` + from + ` AND d.created_at < ` + to + ` + 1
+			GROUP BY 1
+		)`
+}
+
+// dailyColumns selects the counts of days.day from the dailyAggregates CTEs
+const dailyColumns = `
+	COALESCE(nu.n, 0), COALESCE(rq.active, 0), COALESCE(rq.total, 0),
+	COALESCE(rq.succeeded, 0), COALESCE(rq.failed, 0), COALESCE(st.n, 0)`
+
+const dailyJoins = `
+	LEFT JOIN new_users nu ON nu.day = days.day
+	LEFT JOIN requests rq ON rq.day = days.day
+	LEFT JOIN stories st ON st.day = days.day`
+
+// DailyStats returns every series of the inclusive date range in one query. Days before today
+// come from daily_stats; today and days without a rollup are aggregated from the live tables.
+// Days follow the database time zone, like the daily download limit.
+func (r *AnalyticsRepository) DailyStats(ctx context.Context, from, to time.Time) (*models.StatsRange, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	// days only holds the days aggregated live. Distinct users do not add up across days,
+	// so the range total is always counted live.
+	query := `
+		WITH days AS (
+			SELECT d::date AS day FROM generate_series($1::date, $2::date, INTERVAL '1 day') d
+			WHERE d::date >= CURRENT_DATE OR NOT EXISTS (SELECT 1 FROM daily_stats s WHERE s.day = d::date)
+		), ` + dailyAggregates(`(SELECT MIN(day) FROM days)`, `$2::date`) + `
+		SELECT series.*, (
+			SELECT COUNT(DISTINCT user_id) FROM downloads
+			WHERE created_at >= $1::date AND created_at < $2::date + 1
+		)
+		FROM (
+			SELECT days.day, ` + dailyColumns + `
+			FROM days ` + dailyJoins + `
+			UNION ALL
+			SELECT day, new_users, active_users, requests, succeeded, failed, stories_delivered
+			FROM daily_stats
+			WHERE day >= $1::date AND day <= $2::date AND day < CURRENT_DATE
+		) series
+		ORDER BY 1
+	`
+	rows, err := r.DB.QueryContext(ctx, query, from.Format(time.DateOnly), to.Format(time.DateOnly))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := &models.StatsRange{From: from, To: to}
+	for rows.Next() {
+		var d models.DailyStat
+		if err := rows.Scan(&d.Day, &d.NewUsers, &d.ActiveUsers, &d.Requests, &d.Succeeded, &d.Failed, &d.StoriesDelivered, &stats.ActiveUsers); err != nil {
+			return nil, err
+		}
+		stats.Days = append(stats.Days, d)
+	}
+	return stats, rows.Err()
+}
+
+// Rollup recomputes daily_stats for the inclusive date range from the live tables.
+// Today and later days are skipped since they are still changing. Returns the days written.
+func (r *AnalyticsRepository) Rollup(ctx context.Context, from, to time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	query := `
+		WITH days AS (
+			SELECT d::date AS day FROM generate_series($1::date, LEAST($2::date, CURRENT_DATE - 1), INTERVAL '1 day') d
+		), ` + dailyAggregates(`$1::date`, `$2::date`) + `
+		INSERT INTO daily_stats (day, new_users, active_users, requests, succeeded, failed, stories_delivered, computed_at)
+		SELECT days.day, ` + dailyColumns + `, NOW()
+		FROM days ` + dailyJoins + `
+		ON CONFLICT (day) DO UPDATE SET
+			new_users = EXCLUDED.new_users,
+			active_users = EXCLUDED.active_users,
+			requests = EXCLUDED.requests,
+			succeeded = EXCLUDED.succeeded,
+			failed = EXCLUDED.failed,
+			stories_delivered = EXCLUDED.stories_delivered,
+			computed_at = EXCLUDED.computed_at
+	`
+	res, err := r.DB.ExecContext(ctx, query, from.Format(time.DateOnly), to.Format(time.DateOnly))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RollupBounds returns the latest rolled up day and the first day with any user, each
+// zero when there is none
+func (r *AnalyticsRepository) RollupBounds(ctx context.Context) (latest, first time.Time, err error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var latestDay, firstDay sql.NullTime
+	err = r.DB.QueryRowContext(ctx,
+		`SELECT (SELECT MAX(day) FROM daily_stats), (SELECT MIN(created_at)::date FROM users)`,
+	).Scan(&latestDay, &firstDay)
+	return latestDay.Time, firstDay.Time, err
+}
+
+// targetExpr normalizes downloads.input like NormalizeTarget, taking the username out of story links
+const targetExpr = `lower(COALESCE(substring(input from 't(?:elegram)?\.me/([A-Za-z0-9_]+)/s/'), ltrim(btrim(input), '@+')))`
+
+// TopTargets returns the most requested targets of the inclusive date range
+func (r *AnalyticsRepository) TopTargets(ctx context.Context, from, to time.Time, limit int) ([]models.TargetStat, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT ` + targetExpr + ` AS target,
+			COUNT(*), COUNT(DISTINCT user_id), COUNT(*) FILTER (WHERE status = 'success')
+		FROM downloads
+		WHERE created_at >= $1::date AND created_at < $2::date + 1
+		GROUP BY 1
+		ORDER BY 2 DESC, 3 DESC, 1
+		LIMIT $3
+	`
+	rows, err := r.DB.QueryContext(ctx, query, from.Format(time.DateOnly), to.Format(time.DateOnly), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var targets []models.TargetStat
+	for rows.Next() {
+		var t models.TargetStat
+		if err := rows.Scan(&t.Target, &t.Requests, &t.Requesters, &t.Succeeded); err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	return targets, rows.Err()
+}
+
+// Languages counts all users by language, most common first
+func (r *AnalyticsRepository) Languages(ctx context.Context) ([]models.LanguageStat, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, `
+		SELECT COALESCE(language_code, ''), COUNT(*)
+		FROM users
+		GROUP BY 1
+		ORDER BY 2 DESC, 1
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var languages []models.LanguageStat
+	for rows.Next() {
+		var l models.LanguageStat
+		if err := rows.Scan(&l.LanguageCode, &l.Users); err != nil {
+			return nil, err
+		}
+		languages = append(languages, l)
+	}
+	return languages, rows.Err()
+}
+
+// Funnel counts how far the users who signed up in the inclusive date range got
+func (r *AnalyticsRepository) Funnel(ctx context.Context, from, to time.Time) (*models.Funnel, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT COUNT(*),
+			COUNT(*) FILTER (WHERE COALESCE(u.language_code, '') <> ''),
+			COUNT(*) FILTER (WHERE EXISTS (SELECT 1 FROM downloads d WHERE d.user_id = u.id)),
+			COUNT(*) FILTER (WHERE EXISTS (SELECT 1 FROM downloads d WHERE d.user_id = u.id AND d.status = 'success')),
+			COUNT(*) FILTER (WHERE u.premium_expires_at IS NOT NULL)
+		FROM users u
+		WHERE u.created_at >= $1::date AND u.created_at < $2::date + 1
+	`
+	funnel := &models.Funnel{}
+	err := r.DB.QueryRowContext(ctx, query, from.Format(time.DateOnly), to.Format(time.DateOnly)).Scan(
+		&funnel.Started, &funnel.ChoseLanguage, &funnel.FirstSearch, &funnel.FirstSuccess, &funnel.Premium)
+	if err != nil {
+		return nil, err
+	}
+	return funnel, nil
+}
+
+// Retention groups the users who signed up in the last weeks weeks into cohorts by signup week
+// and counts, per week since signup, how many of them sent a request. Weeks start on Monday.
+func (r *AnalyticsRepository) Retention(ctx context.Context, weeks int) ([]models.RetentionCohort, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	// The (week) grouping set is the cohort size, (week, week_offset) the active users per week
+	query := `
+		WITH cohorts AS (
+			SELECT id, date_trunc('week', created_at)::date AS week
+			FROM users
+			WHERE created_at >= date_trunc('week', CURRENT_DATE) - ($1::int - 1) * INTERVAL '1 week'
+		), activity AS (
+			SELECT DISTINCT c.id, (date_trunc('week', d.created_at)::date - c.week) / 7 AS week_offset
+			FROM cohorts c
+			JOIN downloads d ON d.user_id = c.id
+		)
+		SELECT c.week, a.week_offset, GROUPING(a.week_offset) = 1, COUNT(DISTINCT c.id),
+			(date_trunc('week', CURRENT_DATE)::date - c.week) / 7
+		FROM cohorts c
+		LEFT JOIN activity a ON a.id = c.id
+		GROUP BY GROUPING SETS ((c.week), (c.week, a.week_offset))
+		ORDER BY 1
+	`
+	rows, err := r.DB.QueryContext(ctx, query, weeks)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cohorts []models.RetentionCohort
+	for rows.Next() {
+		var (
+			week       time.Time
+			offset     sql.NullInt64
+			total      bool
+			users, age int
+		)
+		if err := rows.Scan(&week, &offset, &total, &users, &age); err != nil {
+			return nil, err
+		}
+		if len(cohorts) == 0 || !cohorts[len(cohorts)-1].Week.Equal(week) {
+			cohorts = append(cohorts, models.RetentionCohort{Week: week, Retained: make([]int, age+1)})
+		}
+		cohort := &cohorts[len(cohorts)-1]
+		switch {
+		case total:
+			cohort.Users = users
+		case offset.Valid && offset.Int64 >= 0 && int(offset.Int64) < len(cohort.Retained):
+			cohort.Retained[offset.Int64] = users
+		}
+	}
+	return cohorts, rows.Err()
+}
@@ -0,0 +1,121 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/models"
+)
+
+type ArchiveRepository struct {
+	DB *sql.DB
+}
+
+func NewArchiveRepository(db *sql.DB) *ArchiveRepository {
+	return &ArchiveRepository{DB: db}
+}
+
+// Save stores an archived story, refreshing the file ID if the story was archived before
+func (r *ArchiveRepository) Save(ctx context.Context, story *models.ArchivedStory) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `
+		INSERT INTO archived_stories (target, story_id, media_type, file_id, caption, story_date, archive_message_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+		ON CONFLICT (target, story_date, media_type) DO UPDATE SET
+			file_id = EXCLUDED.file_id,
+			caption = EXCLUDED.caption,
+			archive_message_id = EXCLUDED.archive_message_id
+		RETURNING id, created_at
+	`
+	return r.DB.QueryRowContext(ctx, query,
+		story.Target,
+		story.StoryID,
+		story.MediaType,
+		story.FileID,
+		story.Caption,
+		story.StoryDate,
+		story.ArchiveMessageID,
+	).Scan(&story.ID, &story.CreatedAt)
+}
+
+// ListByTarget returns archived stories of a target, newest first
+func (r *ArchiveRepository) ListByTarget(ctx context.Context, target string, limit, offset int) ([]models.ArchivedStory, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT id, target, story_id, media_type, file_id, COALESCE(caption, ''), story_date, COALESCE(archive_message_id, 0), created_at
+		FROM archived_stories
+		WHERE target = $1
+		ORDER BY story_date DESC, id DESC
+		LIMIT $2 OFFSET $3
+	`
+	rows, err := r.DB.QueryContext(ctx, query, target, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stories []models.ArchivedStory
+	for rows.Next() {
+		var st models.ArchivedStory
+		if err := rows.Scan(
+			&st.ID,
+			&st.Target,
+			&st.StoryID,
+			&st.MediaType,
+			&st.FileID,
+			&st.Caption,
+			&st.StoryDate,
+			&st.ArchiveMessageID,
+			&st.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		stories = append(stories, st)
+	}
+	return stories, rows.Err()
+}
+
+// ListByDownload returns the archived stories delivered by one of the user's visible downloads
+func (r *ArchiveRepository) ListByDownload(ctx context.Context, userID int64, downloadID int) ([]models.ArchivedStory, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT a.id, a.target, a.story_id, a.media_type, a.file_id, COALESCE(a.caption, ''), a.story_date, COALESCE(a.archive_message_id, 0), a.created_at
+		FROM download_stories ds
+		JOIN downloads d ON d.id = ds.download_id
+		JOIN archived_stories a ON a.id = ds.archived_story_id
+		WHERE ds.download_id = $1 AND d.user_id = $2 AND d.hidden_at IS NULL
+		ORDER BY ds.position
+	`
+	rows, err := r.DB.QueryContext(ctx, query, downloadID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stories []models.ArchivedStory
+	for rows.Next() {
+		var st models.ArchivedStory
+		if err := rows.Scan(
+			&st.ID,
+			&st.Target,
+			&st.StoryID,
+			&st.MediaType,
+			&st.FileID,
+			&st.Caption,
+			&st.StoryDate,
+			&st.ArchiveMessageID,
+			&st.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		stories = append(stories, st)
+	}
+	return stories, rows.Err()
+}
@@ -0,0 +1,116 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/models"
+)
+
+type BroadcastRepository struct {
+	DB *sql.DB
+}
+
+func NewBroadcastRepository(db *sql.DB) *BroadcastRepository {
+	return &BroadcastRepository{DB: db}
+}
+
+const broadcastColumns = `id, message, COALESCE(language_code, ''), parse_mode, status, total, sent, failed, created_by, created_at, finished_at`
+
+func scanBroadcast(row interface{ Scan(...any) error }) (*models.Broadcast, error) {
+	b := &models.Broadcast{}
+	err := row.Scan(&b.ID, &b.Message, &b.LanguageCode, &b.ParseMode, &b.Status, &b.Total, &b.Sent, &b.Failed, &b.CreatedBy, &b.CreatedAt, &b.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (r *BroadcastRepository) Create(ctx context.Context, message, languageCode, parseMode, createdBy string) (*models.Broadcast, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `
+		INSERT INTO broadcasts (message, language_code, parse_mode, status, created_by, created_at)
+		VALUES ($1, NULLIF($2, ''), $3, 'pending', $4, NOW())
+		RETURNING ` + broadcastColumns
+	return scanBroadcast(r.DB.QueryRowContext(ctx, query, message, languageCode, parseMode, createdBy))
+}
+
+func (r *BroadcastRepository) Get(ctx context.Context, id int) (*models.Broadcast, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	return scanBroadcast(r.DB.QueryRowContext(ctx, `SELECT `+broadcastColumns+` FROM broadcasts WHERE id = $1`, id))
+}
+
+// List returns a page of broadcasts, newest first
+func (r *BroadcastRepository) List(ctx context.Context, limit, offset int) ([]models.Broadcast, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, `SELECT `+broadcastColumns+` FROM broadcasts ORDER BY id DESC LIMIT $1 OFFSET $2`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var broadcasts []models.Broadcast
+	for rows.Next() {
+		b, err := scanBroadcast(rows)
+		if err != nil {
+			return nil, err
+		}
+		broadcasts = append(broadcasts, *b)
+	}
+	return broadcasts, rows.Err()
+}
+
+func (r *BroadcastRepository) Count(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var count int
+	err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM broadcasts").Scan(&count)
+	return count, err
+}
+
+// Update stores the progress of a broadcast; finished broadcasts get their finish time
+func (r *BroadcastRepository) Update(ctx context.Context, b *models.Broadcast) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE broadcasts SET status = $2, total = $3, sent = $4, failed = $5, updated_at = NOW(),
+			finished_at = CASE WHEN $2 IN ('done', 'interrupted') THEN NOW() END
+		WHERE id = $1
+	`
+	_, err := r.DB.ExecContext(ctx, query, b.ID, b.Status, b.Total, b.Sent, b.Failed)
+	return err
+}
+
+// Heartbeat shows that the broadcast is still being sent
+func (r *BroadcastRepository) Heartbeat(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := r.DB.ExecContext(ctx, `UPDATE broadcasts SET updated_at = NOW() WHERE id = $1 AND status IN ('pending', 'running')`, id)
+	return err
+}
+
+// InterruptStale marks broadcasts whose sender stopped sending heartbeats as interrupted
+func (r *BroadcastRepository) InterruptStale(ctx context.Context, staleAfter time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE broadcasts SET status = 'interrupted', updated_at = NOW(), finished_at = NOW()
+		WHERE status IN ('pending', 'running') AND updated_at < NOW() - make_interval(secs => $1)
+	`
+	res, err := r.DB.ExecContext(ctx, query, staleAfter.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,45 @@
+package repositories_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/dbtest"
+	"github.com/bbr/telestory-api-based/internal/models"
+	"github.com/bbr/telestory-api-based/internal/repositories"
+)
+
+func TestInterruptStaleBroadcasts(t *testing.T) {
+	db := dbtest.Open(t)
+	repo := repositories.NewBroadcastRepository(db)
+	ctx := context.Background()
+
+	stale, err := repo.Create(ctx, "stale", "", "", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	live, err := repo.Create(ctx, "live", "", "", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec(`DELETE FROM broadcasts WHERE id IN ($1, $2)`, stale.ID, live.ID) })
+
+	stale.Status = models.BroadcastRunning
+	if err := repo.Update(ctx, stale); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`UPDATE broadcasts SET updated_at = NOW() - INTERVAL '10 minutes' WHERE id = $1`, stale.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.InterruptStale(ctx, 2*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := repo.Get(ctx, stale.ID); err != nil || b.Status != models.BroadcastInterrupted || b.FinishedAt == nil {
+		t.Fatalf("stale broadcast: %+v, %v", b, err)
+	}
+	if b, err := repo.Get(ctx, live.ID); err != nil || b.Status != models.BroadcastPending {
+		t.Fatalf("live broadcast: %+v, %v", b, err)
+	}
+}
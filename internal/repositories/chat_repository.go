@@ -0,0 +1,43 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/models"
+)
+
+type ChatRepository struct {
+	DB *sql.DB
+}
+
+func NewChatRepository(db *sql.DB) *ChatRepository {
+	return &ChatRepository{DB: db}
+}
+
+// Upsert stores the chat and returns it with its current settings
+func (r *ChatRepository) Upsert(ctx context.Context, chat *models.Chat) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `
+		INSERT INTO chats (id, title, type, created_at, updated_at)
+		VALUES ($1, $2, $3, NOW(), NOW())
+		ON CONFLICT (id) DO UPDATE SET
+			title = EXCLUDED.title,
+			type = EXCLUDED.type,
+			updated_at = NOW()
+		RETURNING delivery, created_at, updated_at
+	`
+	return r.DB.QueryRowContext(ctx, query, chat.ID, chat.Title, chat.Type).Scan(&chat.Delivery, &chat.CreatedAt, &chat.UpdatedAt)
+}
+
+func (r *ChatRepository) UpdateDelivery(ctx context.Context, id int64, delivery string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `UPDATE chats SET delivery = $1, updated_at = NOW() WHERE id = $2`
+	_, err := r.DB.ExecContext(ctx, query, delivery, id)
+	return err
+}
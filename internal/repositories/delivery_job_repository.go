@@ -0,0 +1,153 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/models"
+)
+
+type DeliveryJobRepository struct {
+	DB *sql.DB
+}
+
+func NewDeliveryJobRepository(db *sql.DB) *DeliveryJobRepository {
+	return &DeliveryJobRepository{DB: db}
+}
+
+func (r *DeliveryJobRepository) Enqueue(ctx context.Context, userID int64, payload []byte) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var id int64
+	query := `INSERT INTO delivery_jobs (user_id, payload, created_at, updated_at) VALUES ($1, $2, NOW(), NOW()) RETURNING id`
+	err := r.DB.QueryRowContext(ctx, query, userID, payload).Scan(&id)
+	return id, err
+}
+
+// Claim marks the oldest queued job as running on instanceID and returns it, or nil if the queue is empty.
+// SKIP LOCKED lets several instances claim concurrently without handing out the same job.
+func (r *DeliveryJobRepository) Claim(ctx context.Context, instanceID string) (*models.DeliveryJob, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE delivery_jobs
+		SET status = 'running', locked_by = $1, locked_at = NOW(), attempts = attempts + 1, updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM delivery_jobs
+			WHERE status = 'queued'
+			ORDER BY id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING id, user_id, payload, status, attempts, created_at
+	`
+	var job models.DeliveryJob
+	err := r.DB.QueryRowContext(ctx, query, instanceID).Scan(&job.ID, &job.UserID, &job.Payload, &job.Status, &job.Attempts, &job.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Heartbeat shows that the job is still being worked on
+func (r *DeliveryJobRepository) Heartbeat(ctx context.Context, id int64, instanceID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `UPDATE delivery_jobs SET locked_at = NOW() WHERE id = $1 AND locked_by = $2 AND status = 'running'`
+	_, err := r.DB.ExecContext(ctx, query, id, instanceID)
+	return err
+}
+
+// Finish records the outcome of a job; lastError is empty on success
+func (r *DeliveryJobRepository) Finish(ctx context.Context, id int64, status, lastError string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `UPDATE delivery_jobs SET status = $2, last_error = NULLIF($3, ''), locked_by = NULL, locked_at = NULL, updated_at = NOW() WHERE id = $1`
+	_, err := r.DB.ExecContext(ctx, query, id, status, lastError)
+	return err
+}
+
+// Requeue puts back a job its instance gave up on while shutting down.
+// The attempt is not counted, since the job itself did not fail.
+func (r *DeliveryJobRepository) Requeue(ctx context.Context, id int64, reason string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE delivery_jobs
+		SET status = 'queued', attempts = GREATEST(attempts - 1, 0), last_error = $2,
+			locked_by = NULL, locked_at = NULL, updated_at = NOW()
+		WHERE id = $1
+	`
+	_, err := r.DB.ExecContext(ctx, query, id, reason)
+	return err
+}
+
+// RequeueStale puts back jobs whose instance stopped sending heartbeats,
+// failing those that already used maxAttempts. It returns how many jobs were requeued and the failed jobs,
+// whose users still wait for an answer.
+func (r *DeliveryJobRepository) RequeueStale(ctx context.Context, staleAfter time.Duration, maxAttempts int) (int64, []models.DeliveryJob, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `
+		UPDATE delivery_jobs
+		SET status = CASE WHEN attempts >= $2 THEN 'failed' ELSE 'queued' END,
+			last_error = 'instance ' || locked_by || ' stopped responding',
+			locked_by = NULL, locked_at = NULL, updated_at = NOW()
+		WHERE status = 'running' AND locked_at < NOW() - make_interval(secs => $1)
+		RETURNING id, user_id, payload, status, attempts, created_at
+	`
+	rows, err := r.DB.QueryContext(ctx, query, staleAfter.Seconds(), maxAttempts)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	var requeued int64
+	var failed []models.DeliveryJob
+	for rows.Next() {
+		var job models.DeliveryJob
+		if err := rows.Scan(&job.ID, &job.UserID, &job.Payload, &job.Status, &job.Attempts, &job.CreatedAt); err != nil {
+			return 0, nil, err
+		}
+		if job.Status == models.JobFailed {
+			failed = append(failed, job)
+		} else {
+			requeued++
+		}
+	}
+	return requeued, failed, rows.Err()
+}
+
+// Purge deletes finished jobs older than the retention period
+func (r *DeliveryJobRepository) Purge(ctx context.Context, olderThan time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `DELETE FROM delivery_jobs WHERE status IN ('done', 'failed') AND updated_at < NOW() - make_interval(secs => $1)`
+	res, err := r.DB.ExecContext(ctx, query, olderThan.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// CountQueued returns how many jobs are waiting for a worker
+func (r *DeliveryJobRepository) CountQueued(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var count int
+	err := r.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM delivery_jobs WHERE status = 'queued'`).Scan(&count)
+	return count, err
+}
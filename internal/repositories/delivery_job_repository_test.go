@@ -0,0 +1,56 @@
+package repositories_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/dbtest"
+	"github.com/bbr/telestory-api-based/internal/models"
+	"github.com/bbr/telestory-api-based/internal/repositories"
+)
+
+func TestRequeueStaleReturnsFailedJobs(t *testing.T) {
+	db := dbtest.Open(t)
+	repo := repositories.NewDeliveryJobRepository(db)
+	userID := testUser(t, db)
+	ctx := context.Background()
+
+	var ids []int64
+	for range 2 {
+		id, err := repo.Enqueue(ctx, userID, []byte(`{"chat_id":1}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	retried, exhausted := ids[0], ids[1]
+	t.Cleanup(func() { db.Exec(`DELETE FROM delivery_jobs WHERE user_id = $1`, userID) })
+
+	_, err := db.Exec(`
+		UPDATE delivery_jobs
+		SET status = 'running', locked_by = 'dead', locked_at = NOW() - INTERVAL '10 minutes',
+			attempts = CASE WHEN id = $2 THEN 3 ELSE 1 END
+		WHERE id IN ($1, $2)
+	`, retried, exhausted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requeued, failed, err := repo.RequeueStale(ctx, 2*time.Minute, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requeued < 1 {
+		t.Errorf("requeued %d jobs, want at least 1", requeued)
+	}
+	i := slices.IndexFunc(failed, func(j models.DeliveryJob) bool { return j.ID == exhausted })
+	// JSONB returns the payload in its own formatting
+	if i < 0 || string(failed[i].Payload) != `{"chat_id": 1}` {
+		t.Fatalf("failed jobs %+v, want job %d with its payload", failed, exhausted)
+	}
+	if slices.ContainsFunc(failed, func(j models.DeliveryJob) bool { return j.ID == retried }) {
+		t.Fatalf("job %d with attempts left was failed", retried)
+	}
+}
@@ -3,6 +3,8 @@ package repositories
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/bbr/telestory-api-based/internal/models"
@@ -16,16 +18,16 @@ func NewDownloadRepository(db *sql.DB) *DownloadRepository {
 	return &DownloadRepository{DB: db}
 }
 
-func (r *DownloadRepository) Create(download *models.Download) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *DownloadRepository) Create(ctx context.Context, download *models.Download) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	query := `INSERT INTO downloads (user_id, input, status, created_at) VALUES ($1, $2, $3, NOW()) RETURNING id, created_at`
 	return r.DB.QueryRowContext(ctx, query, download.UserID, download.Input, download.Status).Scan(&download.ID, &download.CreatedAt)
 }
 
-func (r *DownloadRepository) CountToday(userID int64) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *DownloadRepository) CountToday(ctx context.Context, userID int64) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	query := `
@@ -40,38 +42,287 @@ func (r *DownloadRepository) CountToday(userID int64) (int, error) {
 	return count, err
 }
 
-func (r *DownloadRepository) CountTotalDownloads() (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// LinkStories records which archived stories were delivered by a download
+func (r *DownloadRepository) LinkStories(ctx context.Context, downloadID int, archivedStoryIDs []int) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	var count int
-	err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM downloads").Scan(&count)
-	return count, err
+	query := `INSERT INTO download_stories (download_id, archived_story_id, position) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`
+	for i, id := range archivedStoryIDs {
+		if _, err := r.DB.ExecContext(ctx, query, downloadID, id, i); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (r *DownloadRepository) CountDownloadsByStatus(status string) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// ListByUser returns the visible history of a user, newest first
+func (r *DownloadRepository) ListByUser(ctx context.Context, userID int64, limit, offset int) ([]models.Download, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	var count int
-	err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM downloads WHERE status = $1", status).Scan(&count)
-	return count, err
+	query := `
+		SELECT d.id, d.user_id, d.input, d.status, d.created_at, COUNT(ds.archived_story_id)
+		FROM downloads d
+		LEFT JOIN download_stories ds ON ds.download_id = d.id
+		WHERE d.user_id = $1 AND d.hidden_at IS NULL
+		GROUP BY d.id
+		ORDER BY d.created_at DESC, d.id DESC
+		LIMIT $2 OFFSET $3
+	`
+	rows, err := r.DB.QueryContext(ctx, query, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var downloads []models.Download
+	for rows.Next() {
+		var d models.Download
+		if err := rows.Scan(&d.ID, &d.UserID, &d.Input, &d.Status, &d.CreatedAt, &d.StoryCount); err != nil {
+			return nil, err
+		}
+		downloads = append(downloads, d)
+	}
+	return downloads, rows.Err()
 }
 
-func (r *DownloadRepository) CountDownloadsToday() (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *DownloadRepository) CountByUser(ctx context.Context, userID int64) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var count int
-	err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM downloads WHERE created_at >= CURRENT_DATE").Scan(&count)
+	err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM downloads WHERE user_id = $1 AND hidden_at IS NULL", userID).Scan(&count)
 	return count, err
 }
 
-func (r *DownloadRepository) CountDownloadsTodayByStatus(status string) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// DownloadFilter narrows Search; zero values match every download
+type DownloadFilter struct {
+	UserID int64
+	Status string
+	From   string // inclusive dates as YYYY-MM-DD
+	To     string
+}
+
+func (f DownloadFilter) where() (string, []any) {
+	var conds []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if f.UserID != 0 {
+		conds = append(conds, "d.user_id = "+arg(f.UserID))
+	}
+	if f.Status != "" {
+		conds = append(conds, "d.status = "+arg(f.Status))
+	}
+	if f.From != "" {
+		conds = append(conds, "d.created_at >= "+arg(f.From)+"::date")
+	}
+	if f.To != "" {
+		conds = append(conds, "d.created_at < "+arg(f.To)+"::date + 1")
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// Search returns a page of matching downloads of all users, newest first,
+// including entries users hid from their history
+func (r *DownloadRepository) Search(ctx context.Context, filter DownloadFilter, limit, offset int) ([]models.Download, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	where, args := filter.where()
+	query := fmt.Sprintf(`
+		SELECT d.id, d.user_id, d.input, d.status, d.created_at, COUNT(ds.archived_story_id)
+		FROM downloads d
+		LEFT JOIN download_stories ds ON ds.download_id = d.id
+		%s
+		GROUP BY d.id
+		ORDER BY d.created_at DESC, d.id DESC
+		LIMIT $%d OFFSET $%d
+	`, where, len(args)+1, len(args)+2)
+	rows, err := r.DB.QueryContext(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var downloads []models.Download
+	for rows.Next() {
+		var d models.Download
+		if err := rows.Scan(&d.ID, &d.UserID, &d.Input, &d.Status, &d.CreatedAt, &d.StoryCount); err != nil {
+			return nil, err
+		}
+		downloads = append(downloads, d)
+	}
+	return downloads, rows.Err()
+}
+
+func (r *DownloadRepository) CountSearch(ctx context.Context, filter DownloadFilter) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
+	where, args := filter.where()
 	var count int
-	err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM downloads WHERE status = $1 AND created_at >= CURRENT_DATE", status).Scan(&count)
+	err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM downloads d"+where, args...).Scan(&count)
 	return count, err
 }
+
+// Hide removes a download from the user's history without affecting limits
+func (r *DownloadRepository) Hide(ctx context.Context, userID int64, id int) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, "UPDATE downloads SET hidden_at = NOW() WHERE id = $1 AND user_id = $2 AND hidden_at IS NULL", id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ReservationTTL bounds how long an unfinished reservation holds a quota slot,
+// e.g. when the user never answers the story selection keyboard
+const ReservationTTL = 30 * time.Minute
+
+// ReserveResult is the outcome of Reserve
+type ReserveResult struct {
+	ReservationID int64         // non-zero when a slot was reserved
+	RetryAfter    time.Duration // remaining cooldown when refused by the cooldown
+	Used          int           // successful downloads today plus live reservations
+}
+
+// Reserve checks the cooldown and the daily limit and takes a slot in one transaction.
+// The user row is locked so concurrent requests of the same user are serialized.
+// A dailyLimit of zero skips the quota check. Cooldowns are measured with the database clock.
+func (r *DownloadRepository) Reserve(ctx context.Context, userID int64, cooldown time.Duration, dailyLimit int) (ReserveResult, error) {
+	var result ReserveResult
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	var sinceLast sql.NullFloat64
+	err = tx.QueryRowContext(ctx,
+		`SELECT EXTRACT(EPOCH FROM (NOW() - last_active_at)) FROM users WHERE id = $1 FOR UPDATE`,
+		userID).Scan(&sinceLast)
+	if err != nil {
+		return result, err
+	}
+	if sinceLast.Valid {
+		elapsed := time.Duration(sinceLast.Float64 * float64(time.Second))
+		if elapsed < cooldown {
+			result.RetryAfter = cooldown - elapsed
+			return result, nil
+		}
+	}
+
+	if dailyLimit > 0 {
+		query := `
+			SELECT
+				(SELECT COUNT(*) FROM downloads
+				 WHERE user_id = $1 AND status = 'success' AND created_at >= CURRENT_DATE) +
+				(SELECT COUNT(*) FROM download_reservations
+				 WHERE user_id = $1 AND created_at > NOW() - make_interval(secs => $2))
+		`
+		if err := tx.QueryRowContext(ctx, query, userID, ReservationTTL.Seconds()).Scan(&result.Used); err != nil {
+			return result, err
+		}
+		if result.Used >= dailyLimit {
+			return result, nil
+		}
+	}
+
+	if err := tx.QueryRowContext(ctx,
+		`INSERT INTO download_reservations (user_id, created_at) VALUES ($1, NOW()) RETURNING id`,
+		userID).Scan(&result.ReservationID); err != nil {
+		return result, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET last_active_at = NOW() WHERE id = $1`, userID); err != nil {
+		return result, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ReserveResult{}, err
+	}
+	return result, nil
+}
+
+// Release frees a reservation that will not turn into a successful download
+func (r *DownloadRepository) Release(ctx context.Context, reservationID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := r.DB.ExecContext(ctx, `DELETE FROM download_reservations WHERE id = $1`, reservationID)
+	return err
+}
+
+// SetSelectionToken links a reservation to the keyboard waiting for the user's choice
+func (r *DownloadRepository) SetSelectionToken(ctx context.Context, reservationID int64, token string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := r.DB.ExecContext(ctx, `UPDATE download_reservations SET selection_token = $2 WHERE id = $1`, reservationID, token)
+	return err
+}
+
+// ClaimSelection unlinks token from its reservation once the user chose; false means
+// the reservation was released in the meantime
+func (r *DownloadRepository) ClaimSelection(ctx context.Context, token string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, `UPDATE download_reservations SET selection_token = NULL WHERE selection_token = $1`, token)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ReleaseSelection frees the reservation still linked to token, e.g. when the instance that held
+// the selection is gone; false means there was none
+func (r *DownloadRepository) ReleaseSelection(ctx context.Context, token string, userID int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, `DELETE FROM download_reservations WHERE selection_token = $1 AND user_id = $2`, token, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// CreateReserved records the download and drops its reservation atomically,
+// so the slot is never counted twice or not at all
+func (r *DownloadRepository) CreateReserved(ctx context.Context, download *models.Download, reservationID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO downloads (user_id, input, status, created_at) VALUES ($1, $2, $3, NOW()) RETURNING id, created_at`
+	if err := tx.QueryRowContext(ctx, query, download.UserID, download.Input, download.Status).Scan(&download.ID, &download.CreatedAt); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM download_reservations WHERE id = $1`, reservationID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
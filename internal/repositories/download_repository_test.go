@@ -0,0 +1,162 @@
+package repositories_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/dbtest"
+	"github.com/bbr/telestory-api-based/internal/models"
+	"github.com/bbr/telestory-api-based/internal/repositories"
+)
+
+// testUser inserts a fresh user and removes it with its downloads when the test ends
+func testUser(t *testing.T, db *sql.DB) int64 {
+	t.Helper()
+	ctx := context.Background()
+	id := time.Now().UnixNano() % 1_000_000_000_000
+	if err := repositories.NewUserRepository(db).Insert(ctx, &models.User{ID: id, FirstName: "Test"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM download_reservations WHERE user_id = $1`, id)
+		db.Exec(`DELETE FROM downloads WHERE user_id = $1`, id)
+		db.Exec(`DELETE FROM users WHERE id = $1`, id)
+	})
+	return id
+}
+
+// reserveParallel runs n concurrent Reserve calls for one user
+func reserveParallel(t *testing.T, repo *repositories.DownloadRepository, userID int64, n int, cooldown time.Duration, dailyLimit int) []repositories.ReserveResult {
+	t.Helper()
+	results := make([]repositories.ReserveResult, n)
+	errs := make([]error, n)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			results[i], errs[i] = repo.Reserve(context.Background(), userID, cooldown, dailyLimit)
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return results
+}
+
+func TestReserveDailyLimitUnderConcurrency(t *testing.T) {
+	db := dbtest.Open(t)
+	repo := repositories.NewDownloadRepository(db)
+	userID := testUser(t, db)
+
+	const dailyLimit, calls = 3, 20
+	reserved, limited := 0, 0
+	for _, r := range reserveParallel(t, repo, userID, calls, 0, dailyLimit) {
+		switch {
+		case r.ReservationID != 0:
+			reserved++
+		case r.RetryAfter == 0 && r.Used >= dailyLimit:
+			limited++
+		default:
+			t.Errorf("unexpected result %+v", r)
+		}
+	}
+	if reserved != dailyLimit || limited != calls-dailyLimit {
+		t.Fatalf("reserved %d and limited %d of %d calls, want %d and %d", reserved, limited, calls, dailyLimit, calls-dailyLimit)
+	}
+}
+
+func TestReserveCooldownUnderConcurrency(t *testing.T) {
+	db := dbtest.Open(t)
+	repo := repositories.NewDownloadRepository(db)
+	userID := testUser(t, db)
+
+	const calls = 20
+	reserved, cooling := 0, 0
+	for _, r := range reserveParallel(t, repo, userID, calls, time.Hour, 3) {
+		switch {
+		case r.ReservationID != 0:
+			reserved++
+		case r.RetryAfter > 0:
+			cooling++
+		default:
+			t.Errorf("unexpected result %+v", r)
+		}
+	}
+	if reserved != 1 || cooling != calls-1 {
+		t.Fatalf("reserved %d and cooled down %d of %d calls, want 1 and %d", reserved, cooling, calls, calls-1)
+	}
+}
+
+func TestReleaseFreesTheSlot(t *testing.T) {
+	db := dbtest.Open(t)
+	repo := repositories.NewDownloadRepository(db)
+	userID := testUser(t, db)
+	ctx := context.Background()
+
+	first, err := repo.Reserve(ctx, userID, 0, 1)
+	if err != nil || first.ReservationID == 0 {
+		t.Fatalf("first reservation: %+v, %v", first, err)
+	}
+	if r, err := repo.Reserve(ctx, userID, 0, 1); err != nil || r.ReservationID != 0 {
+		t.Fatalf("reservation over the limit: %+v, %v", r, err)
+	}
+	if err := repo.Release(ctx, first.ReservationID); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := repo.Reserve(ctx, userID, 0, 1); err != nil || r.ReservationID == 0 {
+		t.Fatalf("reservation after release: %+v, %v", r, err)
+	}
+}
+
+func TestSelectionTokens(t *testing.T) {
+	db := dbtest.Open(t)
+	repo := repositories.NewDownloadRepository(db)
+	userID := testUser(t, db)
+	ctx := context.Background()
+
+	token := fmt.Sprintf("test-%d", userID)
+	reserve := func() int64 {
+		t.Helper()
+		r, err := repo.Reserve(ctx, userID, 0, 0)
+		if err != nil || r.ReservationID == 0 {
+			t.Fatalf("reservation: %+v, %v", r, err)
+		}
+		if err := repo.SetSelectionToken(ctx, r.ReservationID, token); err != nil {
+			t.Fatal(err)
+		}
+		return r.ReservationID
+	}
+
+	// A claimed selection can no longer be released by its token
+	reserve()
+	if ok, err := repo.ClaimSelection(ctx, token); err != nil || !ok {
+		t.Fatalf("claim: %v, %v", ok, err)
+	}
+	if ok, err := repo.ReleaseSelection(ctx, token, userID); err != nil || ok {
+		t.Fatalf("release after claim: %v, %v", ok, err)
+	}
+
+	// A lost selection is released once, and only for its user
+	reserve()
+	if ok, err := repo.ReleaseSelection(ctx, token, userID+1); err != nil || ok {
+		t.Fatalf("release by another user: %v, %v", ok, err)
+	}
+	if ok, err := repo.ReleaseSelection(ctx, token, userID); err != nil || !ok {
+		t.Fatalf("release: %v, %v", ok, err)
+	}
+	if ok, err := repo.ClaimSelection(ctx, token); err != nil || ok {
+		t.Fatalf("claim after release: %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,84 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/models"
+)
+
+// exportTimeout bounds one streamed export; rows are handed out while the query runs
+const exportTimeout = 10 * time.Minute
+
+type ExportRepository struct {
+	DB *sql.DB
+}
+
+func NewExportRepository(db *sql.DB) *ExportRepository {
+	return &ExportRepository{DB: db}
+}
+
+// Users calls fn for every user who signed up in the inclusive date range, oldest first
+func (r *ExportRepository) Users(ctx context.Context, from, to time.Time, fn func(*models.User) error) error {
+	ctx, cancel := context.WithTimeout(ctx, exportTimeout)
+	defer cancel()
+
+	query := `
+		SELECT id, first_name, last_name, username, COALESCE(phone_number, ''), COALESCE(language_code, ''),
+			is_telegram_premium, premium_expires_at, role, created_at, updated_at, last_active_at
+		FROM users
+		WHERE created_at >= $1::date AND created_at < $2::date + 1
+		ORDER BY created_at, id
+	`
+	rows, err := r.DB.QueryContext(ctx, query, from.Format(time.DateOnly), to.Format(time.DateOnly))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u models.User
+		err := rows.Scan(&u.ID, &u.FirstName, &u.LastName, &u.Username, &u.PhoneNumber, &u.LanguageCode,
+			&u.IsTelegramPremium, &u.PremiumExpiresAt, &u.Role, &u.CreatedAt, &u.UpdatedAt, &u.LastActiveAt)
+		if err != nil {
+			return err
+		}
+		if err := fn(&u); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Downloads calls fn for every download of the inclusive date range, oldest first,
+// including entries users hid from their history
+func (r *ExportRepository) Downloads(ctx context.Context, from, to time.Time, fn func(*models.Download) error) error {
+	ctx, cancel := context.WithTimeout(ctx, exportTimeout)
+	defer cancel()
+
+	query := `
+		SELECT d.id, d.user_id, d.input, d.status, d.created_at, COUNT(ds.archived_story_id)
+		FROM downloads d
+		LEFT JOIN download_stories ds ON ds.download_id = d.id
+		WHERE d.created_at >= $1::date AND d.created_at < $2::date + 1
+		GROUP BY d.id
+		ORDER BY d.created_at, d.id
+	`
+	rows, err := r.DB.QueryContext(ctx, query, from.Format(time.DateOnly), to.Format(time.DateOnly))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d models.Download
+		if err := rows.Scan(&d.ID, &d.UserID, &d.Input, &d.Status, &d.CreatedAt, &d.StoryCount); err != nil {
+			return err
+		}
+		if err := fn(&d); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,62 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/models"
+)
+
+type RuntimeSettingRepository struct {
+	DB *sql.DB
+}
+
+func NewRuntimeSettingRepository(db *sql.DB) *RuntimeSettingRepository {
+	return &RuntimeSettingRepository{DB: db}
+}
+
+func (r *RuntimeSettingRepository) List(ctx context.Context) ([]models.RuntimeSetting, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, `SELECT key, value, updated_by, updated_at FROM runtime_settings ORDER BY key`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var settings []models.RuntimeSetting
+	for rows.Next() {
+		var s models.RuntimeSetting
+		if err := rows.Scan(&s.Key, &s.Value, &s.UpdatedBy, &s.UpdatedAt); err != nil {
+			return nil, err
+		}
+		settings = append(settings, s)
+	}
+	return settings, rows.Err()
+}
+
+func (r *RuntimeSettingRepository) Set(ctx context.Context, key, value string, updatedBy int64) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `
+		INSERT INTO runtime_settings (key, value, updated_by, updated_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (key) DO UPDATE SET
+			value = EXCLUDED.value,
+			updated_by = EXCLUDED.updated_by,
+			updated_at = NOW()
+	`
+	_, err := r.DB.ExecContext(ctx, query, key, value, updatedBy)
+	return err
+}
+
+func (r *RuntimeSettingRepository) Delete(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := r.DB.ExecContext(ctx, `DELETE FROM runtime_settings WHERE key = $1`, key)
+	return err
+}
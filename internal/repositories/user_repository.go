@@ -3,6 +3,9 @@ package repositories
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/bbr/telestory-api-based/internal/models"
@@ -16,8 +19,8 @@ func NewUserRepository(db *sql.DB) *UserRepository {
 	return &UserRepository{DB: db}
 }
 
-func (r *UserRepository) Insert(user *models.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *UserRepository) Insert(ctx context.Context, user *models.User) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	query := `
@@ -37,8 +40,8 @@ func (r *UserRepository) Insert(user *models.User) error {
 	return err
 }
 
-func (r *UserRepository) Upsert(user *models.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *UserRepository) Upsert(ctx context.Context, user *models.User) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	query := `
@@ -65,14 +68,11 @@ func (r *UserRepository) Upsert(user *models.User) error {
 	return err
 }
 
-func (r *UserRepository) GetByID(id int64) (*models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+const userColumns = `id, first_name, last_name, username, COALESCE(phone_number, ''), COALESCE(language_code, ''), is_telegram_premium, premium_expires_at, role, created_at, updated_at, last_active_at`
 
+func scanUser(row interface{ Scan(...any) error }) (*models.User, error) {
 	user := &models.User{}
-	query := `SELECT id, first_name, last_name, username, COALESCE(phone_number, ''), COALESCE(language_code, ''), is_telegram_premium, premium_expires_at, role, created_at, updated_at, last_active_at FROM users WHERE id = $1`
-
-	err := r.DB.QueryRowContext(ctx, query, id).Scan(
+	err := row.Scan(
 		&user.ID,
 		&user.FirstName,
 		&user.LastName,
@@ -86,15 +86,152 @@ func (r *UserRepository) GetByID(id int64) (*models.User, error) {
 		&user.UpdatedAt,
 		&user.LastActiveAt,
 	)
-
 	if err != nil {
 		return nil, err
 	}
 	return user, nil
 }
 
-func (r *UserRepository) UpdateActivity(id int64) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *UserRepository) GetByID(ctx context.Context, id int64) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	return scanUser(r.DB.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1`, id))
+}
+
+// UserFilter narrows Search; zero values match every user
+type UserFilter struct {
+	Query    string // exact ID, or part of the username or name
+	Role     string
+	Language string
+	Premium  *bool // bot premium active right now
+}
+
+func (f UserFilter) where() (string, []any) {
+	var conds []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if f.Query != "" {
+		pattern := arg("%" + likeEscaper.Replace(strings.TrimPrefix(f.Query, "@")) + "%")
+		cond := fmt.Sprintf("(username ILIKE %[1]s OR first_name ILIKE %[1]s OR last_name ILIKE %[1]s", pattern)
+		if id, err := strconv.ParseInt(f.Query, 10, 64); err == nil {
+			cond += " OR id = " + arg(id)
+		}
+		conds = append(conds, cond+")")
+	}
+	if f.Role != "" {
+		conds = append(conds, "role = "+arg(f.Role))
+	}
+	if f.Language != "" {
+		conds = append(conds, "language_code = "+arg(f.Language))
+	}
+	if f.Premium != nil {
+		cond := "COALESCE(premium_expires_at > NOW(), FALSE)"
+		if !*f.Premium {
+			cond = "NOT " + cond
+		}
+		conds = append(conds, cond)
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// likeEscaper escapes the wildcards of ILIKE patterns
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Search returns a page of matching users, newest first
+func (r *UserRepository) Search(ctx context.Context, filter UserFilter, limit, offset int) ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	where, args := filter.where()
+	query := fmt.Sprintf(`SELECT %s FROM users%s ORDER BY created_at DESC, id DESC LIMIT $%d OFFSET $%d`,
+		userColumns, where, len(args)+1, len(args)+2)
+	rows, err := r.DB.QueryContext(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	return users, rows.Err()
+}
+
+func (r *UserRepository) CountSearch(ctx context.Context, filter UserFilter) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	where, args := filter.where()
+	var count int
+	err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM users"+where, args...).Scan(&count)
+	return count, err
+}
+
+// UpdateRole returns false if the user does not exist
+func (r *UserRepository) UpdateRole(ctx context.Context, id int64, role string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, `UPDATE users SET role = $1, updated_at = NOW() WHERE id = $2`, role, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// UpdatePremium sets when bot premium expires, NULL removes it; false if the user does not exist
+func (r *UserRepository) UpdatePremium(ctx context.Context, id int64, expiresAt sql.NullTime) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, `UPDATE users SET premium_expires_at = $1, updated_at = NOW() WHERE id = $2`, expiresAt, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ListIDs returns the IDs of all users, or of the users the bot speaks one language to;
+// users who never chose a language get English
+func (r *UserRepository) ListIDs(ctx context.Context, languageCode string) ([]int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, `SELECT id FROM users WHERE $1 = '' OR COALESCE(language_code, 'en') = $1 ORDER BY id`, languageCode)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (r *UserRepository) UpdateActivity(ctx context.Context, id int64) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	query := `UPDATE users SET last_active_at = NOW() WHERE id = $1`
@@ -102,8 +239,8 @@ func (r *UserRepository) UpdateActivity(id int64) error {
 	return err
 }
 
-func (r *UserRepository) UpdateLanguage(id int64, langCode string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *UserRepository) UpdateLanguage(ctx context.Context, id int64, langCode string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	query := `UPDATE users SET language_code = $1 WHERE id = $2`
@@ -111,21 +248,61 @@ func (r *UserRepository) UpdateLanguage(id int64, langCode string) error {
 	return err
 }
 
-func (r *UserRepository) CountAllUsers() (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *UserRepository) CountActiveUsers(ctx context.Context, days int) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
+	query := `SELECT COUNT(*) FROM users WHERE last_active_at >= NOW() - INTERVAL '1 day' * $1`
 	var count int
-	err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&count)
+	err := r.DB.QueryRowContext(ctx, query, days).Scan(&count)
 	return count, err
 }
 
-func (r *UserRepository) CountActiveUsers(days int) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *UserRepository) GetSettings(ctx context.Context, userID int64) (*models.UserSettings, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	query := `SELECT COUNT(*) FROM users WHERE last_active_at >= NOW() - INTERVAL '1 day' * $1`
-	var count int
-	err := r.DB.QueryRowContext(ctx, query, days).Scan(&count)
-	return count, err
+	settings := &models.UserSettings{}
+	query := `SELECT user_id, delivery_style, caption_format, include_archive, timezone, notifications, updated_at FROM user_settings WHERE user_id = $1`
+
+	err := r.DB.QueryRowContext(ctx, query, userID).Scan(
+		&settings.UserID,
+		&settings.DeliveryStyle,
+		&settings.CaptionFormat,
+		&settings.IncludeArchive,
+		&settings.Timezone,
+		&settings.Notifications,
+		&settings.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+func (r *UserRepository) UpsertSettings(ctx context.Context, settings *models.UserSettings) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `
+		INSERT INTO user_settings (user_id, delivery_style, caption_format, include_archive, timezone, notifications, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		ON CONFLICT (user_id) DO UPDATE SET
+			delivery_style = EXCLUDED.delivery_style,
+			caption_format = EXCLUDED.caption_format,
+			include_archive = EXCLUDED.include_archive,
+			timezone = EXCLUDED.timezone,
+			notifications = EXCLUDED.notifications,
+			updated_at = NOW()
+	`
+
+	_, err := r.DB.ExecContext(ctx, query,
+		settings.UserID,
+		settings.DeliveryStyle,
+		settings.CaptionFormat,
+		settings.IncludeArchive,
+		settings.Timezone,
+		settings.Notifications,
+	)
+	return err
 }
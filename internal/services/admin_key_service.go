@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/bbr/telestory-api-based/internal/models"
+	"github.com/bbr/telestory-api-based/internal/repositories"
+)
+
+// AdminKeyPrefix marks admin API keys so leaked keys are easy to spot
+const AdminKeyPrefix = "tsk_"
+
+// adminKeyShownChars is how much of a key is stored in clear to tell keys apart
+const adminKeyShownChars = len(AdminKeyPrefix) + 6
+
+// AdminKeyService issues and checks the bearer keys of the admin REST API.
+// Keys are random, so a plain SHA-256 is enough to store them safely.
+type AdminKeyService struct {
+	Repo *repositories.AdminKeyRepository
+}
+
+func NewAdminKeyService(repo *repositories.AdminKeyRepository) *AdminKeyService {
+	return &AdminKeyService{Repo: repo}
+}
+
+func hashAdminKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create issues a key for an admin; the returned key is not stored and cannot be shown again
+func (s *AdminKeyService) Create(ctx context.Context, name string, createdBy int64) (string, *models.AdminAPIKey, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 64 {
+		return "", nil, fmt.Errorf("the key name must be 1 to 64 characters")
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, fmt.Errorf("failed to generate key: %v", err)
+	}
+	key := AdminKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	record, err := s.Repo.Create(ctx, name, key[:adminKeyShownChars], hashAdminKey(key), createdBy)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to save key: %v", err)
+	}
+	return key, record, nil
+}
+
+// Authenticate returns the live key matching key, or nil when it is unknown, revoked
+// or its creator is no longer an admin
+func (s *AdminKeyService) Authenticate(ctx context.Context, key string) (*models.AdminAPIKey, error) {
+	if !strings.HasPrefix(key, AdminKeyPrefix) {
+		return nil, nil
+	}
+	record, err := s.Repo.Use(ctx, hashAdminKey(key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to check key: %v", err)
+	}
+	return record, nil
+}
+
+func (s *AdminKeyService) List(ctx context.Context) ([]models.AdminAPIKey, error) {
+	return s.Repo.List(ctx)
+}
+
+// Revoke disables a key at once; false if there is no live key with that ID
+func (s *AdminKeyService) Revoke(ctx context.Context, id int) (bool, error) {
+	return s.Repo.Revoke(ctx, id)
+}
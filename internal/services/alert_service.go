@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/metrics"
+)
+
+// alertSignal is a failure rate derived from cumulative metric counters
+type alertSignal struct {
+	name   string
+	title  string
+	sample func() (failures, total float64)
+}
+
+type alertSample struct {
+	at              time.Time
+	failures, total float64
+}
+
+// AlertService watches failure rates over a sliding window and posts one alert per incident
+// to the admin log channel, followed by a recovery notice. Thresholds are runtime settings.
+type AlertService struct {
+	Log        *LogService
+	Runtime    *RuntimeSettings
+	InstanceID string // set in cluster mode, where each instance alerts on its own traffic
+	Interval   time.Duration
+
+	signals []alertSignal
+	samples map[string][]alertSample
+	firing  map[string]time.Time // signal name -> when the alert was posted
+}
+
+func NewAlertService(logService *LogService, runtime *RuntimeSettings) *AlertService {
+	return &AlertService{
+		Log:      logService,
+		Runtime:  runtime,
+		Interval: 30 * time.Second,
+		signals: []alertSignal{
+			{name: "fetch", title: "TeleStory API failures", sample: func() (float64, float64) {
+				failed := metrics.APIRequests.Sum(labelIn("timeout", "network_error", "http_5xx", "decode_error", "circuit_open"))
+				return failed, metrics.APIRequests.Sum(nil)
+			}},
+			{name: "media_download", title: "Story media download failures", sample: func() (float64, float64) {
+				return metrics.MediaDownloads.Sum(labelNotIn("ok")), metrics.MediaDownloads.Sum(nil)
+			}},
+			{name: "archive_upload", title: "Archive channel upload failures", sample: func() (float64, float64) {
+				return float64(metrics.ArchiveUploadDuration.Count(labelIn("error"))), float64(metrics.ArchiveUploadDuration.Count(nil))
+			}},
+			{name: "database", title: "Database errors", sample: func() (float64, float64) {
+				return metrics.DBQueries.Sum(labelIn("error")), metrics.DBQueries.Sum(nil)
+			}},
+		},
+		samples: make(map[string][]alertSample),
+		firing:  make(map[string]time.Time),
+	}
+}
+
+func labelIn(values ...string) func([]string) bool {
+	return func(labels []string) bool { return slices.Contains(values, labels[0]) }
+}
+
+func labelNotIn(values ...string) func([]string) bool {
+	return func(labels []string) bool { return !slices.Contains(values, labels[0]) }
+}
+
+// Run evaluates the signals every Interval until ctx is cancelled
+func (s *AlertService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	s.evaluate(time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.evaluate(now)
+		}
+	}
+}
+
+// evaluate alerts on signals whose failure rate crossed the threshold and announces recoveries.
+// A signal recovers once the window has no failures, or enough events at a rate below the threshold.
+func (s *AlertService) evaluate(now time.Time) {
+	window := s.Runtime.AlertWindow()
+	threshold := s.Runtime.AlertFailureRate()
+	minEvents := float64(s.Runtime.AlertMinEvents())
+
+	for _, sig := range s.signals {
+		failures, total := sig.sample()
+		samples := append(s.samples[sig.name], alertSample{at: now, failures: failures, total: total})
+
+		// Keep the newest sample from before the window as the baseline
+		cut := 0
+		for cut+1 < len(samples) && !samples[cut+1].at.After(now.Add(-window)) {
+			cut++
+		}
+		samples = samples[cut:]
+		s.samples[sig.name] = samples
+
+		failed, events := failures-samples[0].failures, total-samples[0].total
+		rate := 0.0
+		if events > 0 {
+			rate = failed / events
+		}
+
+		since, firing := s.firing[sig.name]
+		switch {
+		case !firing && events >= minEvents && rate >= threshold:
+			s.firing[sig.name] = now
+			slog.Warn("Alert firing", "signal", sig.name, "failed", failed, "events", events)
+			s.Log.SendLog(LogAlert, fmt.Sprintf(
+				"🚨 <b>Alert: %s</b>\n\n<b>Failure rate:</b> %.0f%% (%.0f/%.0f) over the last %s\n<b>Threshold:</b> %.0f%% with at least %.0f events%s",
+				html.EscapeString(sig.title), rate*100, failed, events, window, threshold*100, minEvents, s.instanceLine()))
+		case firing && (failed == 0 || (events >= minEvents && rate < threshold)):
+			delete(s.firing, sig.name)
+			slog.Info("Alert recovered", "signal", sig.name, "failed", failed, "events", events)
+			s.Log.SendLog(LogAlert, fmt.Sprintf(
+				"✅ <b>Recovered: %s</b>\n\n<b>Failure rate:</b> %.0f%% (%.0f/%.0f) over the last %s\n<b>Alerting for:</b> %s%s",
+				html.EscapeString(sig.title), rate*100, failed, events, window, now.Sub(since).Round(time.Second), s.instanceLine()))
+		}
+	}
+}
+
+func (s *AlertService) instanceLine() string {
+	if s.InstanceID == "" {
+		return ""
+	}
+	return fmt.Sprintf("\n<b>Instance:</b> <code>%s</code>", html.EscapeString(s.InstanceID))
+}
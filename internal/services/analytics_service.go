@@ -1,78 +1,224 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"image/color"
+	"math"
+	"strings"
+	"time"
 
-	"github.com/bbr/telestory-api-based/internal/i18n"
+	"github.com/bbr/telestory-api-based/internal/charts"
+	"github.com/bbr/telestory-api-based/internal/models"
 	"github.com/bbr/telestory-api-based/internal/repositories"
 )
 
-type AnalyticsService struct {
-	UserRepo     *repositories.UserRepository
-	DownloadRepo *repositories.DownloadRepository
+// Named /stats views; anything else is a custom date range
+const (
+	StatsToday = "today"
+	Stats7d    = "7d"
+	Stats30d   = "30d"
+)
+
+// StatsViews lists the named views in menu order
+var StatsViews = []string{StatsToday, Stats7d, Stats30d}
+
+// Reports next to the daily charts
+const (
+	StatsTargets   = "targets"
+	StatsLanguages = "languages"
+	StatsFunnel    = "funnel"
+	StatsRetention = "retention"
+)
+
+// StatsReports lists the reports in menu order
+var StatsReports = []string{StatsTargets, StatsFunnel, StatsLanguages, StatsRetention}
+
+const (
+	// MaxStatsDays bounds custom ranges so one view stays a cheap query
+	MaxStatsDays = 366
+
+	DefaultTopTargets     = 10
+	MaxTopTargets         = 100
+	DefaultRetentionWeeks = 8
+	MaxRetentionWeeks     = 52
+)
+
+var ErrInvalidStatsRange = errors.New("invalid stats range")
+
+// StatsView is an inclusive range of calendar days
+type StatsView struct {
+	Name     string // named view, empty for a custom range
+	From, To time.Time
 }
 
-func NewAnalyticsService(userRepo *repositories.UserRepository, downloadRepo *repositories.DownloadRepository) *AnalyticsService {
-	return &AnalyticsService{
-		UserRepo:     userRepo,
-		DownloadRepo: downloadRepo,
+// ParseStatsView reads "/stats" arguments: nothing for the last 7 days, a view name,
+// one day or two days as YYYY-MM-DD. Days are UTC, like the database sessions.
+func ParseStatsView(args []string, now time.Time) (StatsView, error) {
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	named := func(name string, days int) StatsView {
+		return StatsView{Name: name, From: today.AddDate(0, 0, 1-days), To: today}
 	}
-}
 
-// GetOverallStats compiles all interesting analytics into a Markdown-formatted string based on language
-func (s *AnalyticsService) GetOverallStats(langCode string) (string, error) {
-	totalUsers, err := s.UserRepo.CountAllUsers()
-	if err != nil {
-		return "", fmt.Errorf("failed to count users: %v", err)
+	if len(args) == 0 {
+		return named(Stats7d, 7), nil
+	}
+	switch args[0] {
+	case StatsToday:
+		return named(StatsToday, 1), nil
+	case Stats7d:
+		return named(Stats7d, 7), nil
+	case Stats30d:
+		return named(Stats30d, 30), nil
 	}
 
-	activeUsers7, err := s.UserRepo.CountActiveUsers(7)
+	if len(args) > 2 {
+		return StatsView{}, ErrInvalidStatsRange
+	}
+	from, err := time.Parse(time.DateOnly, args[0])
 	if err != nil {
-		return "", fmt.Errorf("failed to count active 7d users: %v", err)
+		return StatsView{}, ErrInvalidStatsRange
 	}
+	to := from
+	if len(args) == 2 {
+		if to, err = time.Parse(time.DateOnly, args[1]); err != nil {
+			return StatsView{}, ErrInvalidStatsRange
+		}
+	}
+	if to.Before(from) || to.Sub(from) >= MaxStatsDays*24*time.Hour {
+		return StatsView{}, ErrInvalidStatsRange
+	}
+	return StatsView{From: from, To: to}, nil
+}
 
-	activeUsers30, err := s.UserRepo.CountActiveUsers(30)
-	if err != nil {
-		return "", fmt.Errorf("failed to count active 30d users: %v", err)
+// Key identifies the view in one token, e.g. for callback payloads; ParseStatsKey reverses it
+func (v StatsView) Key() string {
+	if v.Name != "" {
+		return v.Name
 	}
+	return v.From.Format(time.DateOnly) + "_" + v.To.Format(time.DateOnly)
+}
+
+func ParseStatsKey(key string, now time.Time) (StatsView, error) {
+	return ParseStatsView(strings.Split(key, "_"), now)
+}
+
+type AnalyticsService struct {
+	Repo *repositories.AnalyticsRepository
+}
+
+func NewAnalyticsService(repo *repositories.AnalyticsRepository) *AnalyticsService {
+	return &AnalyticsService{Repo: repo}
+}
 
-	totalDownloads, err := s.DownloadRepo.CountTotalDownloads()
+// Stats loads the daily series of a view
+func (s *AnalyticsService) Stats(ctx context.Context, view StatsView) (*models.StatsRange, error) {
+	stats, err := s.Repo.DailyStats(ctx, view.From, view.To)
 	if err != nil {
-		return "", fmt.Errorf("failed to count total downloads: %v", err)
+		return nil, fmt.Errorf("failed to load daily stats: %v", err)
 	}
+	return stats, nil
+}
 
-	successDownloads, err := s.DownloadRepo.CountDownloadsByStatus("success")
+// TopTargets returns the most requested targets of a view
+func (s *AnalyticsService) TopTargets(ctx context.Context, view StatsView, limit int) ([]models.TargetStat, error) {
+	targets, err := s.Repo.TopTargets(ctx, view.From, view.To, limit)
 	if err != nil {
-		return "", fmt.Errorf("failed to count success downloads: %v", err)
+		return nil, fmt.Errorf("failed to load top targets: %v", err)
 	}
+	return targets, nil
+}
 
-	failedDownloads, err := s.DownloadRepo.CountDownloadsByStatus("failed")
+// Languages returns all users by language
+func (s *AnalyticsService) Languages(ctx context.Context) ([]models.LanguageStat, error) {
+	languages, err := s.Repo.Languages(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to count failed downloads: %v", err)
+		return nil, fmt.Errorf("failed to load languages: %v", err)
 	}
+	return languages, nil
+}
 
-	totalDownloadsToday, err := s.DownloadRepo.CountDownloadsToday()
+// Funnel returns the conversion of the users who signed up in a view
+func (s *AnalyticsService) Funnel(ctx context.Context, view StatsView) (*models.Funnel, error) {
+	funnel, err := s.Repo.Funnel(ctx, view.From, view.To)
 	if err != nil {
-		return "", fmt.Errorf("failed to count total downloads today: %v", err)
+		return nil, fmt.Errorf("failed to load funnel: %v", err)
 	}
+	return funnel, nil
+}
 
-	successDownloadsToday, err := s.DownloadRepo.CountDownloadsTodayByStatus("success")
+// Retention returns weekly signup cohorts, oldest first
+func (s *AnalyticsService) Retention(ctx context.Context, weeks int) ([]models.RetentionCohort, error) {
+	cohorts, err := s.Repo.Retention(ctx, weeks)
 	if err != nil {
-		return "", fmt.Errorf("failed to count success downloads today: %v", err)
+		return nil, fmt.Errorf("failed to load retention: %v", err)
 	}
+	return cohorts, nil
+}
 
-	failedDownloadsToday, err := s.DownloadRepo.CountDownloadsTodayByStatus("failed")
-	if err != nil {
-		return "", fmt.Errorf("failed to count failed downloads today: %v", err)
+// StatsChart is one rendered series; Series names the i18n caption "stats_chart_<series>"
+type StatsChart struct {
+	Series string
+	PNG    []byte
+}
+
+// Charts renders a PNG bar chart per series
+func (s *AnalyticsService) Charts(stats *models.StatsRange) ([]StatsChart, error) {
+	labels := make([]string, len(stats.Days))
+	for i, d := range stats.Days {
+		labels[i] = d.Day.Format("01-02")
+	}
+	series := func(value func(models.DailyStat) float64) []float64 {
+		values := make([]float64, len(stats.Days))
+		for i, d := range stats.Days {
+			values[i] = value(d)
+		}
+		return values
 	}
 
-	template := i18n.GetMessage(langCode, "stats_report")
-	report := fmt.Sprintf(
-		template,
-		totalUsers, activeUsers7, activeUsers30,
-		totalDownloadsToday, successDownloadsToday, failedDownloadsToday,
-		totalDownloads, successDownloads, failedDownloads,
-	)
+	specs := []struct {
+		series string
+		chart  charts.BarChart
+	}{
+		{"new_users", charts.BarChart{
+			Values: series(func(d models.DailyStat) float64 { return float64(d.NewUsers) }),
+			Color:  color.RGBA{0x42, 0x85, 0xf4, 0xff},
+		}},
+		{"active_users", charts.BarChart{
+			Values: series(func(d models.DailyStat) float64 { return float64(d.ActiveUsers) }),
+			Color:  color.RGBA{0xfb, 0x8c, 0x00, 0xff},
+		}},
+		{"requests", charts.BarChart{
+			Values: series(func(d models.DailyStat) float64 { return float64(d.Requests) }),
+			Color:  color.RGBA{0x7e, 0x57, 0xc2, 0xff},
+		}},
+		{"success_rate", charts.BarChart{
+			Values: series(func(d models.DailyStat) float64 {
+				if rate, ok := d.SuccessRate(); ok {
+					return rate
+				}
+				return math.NaN()
+			}),
+			Color:  color.RGBA{0x43, 0xa0, 0x47, 0xff},
+			Max:    100,
+			Suffix: "%",
+		}},
+		{"stories", charts.BarChart{
+			Values: series(func(d models.DailyStat) float64 { return float64(d.StoriesDelivered) }),
+			Color:  color.RGBA{0xe5, 0x39, 0x35, 0xff},
+		}},
+	}
 
-	return report, nil
+	result := make([]StatsChart, 0, len(specs))
+	for _, spec := range specs {
+		spec.chart.Labels = labels
+		png, err := spec.chart.PNG()
+		if err != nil {
+			return nil, fmt.Errorf("failed to render %s chart: %v", spec.series, err)
+		}
+		result = append(result, StatsChart{Series: spec.series, PNG: png})
+	}
+	return result, nil
 }
@@ -0,0 +1,19 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseStatsViewUsesUTCDays(t *testing.T) {
+	// 02:00 in Tashkent is still the previous day in UTC
+	now := time.Date(2026, 3, 10, 2, 0, 0, 0, time.FixedZone("UTC+5", 5*60*60))
+	view, err := ParseStatsView([]string{StatsToday}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)
+	if !view.From.Equal(want) || !view.To.Equal(want) {
+		t.Fatalf("today is %s to %s, want %s", view.From, view.To, want)
+	}
+}
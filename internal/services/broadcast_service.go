@@ -0,0 +1,253 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bbr/telestory-api-based/internal/i18n"
+	"github.com/bbr/telestory-api-based/internal/models"
+	"github.com/bbr/telestory-api-based/internal/repositories"
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	broadcastProgressEvery = 100 // sends between progress updates in the database
+	broadcastHeartbeat     = 30 * time.Second
+	broadcastStaleAfter    = 2 * time.Minute // several missed heartbeats
+)
+
+// ErrBroadcastsStopped is returned by Start and Run after Close
+var ErrBroadcastsStopped = errors.New("broadcasts are stopped")
+
+// BroadcastParseModes are the accepted message formats; empty is plain text
+var BroadcastParseModes = []string{"", tele.ModeMarkdownV2, tele.ModeHTML}
+
+// BroadcastService sends a message to all users, or to the users of one language. It backs both
+// the admin API and cmd/broadcast. Sends are spaced by Interval to stay under the bot's global flood limit.
+type BroadcastService struct {
+	Repo     *repositories.BroadcastRepository
+	UserRepo *repositories.UserRepository
+	Bot      *tele.Bot
+	Interval time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	mu     sync.Mutex // orders wg.Add in create against Close
+	closed bool
+}
+
+func NewBroadcastService(repo *repositories.BroadcastRepository, userRepo *repositories.UserRepository, bot *tele.Bot) *BroadcastService {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &BroadcastService{
+		Repo:     repo,
+		UserRepo: userRepo,
+		Bot:      bot,
+		Interval: 40 * time.Millisecond, // 25 messages per second
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// ValidateBroadcast checks a message, the optional language filter and the parse mode
+func ValidateBroadcast(message, languageCode, parseMode string) error {
+	if strings.TrimSpace(message) == "" {
+		return fmt.Errorf("message must not be empty")
+	}
+	if utf8.RuneCountInString(message) > 4096 {
+		return fmt.Errorf("message must be at most 4096 characters")
+	}
+	if languageCode != "" && !slices.Contains(i18n.SupportedLanguages, languageCode) {
+		return fmt.Errorf("unknown language %q, expected one of %v", languageCode, i18n.SupportedLanguages)
+	}
+	if !slices.Contains(BroadcastParseModes, parseMode) {
+		return fmt.Errorf("unknown parse mode %q, expected %s or %s", parseMode, tele.ModeMarkdownV2, tele.ModeHTML)
+	}
+	return nil
+}
+
+// Start records a broadcast and sends it in the background; progress is read with Get
+func (s *BroadcastService) Start(ctx context.Context, message, languageCode, parseMode, createdBy string) (*models.Broadcast, error) {
+	b, err := s.create(ctx, message, languageCode, parseMode, createdBy)
+	if err != nil {
+		return nil, err
+	}
+
+	progress := *b
+	go func() {
+		defer s.wg.Done()
+		s.run(&progress)
+	}()
+	return b, nil
+}
+
+// Run records a broadcast and sends it, returning when it is done or interrupted by Close
+func (s *BroadcastService) Run(ctx context.Context, message, languageCode, parseMode, createdBy string) (*models.Broadcast, error) {
+	b, err := s.create(ctx, message, languageCode, parseMode, createdBy)
+	if err != nil {
+		return nil, err
+	}
+	defer s.wg.Done()
+
+	s.run(b)
+	return b, nil
+}
+
+// create validates and records a broadcast; on success the caller must call s.wg.Done once it is sent
+func (s *BroadcastService) create(ctx context.Context, message, languageCode, parseMode, createdBy string) (*models.Broadcast, error) {
+	if err := ValidateBroadcast(message, languageCode, parseMode); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, ErrBroadcastsStopped
+	}
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	b, err := s.Repo.Create(ctx, message, languageCode, parseMode, createdBy)
+	if err != nil {
+		s.wg.Done()
+		return nil, fmt.Errorf("failed to create broadcast: %v", err)
+	}
+	return b, nil
+}
+
+func (s *BroadcastService) Get(ctx context.Context, id int) (*models.Broadcast, error) {
+	return s.Repo.Get(ctx, id)
+}
+
+// List returns a page of broadcasts and the total count
+func (s *BroadcastService) List(ctx context.Context, limit, offset int) ([]models.Broadcast, int, error) {
+	broadcasts, err := s.Repo.List(ctx, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	total, err := s.Repo.Count(ctx)
+	return broadcasts, total, err
+}
+
+// Close stops running broadcasts, marks them interrupted and waits for them
+func (s *BroadcastService) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.cancel()
+	s.wg.Wait()
+}
+
+func (s *BroadcastService) run(b *models.Broadcast) {
+	// Progress is saved with a fresh context so an interrupted broadcast is still recorded
+	save := func() {
+		if err := s.Repo.Update(context.Background(), b); err != nil {
+			slog.Error("Failed to save broadcast progress", "broadcast_id", b.ID, "error", err)
+		}
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(broadcastHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := s.Repo.Heartbeat(context.Background(), b.ID); err != nil {
+					slog.Warn("Broadcast heartbeat failed", "broadcast_id", b.ID, "error", err)
+				}
+			}
+		}
+	}()
+
+	ids, err := s.UserRepo.ListIDs(s.ctx, b.LanguageCode)
+	if err != nil {
+		slog.Error("Failed to list broadcast recipients", "broadcast_id", b.ID, "error", err)
+		b.Status = models.BroadcastInterrupted
+		save()
+		return
+	}
+	b.Status = models.BroadcastRunning
+	b.Total = len(ids)
+	save()
+	slog.Info("Broadcast started", "broadcast_id", b.ID, "recipients", b.Total, "language", b.LanguageCode)
+
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for i, id := range ids {
+		select {
+		case <-s.ctx.Done():
+			b.Status = models.BroadcastInterrupted
+			save()
+			slog.Warn("Broadcast interrupted", "broadcast_id", b.ID, "sent", b.Sent, "failed", b.Failed)
+			return
+		case <-ticker.C:
+		}
+
+		if s.send(id, b.Message, tele.ParseMode(b.ParseMode)) {
+			b.Sent++
+		} else {
+			b.Failed++
+		}
+		if (i+1)%broadcastProgressEvery == 0 {
+			save()
+		}
+	}
+
+	b.Status = models.BroadcastDone
+	save()
+	slog.Info("Broadcast finished", "broadcast_id", b.ID, "sent", b.Sent, "failed", b.Failed)
+}
+
+// SweepStale marks broadcasts left pending or running by an instance that crashed as interrupted,
+// once at start and then periodically. In cluster mode it runs on the leader only.
+func (s *BroadcastService) SweepStale(ctx context.Context) {
+	ticker := time.NewTicker(broadcastStaleAfter / 2)
+	defer ticker.Stop()
+	for {
+		if n, err := s.Repo.InterruptStale(ctx, broadcastStaleAfter); err != nil {
+			slog.ErrorContext(ctx, "Error interrupting stale broadcasts", "error", err)
+		} else if n > 0 {
+			slog.WarnContext(ctx, "Marked stale broadcasts as interrupted", "count", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// send delivers one message, waiting out a flood limit once; users who blocked the bot count as failed
+func (s *BroadcastService) send(userID int64, message string, parseMode tele.ParseMode) bool {
+	for attempt := 1; attempt <= 2; attempt++ {
+		_, err := s.Bot.Send(&tele.User{ID: userID}, message, &tele.SendOptions{ParseMode: parseMode, DisableWebPagePreview: true})
+		if err == nil {
+			return true
+		}
+
+		var flood tele.FloodError
+		if errors.As(err, &flood) && attempt == 1 {
+			slog.Warn("Broadcast flood wait", "retry_after", flood.RetryAfter)
+			select {
+			case <-time.After(time.Duration(flood.RetryAfter) * time.Second):
+			case <-s.ctx.Done():
+				return false
+			}
+			continue
+		}
+		slog.Debug("Broadcast message not delivered", "user_id", userID, "error", err)
+		return false
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bbr/telestory-api-based/internal/models"
+	"github.com/bbr/telestory-api-based/internal/repositories"
+	tele "gopkg.in/telebot.v3"
+)
+
+type ChatService struct {
+	ChatRepo *repositories.ChatRepository
+}
+
+func NewChatService(chatRepo *repositories.ChatRepository) *ChatService {
+	return &ChatService{ChatRepo: chatRepo}
+}
+
+// RegisterChat stores a group chat and returns its settings
+func (s *ChatService) RegisterChat(ctx context.Context, teleChat *tele.Chat) (*models.Chat, error) {
+	chat := &models.Chat{
+		ID:    teleChat.ID,
+		Title: teleChat.Title,
+		Type:  string(teleChat.Type),
+	}
+	if err := s.ChatRepo.Upsert(ctx, chat); err != nil {
+		return nil, err
+	}
+	return chat, nil
+}
+
+func (s *ChatService) UpdateDelivery(ctx context.Context, chatID int64, delivery string) error {
+	if delivery != models.DeliveryGroup && delivery != models.DeliveryPrivate {
+		return fmt.Errorf("unknown delivery mode %q", delivery)
+	}
+	return s.ChatRepo.UpdateDelivery(ctx, chatID, delivery)
+}
+
+// IsChatAdmin reports whether user is the creator or an administrator of chat
+func (s *ChatService) IsChatAdmin(bot *tele.Bot, chat *tele.Chat, user *tele.User) (bool, error) {
+	member, err := bot.ChatMemberOf(chat, user)
+	if err != nil {
+		return false, err
+	}
+	return member.Role == tele.Creator || member.Role == tele.Administrator, nil
+}
+
+// IsGroup reports whether chat is a group or supergroup
+func IsGroup(chat *tele.Chat) bool {
+	return chat != nil && (chat.Type == tele.ChatGroup || chat.Type == tele.ChatSuperGroup)
+}
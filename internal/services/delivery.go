@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+	"unicode/utf16"
+
+	"github.com/bbr/telestory-api-based/internal/models"
+	tele "gopkg.in/telebot.v3"
+)
+
+// maxAlbumSize is the largest media group Telegram accepts
+const maxAlbumSize = 10
+
+// MaxCaptionLength is Telegram's media caption limit, counted in UTF-16 code units
+const MaxCaptionLength = 1024
+
+// CaptionWithFooter appends footer to caption, shortening caption with an ellipsis
+// so the whole fits in MaxCaptionLength
+func CaptionWithFooter(caption, footer string) string {
+	if utf16Len(caption)+utf16Len(footer) <= MaxCaptionLength {
+		return caption + footer
+	}
+	budget := MaxCaptionLength - utf16Len(footer) - 1 // room for the ellipsis
+	n := 0
+	for i, r := range caption {
+		n += utf16.RuneLen(r)
+		if n > budget {
+			return caption[:i] + "…" + footer
+		}
+	}
+	return caption + footer
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+// formatUserCaption builds the caption of a delivered story according to the user's settings
+func formatUserCaption(settings *models.UserSettings, caption, fallback string, date time.Time) string {
+	storyDate := date.In(settings.Location()).Format("2006-01-02 15:04")
+
+	switch settings.CaptionFormat {
+	case models.CaptionNone:
+		return ""
+	case models.CaptionDate:
+		return fmt.Sprintf("📅 %s", storyDate)
+	default:
+		if caption == "" {
+			caption = fallback
+		}
+		return CaptionWithFooter(caption, "\n\n📅 "+storyDate)
+	}
+}
+
+// archivedMedia returns the media of an archive channel message ready to be re-sent by file ID
+func archivedMedia(archiveMsg *tele.Message, caption string) tele.Inputtable {
+	switch {
+	case archiveMsg.Video != nil:
+		return &tele.Video{File: tele.File{FileID: archiveMsg.Video.FileID}, Caption: caption}
+	case archiveMsg.Photo != nil:
+		return &tele.Photo{File: tele.File{FileID: archiveMsg.Photo.FileID}, Caption: caption}
+	}
+	return nil
+}
+
+// sendStories sends media to the recipient individually or as albums and returns how many were sent
+func sendStories(ctx context.Context, bot *tele.Bot, to tele.Recipient, media []tele.Inputtable, settings *models.UserSettings) int {
+	opts := &tele.SendOptions{DisableNotification: !settings.Notifications}
+
+	sent := 0
+	if settings.DeliveryStyle == models.DeliveryStyleAlbum {
+		for start := 0; start < len(media); start += maxAlbumSize {
+			chunk := media[start:min(start+maxAlbumSize, len(media))]
+			// Albums need at least two items
+			if len(chunk) == 1 {
+				individual := *settings
+				individual.DeliveryStyle = models.DeliveryStyleIndividual
+				sent += sendStories(ctx, bot, to, chunk, &individual)
+				continue
+			}
+			if _, err := bot.SendAlbum(to, tele.Album(chunk), opts); err != nil {
+				slog.WarnContext(ctx, "Failed to send album to user", "error", err)
+				continue
+			}
+			sent += len(chunk)
+		}
+		return sent
+	}
+
+	for _, item := range media {
+		if _, err := bot.Send(to, item, opts); err != nil {
+			slog.WarnContext(ctx, "Failed to send story to user", "error", err)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
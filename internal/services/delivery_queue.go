@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/i18n"
+	"github.com/bbr/telestory-api-based/internal/logging"
+	"github.com/bbr/telestory-api-based/internal/models"
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	queuePollInterval = time.Second
+	jobHeartbeat      = 30 * time.Second
+	jobStaleAfter     = 2 * time.Minute // several missed heartbeats
+	jobMaxAttempts    = 3
+	jobRetention      = 7 * 24 * time.Hour
+)
+
+// deliveryPayload is everything a worker on another instance needs to run a delivery
+type deliveryPayload struct {
+	User      models.User       `json:"user"` // snapshot, group members may have a fallback language
+	ChatID    int64             `json:"chat_id"`
+	MessageID int               `json:"message_id"` // processing message edited with progress
+	Request   StoryRequest      `json:"request"`
+	Response  TeleStoryResponse `json:"response"`
+	RequestID string            `json:"request_id"` // keeps the log trail across instances
+}
+
+func (s *DownloadService) enqueueDelivery(ctx context.Context, msg *tele.Message, user *models.User, req StoryRequest, apiResp *TeleStoryResponse) error {
+	payload, err := json.Marshal(deliveryPayload{
+		User:      *user,
+		ChatID:    msg.Chat.ID,
+		MessageID: msg.ID,
+		Request:   req,
+		Response:  *apiResp,
+		RequestID: logging.RequestID(ctx),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode delivery job: %v", err)
+	}
+
+	id, err := s.Queue.Enqueue(ctx, user.ID, payload)
+	if err != nil {
+		return fmt.Errorf("failed to queue delivery: %v", err)
+	}
+	slog.InfoContext(ctx, "Queued delivery job", "job_id", id)
+	return nil
+}
+
+// RunWorkers claims queued deliveries and runs up to concurrency of them until ctx is cancelled.
+// Every instance runs workers; stopping them leaves unclaimed jobs for the other instances.
+// Jobs already running are drained by Shutdown.
+func (s *DownloadService) RunWorkers(ctx context.Context, bot *tele.Bot, concurrency int) {
+	slots := make(chan struct{}, concurrency)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case slots <- struct{}{}:
+		}
+
+		job, err := s.Queue.Claim(ctx, s.InstanceID)
+		if err != nil || job == nil {
+			<-slots
+			if err != nil {
+				slog.ErrorContext(ctx, "Error claiming delivery job", "error", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(queuePollInterval):
+			}
+			continue
+		}
+
+		go func() {
+			defer func() { <-slots }()
+			s.runJob(bot, job)
+		}()
+	}
+}
+
+func (s *DownloadService) runJob(bot *tele.Bot, job *models.DeliveryJob) {
+	ctx := logging.With(context.Background(), "job_id", job.ID)
+	var payload deliveryPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		slog.ErrorContext(ctx, "Delivery job has an invalid payload", "error", err)
+		s.Queue.Finish(ctx, job.ID, models.JobFailed, err.Error())
+		return
+	}
+	ctx = logging.WithRequestID(ctx, payload.RequestID)
+	ctx = logging.With(ctx, "user_id", payload.User.ID, "target", payload.Request.Input)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(jobHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := s.Queue.Heartbeat(ctx, job.ID, s.InstanceID); err != nil {
+					slog.WarnContext(ctx, "Delivery job heartbeat failed", "error", err)
+				}
+			}
+		}
+	}()
+
+	slog.InfoContext(ctx, "Running delivery job", "attempt", job.Attempts)
+	msg := &tele.Message{ID: payload.MessageID, Chat: &tele.Chat{ID: payload.ChatID}}
+	status, lastError := models.JobDone, ""
+	err := s.runDelivery(ctx, bot, msg, &payload.User, payload.Request, &payload.Response)
+	if isShutdownError(err) {
+		slog.WarnContext(ctx, "Requeueing delivery job on shutdown", "error", err)
+		if err := s.Queue.Requeue(ctx, job.ID, err.Error()); err != nil {
+			slog.ErrorContext(ctx, "Error requeueing delivery job", "error", err)
+		}
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Delivery job failed", "error", err)
+		status, lastError = models.JobFailed, err.Error()
+	}
+	if err := s.Queue.Finish(ctx, job.ID, status, lastError); err != nil {
+		slog.ErrorContext(ctx, "Error finishing delivery job", "error", err)
+	}
+}
+
+// SweepJobs requeues jobs of instances that died and purges old finished jobs.
+// Jobs out of attempts free their reservation and tell the user. It runs on the leader only.
+func (s *DownloadService) SweepJobs(ctx context.Context, bot *tele.Bot) {
+	ticker := time.NewTicker(jobStaleAfter / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, failed, err := s.Queue.RequeueStale(ctx, jobStaleAfter, jobMaxAttempts)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error requeueing stale delivery jobs", "error", err)
+		} else if n > 0 {
+			slog.InfoContext(ctx, "Requeued stale delivery jobs", "count", n)
+		}
+		for _, job := range failed {
+			s.failStaleJob(ctx, bot, job)
+		}
+		if _, err := s.Queue.Purge(ctx, jobRetention); err != nil {
+			slog.ErrorContext(ctx, "Error purging delivery jobs", "error", err)
+		}
+	}
+}
+
+// failStaleJob cleans up after a job whose instances kept dying until it ran out of attempts
+func (s *DownloadService) failStaleJob(ctx context.Context, bot *tele.Bot, job models.DeliveryJob) {
+	ctx = logging.With(ctx, "job_id", job.ID, "user_id", job.UserID)
+	var payload deliveryPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		slog.ErrorContext(ctx, "Failed delivery job has an invalid payload", "error", err)
+		return
+	}
+	ctx = logging.WithRequestID(ctx, payload.RequestID)
+	slog.WarnContext(ctx, "Delivery job failed after its instances stopped responding", "attempts", job.Attempts)
+
+	s.ReleaseReservation(ctx, payload.Request)
+
+	userLang := payload.User.LanguageCode
+	if userLang == "" {
+		userLang = "en"
+	}
+	text := i18n.GetMessage(userLang, "delivery_failed")
+	msg := &tele.Message{ID: payload.MessageID, Chat: &tele.Chat{ID: payload.ChatID}}
+	if _, err := bot.Edit(msg, text); err != nil {
+		// The processing message may be gone; answer in the chat instead
+		if _, err := bot.Send(msg.Chat, text); err != nil {
+			slog.WarnContext(ctx, "Failed to tell user about the failed delivery", "error", err)
+		}
+	}
+}
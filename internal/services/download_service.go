@@ -1,108 +1,208 @@
 package services
 
 import (
-	"compress/gzip"
-	"encoding/json"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
-	"strconv"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/bbr/telestory-api-based/internal/callback"
 	"github.com/bbr/telestory-api-based/internal/i18n"
+	"github.com/bbr/telestory-api-based/internal/logging"
+	"github.com/bbr/telestory-api-based/internal/metrics"
 	"github.com/bbr/telestory-api-based/internal/models"
 	"github.com/bbr/telestory-api-based/internal/repositories"
 	tele "gopkg.in/telebot.v3"
 )
 
+// StoryModeUnique is the callback unique of the story subset keyboard
+const StoryModeUnique = "smode"
+
+// SelectionTTL is how long story selection keyboards stay usable
+const SelectionTTL = 10 * time.Minute
+
+// StoryModePayload is the callback payload of the story subset keyboard
+type StoryModePayload struct {
+	Token string
+	Mode  StoryMode
+}
+
+func (p *StoryModePayload) Fields() []string {
+	return []string{p.Token, string(p.Mode)}
+}
+
+func (p *StoryModePayload) Parse(fields []string) error {
+	if len(fields) != 2 {
+		return fmt.Errorf("story mode payload: expected 2 fields, got %d", len(fields))
+	}
+	p.Token, p.Mode = fields[0], StoryMode(fields[1])
+	return nil
+}
+
 type DownloadService struct {
 	DownloadRepo *repositories.DownloadRepository
+	ArchiveRepo  *repositories.ArchiveRepository
+	UserService  *UserService
+	Provider     StoryProvider
 	HTTPClient   *http.Client
+	Callbacks    *callback.Signer
+	Runtime      *RuntimeSettings // provides the archive channel every story is uploaded to
+
+	// Queue distributes deliveries across instances; nil runs them in the handling goroutine
+	Queue      *repositories.DeliveryJobRepository
+	InstanceID string
+
+	mu      sync.Mutex
+	pending map[string]*pendingSelection
+	resends map[int64]time.Time // last /history re-send per user
+
+	// In-flight deliveries, drained on shutdown
+	jobs     map[*deliveryJob]struct{}
+	jobsDone sync.WaitGroup
+	closing  bool
+	ctx      context.Context // cancelled when shutdown gives up waiting
+	cancel   context.CancelFunc
+}
+
+// pendingSelection holds fetched stories while the user picks what to receive
+type pendingSelection struct {
+	user      *models.User
+	req       StoryRequest
+	resp      *TeleStoryResponse
+	createdAt time.Time
+	linked    bool // the reservation knows the token, see DownloadRepository.SetSelectionToken
+
+	// Story picker state
+	loc         *time.Location
+	selected    map[int]bool
+	page        int
+	rangeMode   bool
+	rangeAnchor int
 }
 
-func NewDownloadService(downloadRepo *repositories.DownloadRepository) *DownloadService {
+func NewDownloadService(downloadRepo *repositories.DownloadRepository, archiveRepo *repositories.ArchiveRepository, userService *UserService, provider StoryProvider, callbacks *callback.Signer, runtime *RuntimeSettings) *DownloadService {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &DownloadService{
 		DownloadRepo: downloadRepo,
+		ArchiveRepo:  archiveRepo,
+		UserService:  userService,
+		Provider:     provider,
 		HTTPClient:   &http.Client{},
+		Callbacks:    callbacks,
+		Runtime:      runtime,
+		pending:      make(map[string]*pendingSelection),
+		resends:      make(map[int64]time.Time),
+		jobs:         make(map[*deliveryJob]struct{}),
+		ctx:          ctx,
+		cancel:       cancel,
 	}
 }
 
-// TeleStoryResponse represents the API response structure
-type TeleStoryResponse struct {
-	OK      bool    `json:"ok"`
-	Stories []Story `json:"stories"`
-	BaseURL string  `json:"base_url"`
-	Success bool    `json:"success"`
-	Error   string  `json:"error"`
+// saveArchivedStory records the archive channel file ID of a delivered story and returns its ID, or 0
+func (s *DownloadService) saveArchivedStory(ctx context.Context, input string, story Story, archiveMsg *tele.Message) int {
+	archived := &models.ArchivedStory{
+		Target:           NormalizeTarget(input),
+		StoryID:          story.ID,
+		Caption:          story.Caption,
+		StoryDate:        time.Unix(story.Date, 0),
+		ArchiveMessageID: archiveMsg.ID,
+	}
+	switch {
+	case archiveMsg.Video != nil:
+		archived.MediaType, archived.FileID = "video", archiveMsg.Video.FileID
+	case archiveMsg.Photo != nil:
+		archived.MediaType, archived.FileID = "photo", archiveMsg.Photo.FileID
+	default:
+		return 0
+	}
+
+	if err := s.ArchiveRepo.Save(ctx, archived); err != nil {
+		slog.ErrorContext(ctx, "Failed to save archived story", "story_id", story.ID, "error", err)
+		return 0
+	}
+	return archived.ID
 }
 
-type Story struct {
-	URL     string `json:"url"`
-	Date    int64  `json:"date"`
-	Caption string `json:"caption"`
+// CachedStories returns stories of a target already present in the archive channel, newest first
+func (s *DownloadService) CachedStories(ctx context.Context, input string, limit, offset int) ([]models.ArchivedStory, error) {
+	return s.ArchiveRepo.ListByTarget(ctx, NormalizeTarget(input), limit, offset)
 }
 
-func (s *DownloadService) FetchStoriesByInput(input string) (*TeleStoryResponse, error) {
-	apiKey := os.Getenv("TELESTORY_API_KEY")
-	apiURL := os.Getenv("TELESTORY_API_URL")
+// DownloadStoryMedia downloads a story from URL to temp file
+func (s *DownloadService) DownloadStoryMedia(ctx context.Context, baseURL, storyURL string, index int) (string, error) {
+	if baseURL == "" {
+		return "", fmt.Errorf("base URL is empty")
+	}
 
-	if apiKey == "" || apiURL == "" {
-		return nil, fmt.Errorf("TELESTORY_API_KEY or TELESTORY_API_URL not set")
+	// Build full URL - ensure proper path separator
+	fullURL := baseURL
+	if !strings.HasSuffix(baseURL, "/") && !strings.HasPrefix(storyURL, "/") {
+		fullURL += "/"
 	}
+	fullURL += storyURL
 
-	// Clean input (remove @ for username or + for phone number)
-	cleanInput := strings.TrimPrefix(input, "@")
-	cleanInput = strings.TrimPrefix(cleanInput, "+")
+	slog.DebugContext(ctx, "Downloading story media", "url", fullURL)
 
-	// Build request URL
-	reqURL := fmt.Sprintf("%s/get_stories_by_username?api_key=%s&username=%s&archive=true&mark=true",
-		apiURL, url.QueryEscape(apiKey), url.QueryEscape(cleanInput))
+	// Create temp file
+	ext := filepath.Ext(storyURL)
+	if ext == "" {
+		ext = ".mp4" // default to video
+	}
+	tempFile := filepath.Join(os.TempDir(), fmt.Sprintf("telestory-%d-%d%s", time.Now().Unix(), index, ext))
 
-	// Create request
-	req, err := http.NewRequest("GET", reqURL, nil)
+	// Download file; aborted if shutdown cancels the job
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodGet, fullURL, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
+		return "", fmt.Errorf("failed to create request: %v", err)
 	}
-
-	// Set headers
-	req.Header.Set("User-Agent", "TeleStory Android Client v1.43Build: 79, Patch: 20250820")
-	req.Header.Set("Accept-Encoding", "gzip")
-
-	// Execute request
+	start := time.Now()
 	resp, err := s.HTTPClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute request: %v", err)
+		metrics.MediaDownloads.With("network_error").Inc()
+		return "", fmt.Errorf("failed to download: %v", err)
 	}
 	defer resp.Body.Close()
 
-	// Handle gzip response
-	var reader io.ReadCloser
-	if resp.Header.Get("Content-Encoding") == "gzip" {
-		reader, err = gzip.NewReader(resp.Body)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create gzip reader: %v", err)
-		}
-		defer reader.Close()
-	} else {
-		reader = resp.Body
+	if resp.StatusCode != http.StatusOK {
+		metrics.MediaDownloads.With("bad_status").Inc()
+		return "", fmt.Errorf("bad status: %s", resp.Status)
 	}
 
-	// Parse JSON response
-	var apiResp TeleStoryResponse
-	if err := json.NewDecoder(reader).Decode(&apiResp); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %v", err)
+	// Create file
+	out, err := os.Create(tempFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to create file: %v", err)
 	}
+	defer out.Close()
 
-	return &apiResp, nil
+	// Write to file
+	written, err := io.Copy(out, resp.Body)
+	metrics.MediaDownloadBytes.With().Add(float64(written))
+	if err != nil {
+		metrics.MediaDownloads.With("write_error").Inc()
+		os.Remove(tempFile)
+		return "", fmt.Errorf("failed to write file: %v", err)
+	}
+	metrics.MediaDownloads.With("ok").Inc()
+	metrics.MediaDownloadDuration.With().Observe(time.Since(start).Seconds())
+
+	return tempFile, nil
 }
 
-func (s *DownloadService) ProcessDownload(ctx tele.Context, user *models.User, input string) error {
+// ProcessDownloadWithEdit edits an existing message with the result and downloads/uploads stories.
+// When req.Mode is empty and no story ID is given, the active stories are counted first and
+// the user is asked which subset to receive.
+func (s *DownloadService) ProcessDownloadWithEdit(ctx context.Context, bot *tele.Bot, msg *tele.Message, user *models.User, req StoryRequest) error {
 	// Get user's language
 	userLang := user.LanguageCode
 	if userLang == "" {
@@ -110,155 +210,323 @@ func (s *DownloadService) ProcessDownload(ctx tele.Context, user *models.User, i
 	}
 
 	// Fetch stories from TeleStory API
-	apiResp, err := s.FetchStoriesByInput(input)
+	apiResp, err := s.Provider.FetchStories(ctx, req)
 	if err != nil {
-		// Log the failed download
-		s.DownloadRepo.Create(&models.Download{
-			UserID: user.ID,
-			Input:  input,
-			Status: "failed",
-		})
-		return ctx.Send(fmt.Sprintf(i18n.GetMessage(userLang, "fetch_error"), err.Error()))
+		// Log the failed download; the error may hold the API URL, so users only get a generic message
+		// and the caller logs the detail redacted
+		s.recordDownload(ctx, user, req, "failed")
+		bot.Edit(msg, i18n.GetMessage(userLang, "fetch_error"))
+		return err
 	}
 
-	// Count stories
-	storyCount := len(apiResp.Stories)
+	slog.InfoContext(ctx, "Fetched stories", "stories", len(apiResp.Stories))
 
-	// Send result to user
-	var message string
-	if storyCount == 0 {
+	// Ask which subset to deliver now that the count is known
+	if req.Mode == "" && req.StoryID == 0 {
+		return s.askStoryMode(ctx, bot, msg, user, req, apiResp)
+	}
+
+	if len(apiResp.Stories) == 0 {
 		// Log the failed download (no stories)
-		s.DownloadRepo.Create(&models.Download{
-			UserID: user.ID,
-			Input:  input,
-			Status: "failed",
-		})
-		message = fmt.Sprintf(i18n.GetMessage(userLang, "no_stories"), input)
-	} else {
-		message = fmt.Sprintf(i18n.GetMessage(userLang, "story_count"), storyCount, input)
+		s.recordDownload(ctx, user, req, "failed")
+		var message string
+		if req.StoryID != 0 {
+			message = fmt.Sprintf(i18n.GetMessage(userLang, "story_not_found"), req.StoryID, req.Input)
+		} else {
+			message = fmt.Sprintf(i18n.GetMessage(userLang, "no_stories"), req.Input)
+		}
+		bot.Edit(msg, message, &tele.SendOptions{ParseMode: tele.ModeMarkdown})
+		return nil
 	}
 
-	if err := ctx.Send(message, &tele.SendOptions{ParseMode: tele.ModeMarkdown}); err != nil {
+	// Let the user pick individual stories when there is more than one
+	if req.StoryID == 0 && req.Mode != StoryModeLatest && len(apiResp.Stories) > 1 {
+		return s.showStoryPicker(ctx, bot, msg, user, req, apiResp)
+	}
+
+	return s.deliverStories(ctx, bot, msg, user, req, apiResp)
+}
+
+// askStoryMode stores the fetched active stories and edits msg into a subset keyboard
+func (s *DownloadService) askStoryMode(ctx context.Context, bot *tele.Bot, msg *tele.Message, user *models.User, req StoryRequest, apiResp *TeleStoryResponse) error {
+	userLang := user.LanguageCode
+	input := req.Input
+	token, err := s.storePending(ctx, &pendingSelection{user: user, req: req, resp: apiResp})
+	if err != nil {
 		return err
 	}
 
-	if storyCount > 0 {
-		// Log the success download
-		download := &models.Download{
-			UserID: user.ID,
-			Input:  input,
-			Status: "success",
+	storyCount := len(apiResp.Stories)
+	menu := &tele.ReplyMarkup{}
+	kb := s.Callbacks.Keyboard()
+	btnArchive := kb.Button(i18n.GetMessage(userLang, "mode_archive"), StoryModeUnique, &StoryModePayload{Token: token, Mode: StoryModeArchive})
+	btnLatest := kb.Button(i18n.GetMessage(userLang, "mode_latest"), StoryModeUnique, &StoryModePayload{Token: token, Mode: StoryModeLatest})
+	btnActive := kb.Button(fmt.Sprintf(i18n.GetMessage(userLang, "mode_active"), storyCount), StoryModeUnique, &StoryModePayload{Token: token, Mode: StoryModeActive})
+	if err := kb.Err(); err != nil {
+		s.takePending(ctx, token, user.ID)
+		s.ReleaseReservation(ctx, req)
+		return err
+	}
+	includeArchive := s.UserService.SettingsOrDefault(ctx, user.ID).IncludeArchive
+
+	if storyCount == 0 {
+		if !includeArchive {
+			s.takePending(ctx, token, user.ID)
+			s.ReleaseReservation(ctx, req)
+			message := fmt.Sprintf(i18n.GetMessage(userLang, "no_stories"), input)
+			_, err = bot.Edit(msg, message, tele.ModeMarkdown)
+			return err
 		}
+		menu.Inline(menu.Row(btnArchive))
+		message := fmt.Sprintf(i18n.GetMessage(userLang, "no_active_stories"), input)
+		_, err = bot.Edit(msg, message, menu, tele.ModeMarkdown)
+		return err
+	}
+
+	rows := []tele.Row{menu.Row(btnLatest), menu.Row(btnActive)}
+	if includeArchive {
+		rows = append(rows, menu.Row(btnArchive))
+	}
+	menu.Inline(rows...)
 
-		if err := s.DownloadRepo.Create(download); err != nil {
-			return fmt.Errorf("failed to log download: %v", err)
+	message := fmt.Sprintf(i18n.GetMessage(userLang, "choose_mode"), storyCount, input)
+	_, err = bot.Edit(msg, message, menu, tele.ModeMarkdown)
+	return err
+}
+
+// ProcessStoryMode delivers the subset picked from the keyboard created by askStoryMode
+func (s *DownloadService) ProcessStoryMode(ctx context.Context, bot *tele.Bot, msg *tele.Message, userID int64, token string, mode StoryMode) error {
+	sel, err := s.takePending(ctx, token, userID)
+	if err != nil {
+		return err
+	}
+	ctx = logging.With(ctx, "target", sel.req.Input)
+
+	switch mode {
+	case StoryModeLatest:
+		resp := *sel.resp
+		resp.Stories = LatestStories(sel.resp.Stories, 1)
+		return s.deliverStories(ctx, bot, msg, sel.user, sel.req, &resp)
+	case StoryModeActive:
+		if len(sel.resp.Stories) > 1 {
+			return s.showStoryPicker(ctx, bot, msg, sel.user, sel.req, sel.resp)
 		}
+		return s.deliverStories(ctx, bot, msg, sel.user, sel.req, sel.resp)
+	default:
+		req := sel.req
+		req.Mode = StoryModeArchive
+		return s.ProcessDownloadWithEdit(ctx, bot, msg, sel.user, req)
 	}
+}
 
-	return nil
+// recordDownload logs a finished request, consuming its reservation if it has one
+func (s *DownloadService) recordDownload(ctx context.Context, user *models.User, req StoryRequest, status string) (*models.Download, error) {
+	download := &models.Download{
+		UserID: user.ID,
+		Input:  req.Input,
+		Status: status,
+	}
+	if req.ReservationID == 0 {
+		return download, s.DownloadRepo.Create(ctx, download)
+	}
+	return download, s.DownloadRepo.CreateReserved(ctx, download, req.ReservationID)
 }
 
-// DownloadStoryMedia downloads a story from URL to temp file
-func (s *DownloadService) DownloadStoryMedia(baseURL, storyURL string, index int) (string, error) {
-	if baseURL == "" {
-		return "", fmt.Errorf("base URL is empty")
+// ReleaseReservation frees the quota slot of a request that ends without a download
+func (s *DownloadService) ReleaseReservation(ctx context.Context, req StoryRequest) {
+	if req.ReservationID == 0 {
+		return
+	}
+	if err := s.DownloadRepo.Release(ctx, req.ReservationID); err != nil {
+		slog.ErrorContext(ctx, "Failed to release reservation", "reservation_id", req.ReservationID, "error", err)
 	}
+}
 
-	// Build full URL - ensure proper path separator
-	fullURL := baseURL
-	if !strings.HasSuffix(baseURL, "/") && !strings.HasPrefix(storyURL, "/") {
-		fullURL += "/"
+// ErrSelectionExpired is returned when a story subset keyboard is no longer usable
+var ErrSelectionExpired = errors.New("story selection expired")
+
+// ErrSelectionForbidden is returned when someone else presses a requester's keyboard in a group
+var ErrSelectionForbidden = errors.New("story selection belongs to another user")
+
+// storePending keeps sel until it is taken or expires and returns its callback token.
+// An older selection of the same user is replaced, since only the newest keyboard is in use.
+func (s *DownloadService) storePending(ctx context.Context, sel *pendingSelection) (string, error) {
+	token, err := newSelectionToken()
+	if err != nil {
+		return "", err
+	}
+	sel.createdAt = time.Now()
+	// Lets another instance release the reservation if this one goes away before the user chooses
+	if sel.req.ReservationID != 0 {
+		if err := s.DownloadRepo.SetSelectionToken(ctx, sel.req.ReservationID, token); err != nil {
+			slog.WarnContext(ctx, "Failed to link selection to its reservation", "reservation_id", sel.req.ReservationID, "error", err)
+		} else {
+			sel.linked = true
+		}
 	}
-	fullURL += storyURL
 
-	log.Printf("Attempting download from: %s", fullURL)
+	s.mu.Lock()
+	dropped := s.cleanupPendingLocked()
+	for old, prev := range s.pending {
+		if prev.user.ID == sel.user.ID {
+			delete(s.pending, old)
+			dropped = append(dropped, prev)
+		}
+	}
+	s.pending[token] = sel
+	s.mu.Unlock()
+
+	s.releaseSelections(dropped)
+	// Expire on time even if nobody touches the selections again, so an ignored keyboard
+	// does not hold the reservation until ReservationTTL
+	time.AfterFunc(SelectionTTL, func() { s.expirePending(token, sel) })
+	return token, nil
+}
 
-	// Create temp file
-	ext := filepath.Ext(storyURL)
-	if ext == "" {
-		ext = ".mp4" // default to video
+// expirePending drops sel if it is still waiting under token
+func (s *DownloadService) expirePending(token string, sel *pendingSelection) {
+	s.mu.Lock()
+	live := s.pending[token] == sel
+	if live {
+		delete(s.pending, token)
 	}
-	tempFile := filepath.Join(os.TempDir(), fmt.Sprintf("telestory-%d-%d%s", time.Now().Unix(), index, ext))
+	s.mu.Unlock()
 
-	// Download file
-	resp, err := s.HTTPClient.Get(fullURL)
-	if err != nil {
-		return "", fmt.Errorf("failed to download: %v", err)
+	if live {
+		s.releaseSelections([]*pendingSelection{sel})
 	}
-	defer resp.Body.Close()
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("bad status: %s", resp.Status)
+// getPending returns the selection behind token if it belongs to userID.
+// A token this instance does not know may come from a keyboard of an instance that stopped;
+// its reservation is released, since the selection is lost.
+func (s *DownloadService) getPending(ctx context.Context, token string, userID int64) (*pendingSelection, error) {
+	s.mu.Lock()
+	dropped := s.cleanupPendingLocked()
+	sel, ok := s.pending[token]
+	s.mu.Unlock()
+
+	s.releaseSelections(dropped)
+	if !ok {
+		if released, err := s.DownloadRepo.ReleaseSelection(ctx, token, userID); err != nil {
+			slog.ErrorContext(ctx, "Failed to release the reservation of a lost selection", "error", err)
+		} else if released {
+			slog.InfoContext(ctx, "Released the reservation of a lost selection")
+		}
+		return nil, ErrSelectionExpired
+	}
+	if sel.user.ID != userID {
+		return nil, ErrSelectionForbidden
 	}
+	return sel, nil
+}
 
-	// Create file
-	out, err := os.Create(tempFile)
+// takePending is getPending that also removes the selection
+func (s *DownloadService) takePending(ctx context.Context, token string, userID int64) (*pendingSelection, error) {
+	sel, err := s.getPending(ctx, token, userID)
 	if err != nil {
-		return "", fmt.Errorf("failed to create file: %v", err)
+		return nil, err
 	}
-	defer out.Close()
+	// Unlink first, so a second press that no longer finds the selection cannot release
+	// the reservation this one goes on to use
+	if sel.linked {
+		claimed, err := s.DownloadRepo.ClaimSelection(ctx, token)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to unlink selection from its reservation", "error", err)
+		} else if !claimed {
+			return nil, ErrSelectionExpired
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending[token] != sel {
+		return nil, ErrSelectionExpired // dropped in the meantime, its reservation is released
+	}
+	delete(s.pending, token)
+	return sel, nil
+}
 
-	// Write to file
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		os.Remove(tempFile)
-		return "", fmt.Errorf("failed to write file: %v", err)
+// cleanupPendingLocked removes expired selections and returns them so their reservations
+// can be released once the lock is dropped
+func (s *DownloadService) cleanupPendingLocked() []*pendingSelection {
+	var dropped []*pendingSelection
+	for token, sel := range s.pending {
+		if time.Since(sel.createdAt) > SelectionTTL {
+			delete(s.pending, token)
+			dropped = append(dropped, sel)
+		}
 	}
+	return dropped
+}
 
-	return tempFile, nil
+// releaseSelections frees the quota slots of selections the user abandoned
+func (s *DownloadService) releaseSelections(dropped []*pendingSelection) {
+	for _, sel := range dropped {
+		s.ReleaseReservation(context.Background(), sel.req)
+	}
+}
+
+func newSelectionToken() (string, error) {
+	buf := make([]byte, 6)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate selection token: %v", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// deliverStories runs the delivery here, or queues it for any instance in cluster mode
+func (s *DownloadService) deliverStories(ctx context.Context, bot *tele.Bot, msg *tele.Message, user *models.User, req StoryRequest, apiResp *TeleStoryResponse) error {
+	if s.Queue != nil {
+		return s.enqueueDelivery(ctx, msg, user, req, apiResp)
+	}
+	return s.runDelivery(ctx, bot, msg, user, req, apiResp)
 }
 
-// ProcessDownloadWithEdit edits an existing message with the result and downloads/uploads stories
-func (s *DownloadService) ProcessDownloadWithEdit(bot *tele.Bot, msg *tele.Message, user *models.User, input string) error {
+// runDelivery downloads the stories in apiResp, uploads them to the archive and sends them to the user
+// It returns ErrShuttingDown or ErrDeliveryInterrupted when shutdown stops it, without recording the download.
+func (s *DownloadService) runDelivery(ctx context.Context, bot *tele.Bot, msg *tele.Message, user *models.User, req StoryRequest, apiResp *TeleStoryResponse) (err error) {
+	input := req.Input
+
 	// Get user's language
 	userLang := user.LanguageCode
 	if userLang == "" {
 		userLang = "en"
 	}
 
-	// Get archive channel ID
-	archiveChannelID := os.Getenv("ARCHIVE_CHANNEL_ID")
-	if archiveChannelID == "" {
+	// Free the quota slot on every path that does not record the download,
+	// except for queued jobs that shutdown puts back for another instance
+	recorded := false
+	defer func() {
+		if !recorded && !(s.Queue != nil && isShutdownError(err)) {
+			s.ReleaseReservation(ctx, req)
+		}
+	}()
+
+	archiveChatID := s.Runtime.ArchiveChannelID()
+	if archiveChatID == 0 {
 		return fmt.Errorf("ARCHIVE_CHANNEL_ID not set")
 	}
 
-	// Fetch stories from TeleStory API
-	apiResp, err := s.FetchStoriesByInput(input)
+	userChat := req.Recipient(user)
+	job, err := s.startJob(userChat, userLang, input)
 	if err != nil {
-		// Log the failed download
-		s.DownloadRepo.Create(&models.Download{
-			UserID: user.ID,
-			Input:  input,
-			Status: "failed",
-		})
-		errorMsg := fmt.Sprintf(i18n.GetMessage(userLang, "fetch_error"), err.Error())
-		bot.Edit(msg, errorMsg)
+		// A queued job is requeued and keeps its progress message
+		if s.Queue == nil {
+			if _, editErr := bot.Edit(msg, i18n.GetMessage(userLang, "shutdown_restarting")); editErr != nil {
+				slog.WarnContext(ctx, "Failed to tell user about the restart", "error", editErr)
+			}
+		}
 		return err
 	}
-
-	log.Printf("API Response - BaseURL: '%s', Stories: %d", apiResp.BaseURL, len(apiResp.Stories))
+	defer s.finishJob(job)
 
 	// Count stories
 	storyCount := len(apiResp.Stories)
 
-	if storyCount == 0 {
-		// Log the failed download (no stories)
-		s.DownloadRepo.Create(&models.Download{
-			UserID: user.ID,
-			Input:  input,
-			Status: "failed",
-		})
-		message := fmt.Sprintf(i18n.GetMessage(userLang, "no_stories"), input)
-		bot.Edit(msg, message, &tele.SendOptions{ParseMode: tele.ModeMarkdown})
-		return nil
-	}
-
 	// Edit message to show downloading status
 	downloadingMsg := fmt.Sprintf(i18n.GetMessage(userLang, "downloading"), storyCount)
 	bot.Edit(msg, downloadingMsg)
 
-	log.Printf("Using base URL for downloads: %s", apiResp.BaseURL)
-
 	// Download stories asynchronously
 	type downloadResult struct {
 		index    int
@@ -270,17 +538,14 @@ func (s *DownloadService) ProcessDownloadWithEdit(bot *tele.Bot, msg *tele.Messa
 	results := make(chan downloadResult, storyCount)
 	var wg sync.WaitGroup
 
-	log.Printf("Starting async download of %d stories", storyCount)
+	slog.InfoContext(ctx, "Starting story downloads", "stories", storyCount)
 	for i, story := range apiResp.Stories {
 		wg.Add(1)
 		go func(idx int, st Story) {
 			defer wg.Done()
-			log.Printf("Downloading story %d: %s", idx, st.URL)
-			filePath, err := s.DownloadStoryMedia(apiResp.BaseURL, st.URL, idx)
+			filePath, err := s.DownloadStoryMedia(ctx, apiResp.BaseURL, st.URL, idx)
 			if err != nil {
-				log.Printf("Failed to download story %d: %v", idx, err)
-			} else {
-				log.Printf("Successfully downloaded story %d to %s", idx, filePath)
+				slog.WarnContext(ctx, "Failed to download story", "index", idx, "story_id", st.ID, "error", err)
 			}
 			results <- downloadResult{index: idx, filePath: filePath, story: st, err: err}
 		}(i, story)
@@ -300,20 +565,28 @@ func (s *DownloadService) ProcessDownloadWithEdit(bot *tele.Bot, msg *tele.Messa
 		}
 	}
 
-	log.Printf("Downloaded %d/%d stories successfully", len(downloaded), storyCount)
+	slog.InfoContext(ctx, "Downloaded stories", "downloaded", len(downloaded), "stories", storyCount)
 
 	// Upload to archive and forward to user
-	archiveChatID, _ := strconv.ParseInt(archiveChannelID, 10, 64)
 	archiveChat, _ := bot.ChatByID(archiveChatID)
-	userChat := &tele.User{ID: user.ID}
+	settings := s.UserService.SettingsOrDefault(ctx, user.ID)
 
-	log.Printf("Archive chat ID: %d, User ID: %d", archiveChatID, user.ID)
+	// Keep the order the stories were requested in
+	sort.Slice(downloaded, func(i, j int) bool { return downloaded[i].index < downloaded[j].index })
 
-	successCount := 0
+	outgoing := make([]tele.Inputtable, 0, len(downloaded))
+	archivedIDs := make([]int, 0, len(downloaded))
 	for _, result := range downloaded {
+		// Shutdown cancelled the job; only clean up
+		if s.ctx.Err() != nil {
+			os.Remove(result.filePath)
+			continue
+		}
+
 		// Build caption for archive channel (detailed)
-		storyDate := time.Unix(result.story.Date, 0).Format("2006-01-02 15:04")
-		archiveCaption := fmt.Sprintf(
+		storyTime := time.Unix(result.story.Date, 0)
+		storyDate := storyTime.Format("2006-01-02 15:04")
+		archiveCaption := CaptionWithFooter(fmt.Sprintf(
 			"📥 Requested by: %s %s (@%s)\n📍 Target: %s\n📅 Story Date: %s\n\n%s",
 			user.FirstName,
 			user.LastName,
@@ -321,20 +594,17 @@ func (s *DownloadService) ProcessDownloadWithEdit(bot *tele.Bot, msg *tele.Messa
 			input,
 			storyDate,
 			result.story.Caption,
-		)
+		), "")
 
-		// Build caption for user (simple)
-		userCaption := result.story.Caption
-		if userCaption == "" {
-			userCaption = fmt.Sprintf(i18n.GetMessage(userLang, "story_from"), input)
-		}
-		userCaption = fmt.Sprintf("%s\n\n📅 %s", userCaption, storyDate)
+		// Build caption for user according to their settings
+		fallback := fmt.Sprintf(i18n.GetMessage(userLang, "story_from"), input)
+		userCaption := formatUserCaption(settings, result.story.Caption, fallback, storyTime)
 
 		// Determine media type by file extension
 		var archiveMsg *tele.Message
 		var uploadErr error
 
-		log.Printf("Uploading story to archive: %s", result.filePath)
+		uploadStart := time.Now()
 		if strings.HasSuffix(result.filePath, ".mp4") || strings.HasSuffix(result.filePath, ".mov") {
 			// Upload video to archive
 			video := &tele.Video{File: tele.FromDisk(result.filePath), Caption: archiveCaption}
@@ -345,63 +615,76 @@ func (s *DownloadService) ProcessDownloadWithEdit(bot *tele.Bot, msg *tele.Messa
 			archiveMsg, uploadErr = bot.Send(archiveChat, photo)
 		}
 
+		// Cleanup temp file
+		os.Remove(result.filePath)
+
+		uploadResult := "ok"
 		if uploadErr != nil {
-			log.Printf("Failed to upload to archive: %v", uploadErr)
-			os.Remove(result.filePath)
-			continue
+			uploadResult = "error"
 		}
+		metrics.ArchiveUploadDuration.With(uploadResult).Observe(time.Since(uploadStart).Seconds())
 
-		log.Printf("Uploaded to archive successfully, message ID: %d", archiveMsg.ID)
+		if uploadErr != nil {
+			slog.ErrorContext(ctx, "Failed to upload story to archive", "story_id", result.story.ID, "error", uploadErr)
+			continue
+		}
 
-		// Send to user using file ID from archive message (not forwarding)
-		var userSendErr error
-		log.Printf("Sending to user %d", user.ID)
+		slog.DebugContext(ctx, "Uploaded story to archive", "story_id", result.story.ID, "message_id", archiveMsg.ID)
 
-		if strings.HasSuffix(result.filePath, ".mp4") || strings.HasSuffix(result.filePath, ".mov") {
-			// Send video to user
-			video := &tele.Video{
-				File:    tele.File{FileID: archiveMsg.Video.FileID},
-				Caption: userCaption,
-			}
-			_, userSendErr = bot.Send(userChat, video)
-		} else {
-			// Send photo to user
-			photo := &tele.Photo{
-				File:    tele.File{FileID: archiveMsg.Photo.FileID},
-				Caption: userCaption,
-			}
-			_, userSendErr = bot.Send(userChat, photo)
+		// Remember the file ID so the story can be re-sent without downloading it again
+		if archivedID := s.saveArchivedStory(ctx, input, result.story, archiveMsg); archivedID != 0 {
+			archivedIDs = append(archivedIDs, archivedID)
 		}
 
-		if userSendErr != nil {
-			log.Printf("Failed to send to user: %v", userSendErr)
-		} else {
-			log.Printf("Sent to user successfully")
-			successCount++
+		// Send to user using file ID from archive message (not forwarding)
+		if media := archivedMedia(archiveMsg, userCaption); media != nil {
+			outgoing = append(outgoing, media)
 		}
+	}
 
-		// Cleanup temp file
-		os.Remove(result.filePath)
+	// Nothing is sent: Shutdown already told the user to retry, or the queued job runs again elsewhere
+	if s.ctx.Err() != nil {
+		if s.Queue == nil {
+			bot.Delete(msg)
+		}
+		return ErrDeliveryInterrupted
 	}
 
-	log.Printf("Successfully sent %d/%d stories to user", successCount, len(downloaded))
+	successCount := sendStories(ctx, bot, userChat, outgoing, settings)
+	slog.InfoContext(ctx, "Delivered stories", "sent", successCount, "downloaded", len(downloaded))
 
 	// Delete processing message
 	bot.Delete(msg)
 
+	// Nothing arrived: the failure is logged but does not use up the daily quota
+	if successCount == 0 {
+		bot.Send(userChat, i18n.GetMessage(userLang, "delivery_failed"))
+		unreserved := req
+		unreserved.ReservationID = 0
+		if _, err := s.recordDownload(ctx, user, unreserved, "failed"); err != nil {
+			slog.ErrorContext(ctx, "Failed to log download", "error", err)
+		}
+		return fmt.Errorf("none of the %d stories were delivered", storyCount)
+	}
+
 	// If some stories failed to download, notify user
 	if len(downloaded) < storyCount {
 		errorMsg := fmt.Sprintf(i18n.GetMessage(userLang, "download_error"), len(downloaded), storyCount)
-		bot.Send(&tele.User{ID: user.ID}, errorMsg)
+		bot.Send(userChat, errorMsg)
 	}
 
 	// Log the download
-	download := &models.Download{
-		UserID: user.ID,
-		Input:  input,
-		Status: "success",
+	download, err := s.recordDownload(ctx, user, req, "success")
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to log download", "error", err)
+		return nil
+	}
+	recorded = true
+
+	// Link the archived stories so /history can re-send them
+	if err := s.DownloadRepo.LinkStories(ctx, download.ID, archivedIDs); err != nil {
+		slog.ErrorContext(ctx, "Failed to link stories to download", "download_id", download.ID, "error", err)
 	}
-	s.DownloadRepo.Create(download)
 
 	return nil
 }
@@ -0,0 +1,225 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/export"
+	"github.com/bbr/telestory-api-based/internal/models"
+	"github.com/bbr/telestory-api-based/internal/repositories"
+)
+
+// Exportable datasets
+const (
+	ExportUsers     = "users"
+	ExportDownloads = "downloads"
+)
+
+// ExportDatasets lists the datasets in help order
+var ExportDatasets = []string{ExportUsers, ExportDownloads}
+
+// ExportPartSize keeps every file well under Telegram's 50 MB upload limit for bots
+const ExportPartSize = 45 << 20
+
+var exportHeaders = map[string][]string{
+	ExportUsers: {"id", "first_name", "last_name", "username", "phone_number", "language_code",
+		"is_telegram_premium", "premium_expires_at", "role", "created_at", "last_active_at"},
+	ExportDownloads: {"id", "user_id", "input", "status", "stories", "created_at"},
+}
+
+// ExportService writes datasets filtered by a date range as CSV or XLSX
+type ExportService struct {
+	Repo     *repositories.ExportRepository
+	PartSize int64 // WriteFiles starts a new file once a part reaches this size
+}
+
+func NewExportService(repo *repositories.ExportRepository) *ExportService {
+	return &ExportService{Repo: repo, PartSize: ExportPartSize}
+}
+
+// ExportFile is one part of an export in a temporary file
+type ExportFile struct {
+	Path string
+	Name string // file name shown to the user
+	Rows int
+}
+
+// ValidateExport reports whether dataset and format are supported
+func ValidateExport(dataset, format string) error {
+	if _, ok := exportHeaders[dataset]; !ok {
+		return fmt.Errorf("unknown dataset %q, expected one of %v", dataset, ExportDatasets)
+	}
+	if !slices.Contains(export.Formats, format) {
+		return fmt.Errorf("unknown format %q, expected one of %v", format, export.Formats)
+	}
+	return nil
+}
+
+// ExportName is the file name of an export, part is zero for a single file
+func ExportName(dataset, format string, view StatsView, part int) string {
+	name := fmt.Sprintf("%s_%s_%s", dataset, view.From.Format(time.DateOnly), view.To.Format(time.DateOnly))
+	if part > 0 {
+		name += fmt.Sprintf("_part%d", part)
+	}
+	return name + "." + format
+}
+
+// Write streams a whole export into w and returns the number of data rows
+func (s *ExportService) Write(ctx context.Context, dataset, format string, view StatsView, w io.Writer) (int, error) {
+	if err := ValidateExport(dataset, format); err != nil {
+		return 0, err
+	}
+	out, err := export.New(format, w, exportHeaders[dataset])
+	if err != nil {
+		return 0, fmt.Errorf("failed to start export: %v", err)
+	}
+
+	rows := 0
+	err = s.each(ctx, dataset, view, func(row []any) error {
+		rows++
+		return out.Write(row)
+	})
+	if err != nil {
+		return rows, fmt.Errorf("failed to export %s: %v", dataset, err)
+	}
+	if err := out.Close(); err != nil {
+		return rows, fmt.Errorf("failed to finish export: %v", err)
+	}
+	return rows, nil
+}
+
+// exportPart is the file currently written by WriteFiles
+type exportPart struct {
+	file *os.File
+	size *countingWriter
+	out  export.Writer
+	rows int
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// WriteFiles writes an export to temporary files of about PartSize bytes at most, each with
+// the header row. The caller removes the files; on error they are already removed.
+func (s *ExportService) WriteFiles(ctx context.Context, dataset, format string, view StatsView) (files []ExportFile, err error) {
+	if err := ValidateExport(dataset, format); err != nil {
+		return nil, err
+	}
+
+	var part *exportPart
+	defer func() {
+		if part != nil {
+			part.file.Close()
+		}
+		if err != nil {
+			RemoveExportFiles(files)
+			files = nil
+		}
+	}()
+
+	finish := func() error {
+		files[len(files)-1].Rows = part.rows
+		if err := part.out.Close(); err != nil {
+			return fmt.Errorf("failed to finish export: %v", err)
+		}
+		err := part.file.Close()
+		part = nil
+		return err
+	}
+	start := func() error {
+		f, err := os.CreateTemp("", "export-*."+format)
+		if err != nil {
+			return fmt.Errorf("failed to create export file: %v", err)
+		}
+		files = append(files, ExportFile{Path: f.Name()})
+		part = &exportPart{file: f, size: &countingWriter{w: f}}
+		if part.out, err = export.New(format, part.size, exportHeaders[dataset]); err != nil {
+			return fmt.Errorf("failed to start export: %v", err)
+		}
+		return nil
+	}
+
+	maxRows := export.MaxRows(format)
+	err = s.each(ctx, dataset, view, func(row []any) error {
+		if part != nil && (part.size.n >= s.PartSize || (maxRows > 0 && part.rows >= maxRows)) {
+			if err := finish(); err != nil {
+				return err
+			}
+		}
+		if part == nil {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		part.rows++
+		return part.out.Write(row)
+	})
+	if err != nil {
+		return files, fmt.Errorf("failed to export %s: %v", dataset, err)
+	}
+
+	// An empty export is still one file with the header
+	if part == nil && len(files) == 0 {
+		if err := start(); err != nil {
+			return files, err
+		}
+	}
+	if part != nil {
+		if err := finish(); err != nil {
+			return files, err
+		}
+	}
+
+	for i := range files {
+		n := 0
+		if len(files) > 1 {
+			n = i + 1
+		}
+		files[i].Name = ExportName(dataset, format, view, n)
+	}
+	return files, nil
+}
+
+// RemoveExportFiles deletes the temporary files of WriteFiles
+func RemoveExportFiles(files []ExportFile) {
+	for _, f := range files {
+		os.Remove(f.Path)
+	}
+}
+
+// each calls fn with every row of dataset in the view
+func (s *ExportService) each(ctx context.Context, dataset string, view StatsView, fn func(row []any) error) error {
+	switch dataset {
+	case ExportUsers:
+		return s.Repo.Users(ctx, view.From, view.To, func(u *models.User) error {
+			return fn([]any{u.ID, u.FirstName, u.LastName, u.Username, u.PhoneNumber, u.LanguageCode,
+				u.IsTelegramPremium, nullTime(u.PremiumExpiresAt), u.Role, u.CreatedAt, nullTime(u.LastActiveAt)})
+		})
+	case ExportDownloads:
+		return s.Repo.Downloads(ctx, view.From, view.To, func(d *models.Download) error {
+			return fn([]any{d.ID, d.UserID, d.Input, d.Status, d.StoryCount, d.CreatedAt})
+		})
+	default:
+		return fmt.Errorf("unknown dataset %q", dataset)
+	}
+}
+
+func nullTime(t sql.NullTime) any {
+	if !t.Valid {
+		return nil
+	}
+	return t.Time
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/datasources"
+	"github.com/bbr/telestory-api-based/internal/migrate"
+)
+
+// Health check states
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+	HealthSkipped  = "skipped"
+)
+
+// HealthCheck is the result of one readiness check
+type HealthCheck struct {
+	Status    string `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Error     string `json:"error,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+}
+
+// HealthReport is the readiness breakdown; Status is degraded if any check is
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks"`
+}
+
+// HealthService runs the readiness checks behind /readyz
+type HealthService struct {
+	DB        *sql.DB
+	Migrator  *migrate.Migrator
+	Heartbeat *datasources.UpdatesHeartbeat
+	Breaker   *CircuitBreaker
+
+	// Polling enables the getUpdates freshness check; webhook bots can be idle legitimately
+	Polling bool
+	// TakesUpdates reports whether this instance receives updates; nil means always
+	TakesUpdates func() bool
+
+	Timeout           time.Duration // per check
+	UpdatesStaleAfter time.Duration
+	TempDir           string
+	MinFreeBytes      uint64
+
+	started time.Time
+}
+
+func NewHealthService(db *sql.DB, migrator *migrate.Migrator, heartbeat *datasources.UpdatesHeartbeat, breaker *CircuitBreaker) *HealthService {
+	return &HealthService{
+		DB:                db,
+		Migrator:          migrator,
+		Heartbeat:         heartbeat,
+		Breaker:           breaker,
+		Timeout:           2 * time.Second,
+		UpdatesStaleAfter: time.Minute,
+		TempDir:           os.TempDir(),
+		MinFreeBytes:      100 << 20,
+		started:           time.Now(),
+	}
+}
+
+// Ready runs every check
+func (s *HealthService) Ready(ctx context.Context) HealthReport {
+	report := HealthReport{Status: HealthOK, Checks: make(map[string]HealthCheck)}
+	checks := map[string]func(ctx context.Context) HealthCheck{
+		"database":   s.checkDatabase,
+		"migrations": s.checkMigrations,
+		"telegram":   s.checkTelegram,
+		"telestory":  s.checkTeleStory,
+		"temp_disk":  s.checkTempDisk,
+	}
+	for name, check := range checks {
+		checkCtx, cancel := context.WithTimeout(ctx, s.Timeout)
+		start := time.Now()
+		result := check(checkCtx)
+		cancel()
+
+		result.LatencyMS = time.Since(start).Milliseconds()
+		if result.Status == HealthDegraded {
+			report.Status = HealthDegraded
+		}
+		report.Checks[name] = result
+	}
+	return report
+}
+
+func (s *HealthService) checkDatabase(ctx context.Context) HealthCheck {
+	if err := s.DB.PingContext(ctx); err != nil {
+		return HealthCheck{Status: HealthDegraded, Error: err.Error()}
+	}
+	return HealthCheck{Status: HealthOK}
+}
+
+func (s *HealthService) checkMigrations(ctx context.Context) HealthCheck {
+	pending, err := s.Migrator.Pending(ctx)
+	if err != nil {
+		return HealthCheck{Status: HealthDegraded, Error: err.Error()}
+	}
+	if pending > 0 {
+		return HealthCheck{Status: HealthDegraded, Detail: fmt.Sprintf("%d pending", pending)}
+	}
+	return HealthCheck{Status: HealthOK, Detail: "up to date"}
+}
+
+func (s *HealthService) checkTelegram(ctx context.Context) HealthCheck {
+	if s.TakesUpdates != nil && !s.TakesUpdates() {
+		return HealthCheck{Status: HealthSkipped, Detail: "not receiving updates on this instance"}
+	}
+
+	last := s.Heartbeat.Last()
+	if last.IsZero() {
+		detail := "no updates received yet"
+		if s.Polling && time.Since(s.started) > s.UpdatesStaleAfter {
+			return HealthCheck{Status: HealthDegraded, Detail: detail}
+		}
+		return HealthCheck{Status: HealthOK, Detail: detail}
+	}
+
+	age := time.Since(last).Round(time.Second)
+	detail := fmt.Sprintf("last update delivery %s ago", age)
+	if s.Polling && age > s.UpdatesStaleAfter {
+		return HealthCheck{Status: HealthDegraded, Detail: detail}
+	}
+	return HealthCheck{Status: HealthOK, Detail: detail}
+}
+
+func (s *HealthService) checkTeleStory(ctx context.Context) HealthCheck {
+	state := s.Breaker.State()
+	if state == CircuitOpen {
+		return HealthCheck{Status: HealthDegraded, Detail: "circuit " + state}
+	}
+	return HealthCheck{Status: HealthOK, Detail: "circuit " + state}
+}
+
+func (s *HealthService) checkTempDisk(ctx context.Context) HealthCheck {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(s.TempDir, &st); err != nil {
+		return HealthCheck{Status: HealthDegraded, Error: err.Error()}
+	}
+
+	free := st.Bavail * uint64(st.Bsize)
+	detail := fmt.Sprintf("%d MB free in %s", free>>20, s.TempDir)
+	if free < s.MinFreeBytes {
+		return HealthCheck{Status: HealthDegraded, Detail: detail}
+	}
+	return HealthCheck{Status: HealthOK, Detail: detail}
+}
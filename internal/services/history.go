@@ -0,0 +1,101 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/i18n"
+	"github.com/bbr/telestory-api-based/internal/models"
+	"github.com/bbr/telestory-api-based/internal/repositories"
+	tele "gopkg.in/telebot.v3"
+)
+
+// HistoryPageSize is the number of downloads per /history page
+const HistoryPageSize = 5
+
+// History returns one page of the user's visible downloads and the total number of entries
+func (s *DownloadService) History(ctx context.Context, userID int64, page int) ([]models.Download, int, error) {
+	total, err := s.DownloadRepo.CountByUser(ctx, userID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count history: %v", err)
+	}
+
+	downloads, err := s.DownloadRepo.ListByUser(ctx, userID, HistoryPageSize, page*HistoryPageSize)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list history: %v", err)
+	}
+	return downloads, total, nil
+}
+
+// ResendDownload re-delivers the archived stories of a past download by file ID.
+// It does not call TeleStory and is not counted against the daily limit, but the download cooldown
+// applies between re-sends; denied is the message for the user when it does.
+func (s *DownloadService) ResendDownload(ctx context.Context, bot *tele.Bot, user *models.User, downloadID int) (sent int, denied string, err error) {
+	stories, err := s.ArchiveRepo.ListByDownload(ctx, user.ID, downloadID)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to load archived stories: %v", err)
+	}
+	if len(stories) == 0 {
+		return 0, "", nil
+	}
+	if wait := s.takeResendSlot(user); wait > 0 {
+		return 0, fmt.Sprintf(i18n.GetMessage(user.LanguageCode, "cooldown"), int(math.Ceil(wait.Seconds()))), nil
+	}
+
+	settings := s.UserService.SettingsOrDefault(ctx, user.ID)
+	media := make([]tele.Inputtable, 0, len(stories))
+	for _, st := range stories {
+		fallback := fmt.Sprintf(i18n.GetMessage(user.LanguageCode, "story_from"), st.Target)
+		caption := formatUserCaption(settings, st.Caption, fallback, st.StoryDate)
+		if st.MediaType == "video" {
+			media = append(media, &tele.Video{File: tele.File{FileID: st.FileID}, Caption: caption})
+		} else {
+			media = append(media, &tele.Photo{File: tele.File{FileID: st.FileID}, Caption: caption})
+		}
+	}
+
+	return sendStories(ctx, bot, &tele.User{ID: user.ID}, media, settings), "", nil
+}
+
+// takeResendSlot starts the cooldown of a re-send, or returns how long the user still has to wait.
+// Callbacks are handled by the leader only, so the cooldown can live in memory.
+func (s *DownloadService) takeResendSlot(user *models.User) time.Duration {
+	cooldown := s.Runtime.DownloadCooldown()
+	if user.Role == "admin" || cooldown <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if wait := s.resends[user.ID].Add(cooldown).Sub(now); wait > 0 {
+		return wait
+	}
+	for id, at := range s.resends {
+		if now.Sub(at) >= cooldown {
+			delete(s.resends, id)
+		}
+	}
+	s.resends[user.ID] = now
+	return 0
+}
+
+// DeleteHistoryEntry hides a download from the user's history
+func (s *DownloadService) DeleteHistoryEntry(ctx context.Context, userID int64, downloadID int) (bool, error) {
+	return s.DownloadRepo.Hide(ctx, userID, downloadID)
+}
+
+// SearchDownloads returns a page of downloads of all users for admins and the number of all matches
+func (s *DownloadService) SearchDownloads(ctx context.Context, filter repositories.DownloadFilter, limit, offset int) ([]models.Download, int, error) {
+	total, err := s.DownloadRepo.CountSearch(ctx, filter)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count downloads: %v", err)
+	}
+	downloads, err := s.DownloadRepo.Search(ctx, filter, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search downloads: %v", err)
+	}
+	return downloads, total, nil
+}
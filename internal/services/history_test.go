@@ -0,0 +1,27 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/config"
+	"github.com/bbr/telestory-api-based/internal/models"
+)
+
+func TestResendCooldown(t *testing.T) {
+	s := NewDownloadService(nil, nil, nil, nil, nil, NewRuntimeSettings(nil, &config.Config{DownloadCooldown: time.Minute}))
+	user := &models.User{ID: 1}
+
+	if wait := s.takeResendSlot(user); wait != 0 {
+		t.Fatalf("first re-send waits %s", wait)
+	}
+	if wait := s.takeResendSlot(user); wait <= 0 || wait > time.Minute {
+		t.Fatalf("second re-send waits %s, want up to a minute", wait)
+	}
+	if wait := s.takeResendSlot(&models.User{ID: 2}); wait != 0 {
+		t.Fatalf("another user waits %s", wait)
+	}
+	if wait := s.takeResendSlot(&models.User{ID: 1, Role: "admin"}); wait != 0 {
+		t.Fatalf("admin waits %s", wait)
+	}
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/i18n"
+	tele "gopkg.in/telebot.v3"
+)
+
+// ErrShuttingDown is returned when a delivery starts after shutdown began
+var ErrShuttingDown = errors.New("service is shutting down")
+
+// ErrDeliveryInterrupted is returned when shutdown cancels a running delivery
+var ErrDeliveryInterrupted = errors.New("delivery interrupted by shutdown")
+
+// isShutdownError reports whether a delivery stopped because this instance is shutting down
+func isShutdownError(err error) bool {
+	return errors.Is(err, ErrShuttingDown) || errors.Is(err, ErrDeliveryInterrupted)
+}
+
+// cleanupGrace is how long cancelled jobs get to remove their temp files
+const cleanupGrace = 5 * time.Second
+
+// deliveryJob is a story delivery in progress
+type deliveryJob struct {
+	recipient tele.Recipient
+	lang      string
+	input     string
+}
+
+// startJob registers a delivery so shutdown can wait for it
+func (s *DownloadService) startJob(recipient tele.Recipient, lang, input string) (*deliveryJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing {
+		return nil, ErrShuttingDown
+	}
+	job := &deliveryJob{recipient: recipient, lang: lang, input: input}
+	s.jobs[job] = struct{}{}
+	s.jobsDone.Add(1)
+	return job, nil
+}
+
+func (s *DownloadService) finishJob(job *deliveryJob) {
+	s.mu.Lock()
+	delete(s.jobs, job)
+	s.mu.Unlock()
+	s.jobsDone.Done()
+}
+
+// InFlight returns how many deliveries this instance is running
+func (s *DownloadService) InFlight() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.jobs)
+}
+
+// Shutdown refuses new deliveries and waits for running ones until ctx expires.
+// Jobs still running at the deadline are cancelled; their users are told to retry,
+// or in cluster mode the jobs are requeued.
+// It returns how many jobs were interrupted.
+func (s *DownloadService) Shutdown(ctx context.Context, bot *tele.Bot) int {
+	s.mu.Lock()
+	s.closing = true
+	running := len(s.jobs)
+	s.mu.Unlock()
+	slog.Info("Waiting for delivery jobs to finish", "running", running)
+
+	if waitJobs(ctx, s) {
+		return 0
+	}
+
+	s.mu.Lock()
+	interrupted := make([]*deliveryJob, 0, len(s.jobs))
+	for job := range s.jobs {
+		interrupted = append(interrupted, job)
+	}
+	s.mu.Unlock()
+
+	slog.Warn("Shutdown deadline reached, cancelling delivery jobs", "running", len(interrupted))
+	s.cancel()
+	for _, job := range interrupted {
+		// Queued jobs are put back for another instance, their users need not retry
+		if s.Queue != nil {
+			continue
+		}
+		msg := fmt.Sprintf(i18n.GetMessage(job.lang, "shutdown_interrupted"), job.input)
+		if _, err := bot.Send(job.recipient, msg); err != nil {
+			slog.Warn("Failed to notify interrupted job", "error", err)
+		}
+	}
+
+	// Give cancelled downloads a moment to remove their temp files
+	grace, cancel := context.WithTimeout(context.Background(), cleanupGrace)
+	defer cancel()
+	waitJobs(grace, s)
+	return len(interrupted)
+}
+
+// waitJobs reports whether every job finished before ctx expired
+func waitJobs(ctx context.Context, s *DownloadService) bool {
+	done := make(chan struct{})
+	go func() {
+		s.jobsDone.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
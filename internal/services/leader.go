@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// leaderLockKey is the pg_advisory_lock key held by the cluster leader
+const leaderLockKey = 727_100_039
+
+// leaderCheckInterval is how often followers retry and the leader checks its connection
+const leaderCheckInterval = 5 * time.Second
+
+// Leader elects one instance of the cluster through a session-level advisory lock.
+// The lock is released by Postgres when the leader's connection dies, so another instance takes over.
+type Leader struct {
+	DB         *sql.DB
+	InstanceID string
+
+	mu      sync.Mutex
+	leading bool
+	tasks   []leaderTask
+}
+
+type leaderTask struct {
+	name string
+	run  func(ctx context.Context)
+}
+
+func NewLeader(db *sql.DB, instanceID string) *Leader {
+	return &Leader{DB: db, InstanceID: instanceID}
+}
+
+// RunExclusive registers fn to run only on the leader. fn must return once ctx is cancelled,
+// which happens when leadership is lost or the instance shuts down. Register tasks before Run.
+func (l *Leader) RunExclusive(name string, fn func(ctx context.Context)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tasks = append(l.tasks, leaderTask{name: name, run: fn})
+}
+
+// IsLeader reports whether this instance currently holds the leadership
+func (l *Leader) IsLeader() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.leading
+}
+
+// Run campaigns for leadership until ctx is cancelled, then stops the exclusive tasks
+func (l *Leader) Run(ctx context.Context) {
+	for {
+		if conn, ok := l.acquire(ctx); ok {
+			l.lead(ctx, conn)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(leaderCheckInterval):
+		}
+	}
+}
+
+// acquire returns the connection holding the lock, if this instance won
+func (l *Leader) acquire(ctx context.Context) (*sql.Conn, bool) {
+	conn, err := l.DB.Conn(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Error("Leader election: failed to get connection", "error", err)
+		}
+		return nil, false
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, leaderLockKey).Scan(&acquired); err != nil || !acquired {
+		if err != nil && ctx.Err() == nil {
+			slog.Error("Leader election failed", "error", err)
+		}
+		conn.Close()
+		return nil, false
+	}
+	return conn, true
+}
+
+// lead runs the exclusive tasks while the lock connection stays healthy
+func (l *Leader) lead(ctx context.Context, conn *sql.Conn) {
+	defer conn.Close()
+	slog.Info("Instance is now the leader", "instance_id", l.InstanceID)
+
+	l.mu.Lock()
+	l.leading = true
+	tasks := l.tasks
+	l.mu.Unlock()
+
+	taskCtx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	for _, task := range tasks {
+		wg.Add(1)
+		go func(task leaderTask) {
+			defer wg.Done()
+			slog.Info("Starting exclusive task", "task", task.name)
+			task.run(taskCtx)
+			slog.Info("Exclusive task stopped", "task", task.name)
+		}(task)
+	}
+
+	ticker := time.NewTicker(leaderCheckInterval)
+	defer ticker.Stop()
+	for healthy := true; healthy; {
+		select {
+		case <-ctx.Done():
+			healthy = false
+		case <-ticker.C:
+			if err := conn.PingContext(ctx); err != nil {
+				slog.Error("Instance lost the leader connection", "instance_id", l.InstanceID, "error", err)
+				healthy = false
+			}
+		}
+	}
+
+	cancel()
+	wg.Wait()
+
+	l.mu.Lock()
+	l.leading = false
+	l.mu.Unlock()
+
+	// Unlock explicitly so a follower does not wait for the connection to be recycled
+	conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, leaderLockKey)
+	slog.Info("Instance stepped down", "instance_id", l.InstanceID)
+}
@@ -1,49 +1,259 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
-	"os"
-	"strconv"
+	"html"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
+	"github.com/bbr/telestory-api-based/internal/logging"
+	"github.com/bbr/telestory-api-based/internal/metrics"
 	tele "gopkg.in/telebot.v3"
 )
 
+// LogCategory groups admin log events so they can be routed to different channels
+type LogCategory string
+
+const (
+	LogNewUser LogCategory = "new_user"
+	LogSearch  LogCategory = "search"
+	LogFailure LogCategory = "failure"
+	LogPayment LogCategory = "payment"
+	LogAdmin   LogCategory = "admin"
+	LogAlert   LogCategory = "alert"
+)
+
+const (
+	logQueueSize   = 1000
+	logMessageMax  = 4096 // Telegram message length limit
+	logBatchSep    = "\n\n〰〰〰\n\n"
+	logSendRetries = 3
+)
+
+type logEvent struct {
+	chatID int64
+	text   string
+}
+
+// LogService posts admin log events to Telegram channels in the background.
+// Events for the same chat are batched into one message, and sends are spaced per chat
+// to stay clear of flood limits.
 type LogService struct {
-	Bot         *tele.Bot
-	LogTargetID int64
+	Bot          *tele.Bot
+	LogTargetID  int64                 // default chat, zero disables categories without a route
+	Routes       map[LogCategory]int64 // per category overrides, zero disables the category
+	BatchWindow  time.Duration         // how long events are collected before sending
+	SendInterval time.Duration         // minimum time between messages to one chat
+
+	mu       sync.RWMutex
+	closed   bool
+	queue    chan logEvent
+	done     chan struct{}
+	lastSent map[int64]time.Time
 }
 
-func NewLogService(bot *tele.Bot) *LogService {
-	logChannelID := os.Getenv("LOG_CHANNEL_ID")
-	var targetID int64
-	if logChannelID != "" {
-		id, err := strconv.ParseInt(logChannelID, 10, 64)
-		if err == nil {
-			targetID = id
-		} else {
-			log.Printf("Warning: Invalid LOG_CHANNEL_ID format: %v", err)
-		}
+func NewLogService(bot *tele.Bot, logChannelID int64, routes map[string]int64) *LogService {
+	s := &LogService{
+		Bot:          bot,
+		LogTargetID:  logChannelID,
+		Routes:       make(map[LogCategory]int64, len(routes)),
+		BatchWindow:  2 * time.Second,
+		SendInterval: 3 * time.Second,
+		queue:        make(chan logEvent, logQueueSize),
+		done:         make(chan struct{}),
+		lastSent:     make(map[int64]time.Time),
+	}
+	for category, chatID := range routes {
+		s.Routes[LogCategory(category)] = chatID
+	}
+	return s
+}
+
+// Start runs the sender until Close
+func (s *LogService) Start() {
+	go s.run()
+}
+
+// Close stops accepting events and waits until queued ones are sent or ctx expires
+func (s *LogService) Close(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	close(s.queue)
+	s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("log channel not flushed: %v", ctx.Err())
 	}
+}
 
-	return &LogService{
-		Bot:         bot,
-		LogTargetID: targetID,
+// chatFor returns where category is posted, zero if it is disabled
+func (s *LogService) chatFor(category LogCategory) int64 {
+	if chatID, ok := s.Routes[category]; ok {
+		return chatID
 	}
+	return s.LogTargetID
 }
 
-func (s *LogService) SendLog(message string) {
-	if s.LogTargetID == 0 {
+// SendLog queues an HTML message without blocking; it is dropped when the queue is full
+func (s *LogService) SendLog(category LogCategory, message string) {
+	chatID := s.chatFor(category)
+	if chatID == 0 {
 		return // Logging not configured
 	}
 
-	target := &tele.Chat{ID: s.LogTargetID}
-	_, err := s.Bot.Send(target, message, &tele.SendOptions{ParseMode: tele.ModeHTML})
-	if err != nil {
-		log.Printf("Failed to send log to channel: %v", err)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.queue <- logEvent{chatID: chatID, text: message}:
+	default:
+		metrics.LogEventsDropped.With(string(category)).Inc()
+		slog.Warn("Log channel queue full, dropping event", "category", category)
+	}
+}
+
+func (s *LogService) run() {
+	defer close(s.done)
+
+	pending := make(map[int64][]string)
+	var flush <-chan time.Time
+	flushAll := func() {
+		for chatID, texts := range pending {
+			s.sendBatch(chatID, texts)
+		}
+		clear(pending)
+		flush = nil
+	}
+
+	for {
+		select {
+		case ev, ok := <-s.queue:
+			if !ok {
+				flushAll()
+				return
+			}
+			pending[ev.chatID] = append(pending[ev.chatID], ev.text)
+			if flush == nil {
+				flush = time.After(s.BatchWindow)
+			}
+		case <-flush:
+			flushAll()
+		}
+	}
+}
+
+// sendBatch joins texts into as few messages as the length limit allows
+func (s *LogService) sendBatch(chatID int64, texts []string) {
+	var batch strings.Builder
+	for _, text := range texts {
+		text = truncateHTML(text, logMessageMax)
+		if batch.Len() > 0 && batch.Len()+len(logBatchSep)+len(text) > logMessageMax {
+			s.send(chatID, batch.String())
+			batch.Reset()
+		}
+		if batch.Len() > 0 {
+			batch.WriteString(logBatchSep)
+		}
+		batch.WriteString(text)
+	}
+	if batch.Len() > 0 {
+		s.send(chatID, batch.String())
+	}
+}
+
+// truncateHTML cuts text to at most max bytes with an ellipsis. It never splits a tag, an entity
+// or a character, and closes the tags left open so Telegram still parses the message.
+func truncateHTML(text string, max int) string {
+	if len(text) <= max {
+		return text
+	}
+	const ellipsis = "…"
+
+	var open []string // names of the open tags, innermost last
+	closing := func(tags []string) string {
+		var b strings.Builder
+		for i := len(tags) - 1; i >= 0; i-- {
+			b.WriteString("</" + tags[i] + ">")
+		}
+		return b.String()
+	}
+
+	end := 0
+	for end < len(text) {
+		next, tags := end, open
+		switch text[end] {
+		case '<':
+			j := strings.IndexByte(text[end:], '>')
+			if j < 0 {
+				next = len(text) // a broken tag is never cut into
+				break
+			}
+			tag := text[end : end+j+1]
+			next = end + j + 1
+			switch {
+			case strings.HasPrefix(tag, "</"):
+				if len(open) > 0 {
+					tags = open[:len(open)-1]
+				}
+			case !strings.HasSuffix(tag, "/>"):
+				name, _, _ := strings.Cut(strings.Trim(tag, "<>"), " ")
+				tags = append(slices.Clip(open), name)
+			}
+		case '&':
+			if j := strings.IndexByte(text[end:], ';'); j > 0 && j <= 10 {
+				next = end + j + 1
+			} else {
+				next = end + 1
+			}
+		default:
+			_, size := utf8.DecodeRuneInString(text[end:])
+			next = end + size
+		}
+		if next+len(ellipsis)+len(closing(tags)) > max {
+			break
+		}
+		end, open = next, tags
+	}
+	return text[:end] + ellipsis + closing(open)
+}
+
+// send posts one message, waiting out the per chat interval and any flood wait
+func (s *LogService) send(chatID int64, text string) {
+	if wait := time.Until(s.lastSent[chatID].Add(s.SendInterval)); wait > 0 {
+		time.Sleep(wait)
+	}
+	defer func() { s.lastSent[chatID] = time.Now() }()
+
+	target := &tele.Chat{ID: chatID}
+	for attempt := 1; attempt <= logSendRetries; attempt++ {
+		_, err := s.Bot.Send(target, text, &tele.SendOptions{ParseMode: tele.ModeHTML, DisableWebPagePreview: true})
+		if err == nil {
+			return
+		}
+
+		var flood tele.FloodError
+		if errors.As(err, &flood) && attempt < logSendRetries {
+			slog.Warn("Log channel flood wait", "chat_id", chatID, "retry_after", flood.RetryAfter)
+			time.Sleep(time.Duration(flood.RetryAfter) * time.Second)
+			continue
+		}
+		slog.Warn("Failed to send log to channel", "chat_id", chatID, "error", err)
+		return
 	}
 }
 
+// FormatUserLog renders user fields for HTML messages; names are user supplied and escaped
 func FormatUserLog(user *tele.User) string {
 	premiumStr := "No"
 	if user.IsPremium {
@@ -53,20 +263,33 @@ func FormatUserLog(user *tele.User) string {
 	return fmt.Sprintf(
 		"<b>ID:</b> <code>%d</code>\n<b>First Name:</b> %s\n<b>Last Name:</b> %s\n<b>Username:</b> @%s\n<b>Language:</b> %s\n<b>Is Premium:</b> %s",
 		user.ID,
-		user.FirstName,
-		user.LastName,
-		user.Username,
-		user.LanguageCode,
+		html.EscapeString(user.FirstName),
+		html.EscapeString(user.LastName),
+		html.EscapeString(user.Username),
+		html.EscapeString(user.LanguageCode),
 		premiumStr,
 	)
 }
 
 func (s *LogService) LogNewUser(user *tele.User) {
 	msg := fmt.Sprintf("👤 <b>New User Started Bot</b>\n\n%s", FormatUserLog(user))
-	s.SendLog(msg)
+	s.SendLog(LogNewUser, msg)
 }
 
 func (s *LogService) LogSearchRequest(user *tele.User, input string) {
-	msg := fmt.Sprintf("🔍 <b>New Search Request</b>\n\n<b>Input:</b> <code>%s</code>\n\n%s", input, FormatUserLog(user))
-	s.SendLog(msg)
+	msg := fmt.Sprintf("🔍 <b>New Search Request</b>\n\n<b>Input:</b> <code>%s</code>\n\n%s", html.EscapeString(input), FormatUserLog(user))
+	s.SendLog(LogSearch, msg)
+}
+
+// LogFailure reports a search that failed with err
+func (s *LogService) LogFailure(user *tele.User, input string, err error) {
+	msg := fmt.Sprintf("❌ <b>Search Failed</b>\n\n<b>Input:</b> <code>%s</code>\n<b>Error:</b> <code>%s</code>\n\n%s",
+		html.EscapeString(input), html.EscapeString(logging.Redact(err.Error())), FormatUserLog(user))
+	s.SendLog(LogFailure, msg)
+}
+
+// LogAdminAction reports a change made with an admin command
+func (s *LogService) LogAdminAction(user *tele.User, action string) {
+	msg := fmt.Sprintf("🛠 <b>Admin Action</b>\n\n<code>%s</code>\n\n%s", html.EscapeString(action), FormatUserLog(user))
+	s.SendLog(LogAdmin, msg)
 }
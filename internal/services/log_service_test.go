@@ -0,0 +1,28 @@
+package services
+
+import "testing"
+
+func TestTruncateHTML(t *testing.T) {
+	for _, tc := range []struct {
+		name, text string
+		max        int
+		want       string
+	}{
+		{"fits", "<b>hi</b>", 9, "<b>hi</b>"},
+		{"plain text", "abcdefgh", 6, "abc…"},
+		{"closes open tags", "<b>abcdefgh</b>", 12, "<b>ab…</b>"},
+		{"closes nested tags", "<b><i>abcdefgh</i></b>", 20, "<b><i>abc…</i></b>"},
+		{"does not split an entity", "a&amp;bcdefgh", 8, "a…"},
+		{"does not split a tag", `ab<a href="https://example.com">x</a>`, 20, "ab…"},
+		{"does not split a character", "ééééé", 8, "éé…"},
+		{"keeps closed tags", "<b>a</b>cdefghijkl", 12, "<b>a</b>c…"},
+	} {
+		got := truncateHTML(tc.text, tc.max)
+		if got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+		if len(got) > tc.max {
+			t.Errorf("%s: %d bytes, limit %d", tc.name, len(got), tc.max)
+		}
+	}
+}
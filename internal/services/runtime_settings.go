@@ -0,0 +1,289 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/config"
+	"github.com/bbr/telestory-api-based/internal/repositories"
+	"github.com/lib/pq"
+)
+
+// RuntimeSettingsChannel is the NOTIFY channel fired by the runtime_settings trigger
+const RuntimeSettingsChannel = "runtime_settings"
+
+// Runtime setting keys
+const (
+	SettingMaintenance      = "maintenance"
+	SettingDownloadCooldown = "download_cooldown"
+	SettingDailyLimit       = "daily_limit"
+	SettingArchiveChannelID = "archive_channel_id"
+	SettingAlertFailureRate = "alert_failure_rate"
+	SettingAlertMinEvents   = "alert_min_events"
+	SettingAlertWindow      = "alert_window"
+)
+
+// runtimeSettingParsers validate the values accepted for each key
+var runtimeSettingParsers = map[string]func(string) error{
+	SettingMaintenance: func(v string) error {
+		_, err := strconv.ParseBool(v)
+		return err
+	},
+	SettingDownloadCooldown: func(v string) error {
+		d, err := time.ParseDuration(v)
+		if err == nil && d < 0 {
+			return fmt.Errorf("must not be negative")
+		}
+		return err
+	},
+	SettingDailyLimit: func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err == nil && n < 1 {
+			return fmt.Errorf("must be positive")
+		}
+		return err
+	},
+	SettingArchiveChannelID: func(v string) error {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err == nil && id == 0 {
+			return fmt.Errorf("must not be zero")
+		}
+		return err
+	},
+	SettingAlertFailureRate: func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err == nil && (n < 1 || n > 100) {
+			return fmt.Errorf("must be a percentage between 1 and 100")
+		}
+		return err
+	},
+	SettingAlertMinEvents: func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err == nil && n < 1 {
+			return fmt.Errorf("must be positive")
+		}
+		return err
+	},
+	SettingAlertWindow: func(v string) error {
+		d, err := time.ParseDuration(v)
+		if err == nil && d < time.Minute {
+			return fmt.Errorf("must be at least 1m")
+		}
+		return err
+	},
+}
+
+// RuntimeSettings serves configuration values that operators can override live.
+// Overrides are cached in memory and reloaded whenever the table changes on any instance.
+type RuntimeSettings struct {
+	Repo     *repositories.RuntimeSettingRepository
+	Defaults map[string]string // values from the startup configuration
+
+	mu        sync.RWMutex
+	overrides map[string]string
+	listener  *pq.Listener
+}
+
+func NewRuntimeSettings(repo *repositories.RuntimeSettingRepository, cfg *config.Config) *RuntimeSettings {
+	return &RuntimeSettings{
+		Repo: repo,
+		Defaults: map[string]string{
+			SettingMaintenance:      strconv.FormatBool(cfg.Maintenance),
+			SettingDownloadCooldown: cfg.DownloadCooldown.String(),
+			SettingDailyLimit:       strconv.Itoa(cfg.DailyLimit),
+			SettingArchiveChannelID: strconv.FormatInt(cfg.ArchiveChannelID, 10),
+			SettingAlertFailureRate: strconv.Itoa(cfg.AlertFailureRate),
+			SettingAlertMinEvents:   strconv.Itoa(cfg.AlertMinEvents),
+			SettingAlertWindow:      cfg.AlertWindow.String(),
+		},
+		overrides: make(map[string]string),
+	}
+}
+
+// Reload replaces the cached overrides with the table contents
+func (s *RuntimeSettings) Reload(ctx context.Context) error {
+	settings, err := s.Repo.List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load runtime settings: %v", err)
+	}
+
+	overrides := make(map[string]string, len(settings))
+	for _, setting := range settings {
+		parse, ok := runtimeSettingParsers[setting.Key]
+		if !ok {
+			slog.WarnContext(ctx, "Ignoring unknown runtime setting", "key", setting.Key)
+			continue
+		}
+		if err := parse(setting.Value); err != nil {
+			slog.WarnContext(ctx, "Ignoring invalid runtime setting", "key", setting.Key, "value", setting.Value, "error", err)
+			continue
+		}
+		overrides[setting.Key] = setting.Value
+	}
+
+	s.mu.Lock()
+	s.overrides = overrides
+	s.mu.Unlock()
+	return nil
+}
+
+// Listen loads the overrides and keeps them fresh through LISTEN/NOTIFY on a dedicated connection
+func (s *RuntimeSettings) Listen(databaseURL string) error {
+	ctx := context.Background()
+	if err := s.Reload(ctx); err != nil {
+		return err
+	}
+
+	listener := pq.NewListener(databaseURL, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			slog.Warn("Runtime settings listener error", "error", err)
+		}
+	})
+	if err := listener.Listen(RuntimeSettingsChannel); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to listen on %s: %v", RuntimeSettingsChannel, err)
+	}
+	s.listener = listener
+
+	go func() {
+		for {
+			select {
+			case n, ok := <-listener.Notify:
+				if !ok {
+					return // listener closed
+				}
+				// n is nil after a reconnect, when notifications may have been missed
+				if n != nil {
+					slog.Info("Runtime setting changed, reloading", "key", n.Extra)
+				}
+				if err := s.Reload(ctx); err != nil {
+					slog.Error("Error reloading runtime settings", "error", err)
+				}
+			case <-time.After(90 * time.Second):
+				go listener.Ping()
+			}
+		}
+	}()
+	return nil
+}
+
+// Close stops listening for changes
+func (s *RuntimeSettings) Close() error {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// Keys returns every known setting key, sorted
+func (s *RuntimeSettings) Keys() []string {
+	keys := make([]string, 0, len(runtimeSettingParsers))
+	for key := range runtimeSettingParsers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Get returns the effective value of key and whether it is overridden
+func (s *RuntimeSettings) Get(key string) (string, bool, error) {
+	if _, ok := runtimeSettingParsers[key]; !ok {
+		return "", false, fmt.Errorf("unknown setting %q", key)
+	}
+	s.mu.RLock()
+	value, overridden := s.overrides[key]
+	s.mu.RUnlock()
+	if !overridden {
+		value = s.Defaults[key]
+	}
+	return value, overridden, nil
+}
+
+// Validate checks a value for key without storing it
+func (s *RuntimeSettings) Validate(key, value string) error {
+	parse, ok := runtimeSettingParsers[key]
+	if !ok {
+		return fmt.Errorf("unknown setting %q", key)
+	}
+	if err := parse(value); err != nil {
+		return fmt.Errorf("invalid value for %s: %v", key, err)
+	}
+	return nil
+}
+
+// Set validates and stores an override; other instances pick it up through NOTIFY
+func (s *RuntimeSettings) Set(ctx context.Context, key, value string, updatedBy int64) error {
+	if err := s.Validate(key, value); err != nil {
+		return err
+	}
+	if err := s.Repo.Set(ctx, key, value, updatedBy); err != nil {
+		return fmt.Errorf("failed to save %s: %v", key, err)
+	}
+
+	s.mu.Lock()
+	s.overrides[key] = value
+	s.mu.Unlock()
+	return nil
+}
+
+// Reset removes an override so the startup configuration applies again
+func (s *RuntimeSettings) Reset(ctx context.Context, key string) error {
+	if _, ok := runtimeSettingParsers[key]; !ok {
+		return fmt.Errorf("unknown setting %q", key)
+	}
+	if err := s.Repo.Delete(ctx, key); err != nil {
+		return fmt.Errorf("failed to reset %s: %v", key, err)
+	}
+
+	s.mu.Lock()
+	delete(s.overrides, key)
+	s.mu.Unlock()
+	return nil
+}
+
+// value returns the effective value of a known key
+func (s *RuntimeSettings) value(key string) string {
+	value, _, _ := s.Get(key)
+	return value
+}
+
+func (s *RuntimeSettings) Maintenance() bool {
+	on, _ := strconv.ParseBool(s.value(SettingMaintenance))
+	return on
+}
+
+func (s *RuntimeSettings) DownloadCooldown() time.Duration {
+	d, _ := time.ParseDuration(s.value(SettingDownloadCooldown))
+	return d
+}
+
+func (s *RuntimeSettings) DailyLimit() int {
+	n, _ := strconv.Atoi(s.value(SettingDailyLimit))
+	return n
+}
+
+func (s *RuntimeSettings) ArchiveChannelID() int64 {
+	id, _ := strconv.ParseInt(s.value(SettingArchiveChannelID), 10, 64)
+	return id
+}
+
+// AlertFailureRate is the failure share, between 0 and 1, at which a signal alerts
+func (s *RuntimeSettings) AlertFailureRate() float64 {
+	n, _ := strconv.Atoi(s.value(SettingAlertFailureRate))
+	return float64(n) / 100
+}
+
+func (s *RuntimeSettings) AlertMinEvents() int {
+	n, _ := strconv.Atoi(s.value(SettingAlertMinEvents))
+	return n
+}
+
+func (s *RuntimeSettings) AlertWindow() time.Duration {
+	d, _ := time.ParseDuration(s.value(SettingAlertWindow))
+	return d
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	// rollupInterval is how often the rollup job catches up
+	rollupInterval = time.Hour
+	// rollupChunkDays bounds the days rolled up by one statement
+	rollupChunkDays = 31
+)
+
+// RebuildStats recomputes the rollups of the inclusive date range, in chunks.
+// Today is skipped since it is always read live. Returns the days written.
+func (s *AnalyticsService) RebuildStats(ctx context.Context, from, to time.Time) (int, error) {
+	total := 0
+	for start := from; !start.After(to); start = start.AddDate(0, 0, rollupChunkDays) {
+		end := start.AddDate(0, 0, rollupChunkDays-1)
+		if end.After(to) {
+			end = to
+		}
+		n, err := s.Repo.Rollup(ctx, start, end)
+		if err != nil {
+			return total, fmt.Errorf("failed to roll up %s to %s: %v", start.Format(time.DateOnly), end.Format(time.DateOnly), err)
+		}
+		total += int(n)
+	}
+	return total, nil
+}
+
+// RunRollups keeps daily_stats up to date until ctx is cancelled. The first run backfills every day
+// since the first user; later runs roll up the days since the latest rollup, recomputing that day
+// to pick up late writes. In cluster mode it runs on the leader only.
+func (s *AnalyticsService) RunRollups(ctx context.Context) {
+	ticker := time.NewTicker(rollupInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.catchUpRollups(ctx); err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "Error rolling up daily stats", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *AnalyticsService) catchUpRollups(ctx context.Context) error {
+	latest, first, err := s.Repo.RollupBounds(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read rollup bounds: %v", err)
+	}
+
+	from := latest
+	if from.IsZero() {
+		if first.IsZero() {
+			return nil // No users yet
+		}
+		from = first
+		slog.InfoContext(ctx, "Backfilling daily stats", "from", from.Format(time.DateOnly))
+	}
+
+	// The database clamps the range to yesterday in its own time zone
+	days, err := s.RebuildStats(ctx, from, time.Now())
+	if err != nil {
+		return err
+	}
+	slog.DebugContext(ctx, "Rolled up daily stats", "from", from.Format(time.DateOnly), "days", days)
+	return nil
+}
@@ -0,0 +1,258 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/i18n"
+	"github.com/bbr/telestory-api-based/internal/logging"
+	"github.com/bbr/telestory-api-based/internal/models"
+	tele "gopkg.in/telebot.v3"
+)
+
+// StoryPickerUnique is the callback unique of the story picker keyboard
+const StoryPickerUnique = "spick"
+
+// Story picker actions carried in StoryPickerPayload
+const (
+	PickerToggle   = "t"
+	PickerPage     = "p"
+	PickerAll      = "all"
+	PickerRange    = "r"
+	PickerDownload = "go"
+	PickerCancel   = "x"
+	PickerNoop     = "n"
+)
+
+// StoryPickerPayload is the callback payload of the story picker keyboard
+type StoryPickerPayload struct {
+	Token  string
+	Action string
+	Arg    string
+}
+
+func (p *StoryPickerPayload) Fields() []string {
+	return []string{p.Token, p.Action, p.Arg}
+}
+
+func (p *StoryPickerPayload) Parse(fields []string) error {
+	if len(fields) != 3 {
+		return fmt.Errorf("story picker payload: expected 3 fields, got %d", len(fields))
+	}
+	p.Token, p.Action, p.Arg = fields[0], fields[1], fields[2]
+	return nil
+}
+
+const (
+	pickerPageSize   = 8
+	pickerCaptionLen = 24
+)
+
+// showStoryPicker stores the stories newest first and edits msg into the first picker page
+func (s *DownloadService) showStoryPicker(ctx context.Context, bot *tele.Bot, msg *tele.Message, user *models.User, req StoryRequest, apiResp *TeleStoryResponse) error {
+	resp := *apiResp
+	resp.Stories = LatestStories(apiResp.Stories, 0)
+
+	sel := &pendingSelection{
+		user:        user,
+		req:         req,
+		resp:        &resp,
+		loc:         s.UserService.SettingsOrDefault(ctx, user.ID).Location(),
+		selected:    make(map[int]bool),
+		rangeAnchor: -1,
+	}
+	token, err := s.storePending(ctx, sel)
+	if err != nil {
+		return err
+	}
+
+	text, menu, err := s.renderStoryPicker(token, sel, "")
+	if err != nil {
+		s.takePending(ctx, token, user.ID)
+		s.ReleaseReservation(ctx, req)
+		return err
+	}
+	_, err = bot.Edit(msg, text, menu, tele.ModeMarkdown)
+	return err
+}
+
+// ProcessPickerAction applies a story picker button press and either re-renders or delivers
+func (s *DownloadService) ProcessPickerAction(ctx context.Context, bot *tele.Bot, msg *tele.Message, userID int64, token, action, arg string) error {
+	sel, err := s.getPending(ctx, token, userID)
+	if err != nil {
+		return err
+	}
+	ctx = logging.With(ctx, "target", sel.req.Input)
+	userLang := sel.user.LanguageCode
+
+	switch action {
+	case PickerAll:
+		if _, err := s.takePending(ctx, token, userID); err != nil {
+			return err
+		}
+		return s.deliverStories(ctx, bot, msg, sel.user, sel.req, sel.resp)
+
+	case PickerDownload:
+		s.mu.Lock()
+		chosen := make([]Story, 0, len(sel.selected))
+		for i, st := range sel.resp.Stories {
+			if sel.selected[i] {
+				chosen = append(chosen, st)
+			}
+		}
+		s.mu.Unlock()
+
+		if len(chosen) == 0 {
+			return s.renderPicker(ctx, bot, msg, token, sel, i18n.GetMessage(userLang, "picker_nothing_selected"))
+		}
+		if _, err := s.takePending(ctx, token, userID); err != nil {
+			return err
+		}
+		resp := *sel.resp
+		resp.Stories = chosen
+		return s.deliverStories(ctx, bot, msg, sel.user, sel.req, &resp)
+
+	case PickerCancel:
+		if _, err := s.takePending(ctx, token, userID); err == nil {
+			s.ReleaseReservation(ctx, sel.req)
+		}
+		return bot.Delete(msg)
+
+	case PickerNoop:
+		return nil
+	}
+
+	s.mu.Lock()
+	switch action {
+	case PickerToggle:
+		idx, err := strconv.Atoi(arg)
+		if err != nil || idx < 0 || idx >= len(sel.resp.Stories) {
+			s.mu.Unlock()
+			return nil
+		}
+		if sel.rangeMode {
+			if sel.rangeAnchor < 0 {
+				sel.rangeAnchor = idx
+			} else {
+				from, to := sel.rangeAnchor, idx
+				if from > to {
+					from, to = to, from
+				}
+				for i := from; i <= to; i++ {
+					sel.selected[i] = true
+				}
+				sel.rangeMode = false
+				sel.rangeAnchor = -1
+			}
+		} else if sel.selected[idx] {
+			delete(sel.selected, idx)
+		} else {
+			sel.selected[idx] = true
+		}
+	case PickerPage:
+		page, err := strconv.Atoi(arg)
+		if err == nil && page >= 0 && page < pickerPageCount(sel) {
+			sel.page = page
+		}
+	case PickerRange:
+		sel.rangeMode = !sel.rangeMode
+		sel.rangeAnchor = -1
+	}
+
+	hint := ""
+	if sel.rangeMode {
+		if sel.rangeAnchor < 0 {
+			hint = i18n.GetMessage(userLang, "picker_range_start")
+		} else {
+			hint = i18n.GetMessage(userLang, "picker_range_end")
+		}
+	}
+	s.mu.Unlock()
+
+	return s.renderPicker(ctx, bot, msg, token, sel, hint)
+}
+
+func (s *DownloadService) renderPicker(ctx context.Context, bot *tele.Bot, msg *tele.Message, token string, sel *pendingSelection, hint string) error {
+	s.mu.Lock()
+	text, menu, err := s.renderStoryPicker(token, sel, hint)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	_, err = bot.Edit(msg, text, menu, tele.ModeMarkdown)
+	if err == tele.ErrSameMessageContent {
+		return nil
+	}
+	return err
+}
+
+func pickerPageCount(sel *pendingSelection) int {
+	return (len(sel.resp.Stories) + pickerPageSize - 1) / pickerPageSize
+}
+
+// renderStoryPicker builds the picker text and keyboard for the current page
+func (s *DownloadService) renderStoryPicker(token string, sel *pendingSelection, hint string) (string, *tele.ReplyMarkup, error) {
+	userLang := sel.user.LanguageCode
+	stories := sel.resp.Stories
+	pages := pickerPageCount(sel)
+
+	text := fmt.Sprintf(i18n.GetMessage(userLang, "picker_title"), len(stories), sel.req.Input, len(sel.selected))
+	if hint != "" {
+		text += "\n\n" + hint
+	}
+
+	menu := &tele.ReplyMarkup{}
+	kb := s.Callbacks.Keyboard()
+	btn := func(label, action, arg string) tele.Btn {
+		return kb.Button(label, StoryPickerUnique, &StoryPickerPayload{Token: token, Action: action, Arg: arg})
+	}
+
+	var rows []tele.Row
+	start := sel.page * pickerPageSize
+	end := min(start+pickerPageSize, len(stories))
+	for i := start; i < end; i++ {
+		mark := "▫️"
+		if sel.selected[i] {
+			mark = "✅"
+		} else if sel.rangeAnchor == i {
+			mark = "📍"
+		}
+		label := fmt.Sprintf("%s %s %s", mark, time.Unix(stories[i].Date, 0).In(sel.loc).Format("2006-01-02 15:04"), captionSnippet(stories[i].Caption))
+		rows = append(rows, menu.Row(btn(label, PickerToggle, strconv.Itoa(i))))
+	}
+
+	if pages > 1 {
+		prev := btn("◀️", PickerPage, strconv.Itoa((sel.page+pages-1)%pages))
+		info := btn(fmt.Sprintf("%d/%d", sel.page+1, pages), PickerNoop, "")
+		next := btn("▶️", PickerPage, strconv.Itoa((sel.page+1)%pages))
+		rows = append(rows, menu.Row(prev, info, next))
+	}
+
+	rows = append(rows,
+		menu.Row(
+			btn(i18n.GetMessage(userLang, "picker_all"), PickerAll, ""),
+			btn(i18n.GetMessage(userLang, "picker_range"), PickerRange, ""),
+		),
+		menu.Row(
+			btn(fmt.Sprintf(i18n.GetMessage(userLang, "picker_download"), len(sel.selected)), PickerDownload, ""),
+			btn(i18n.GetMessage(userLang, "picker_cancel"), PickerCancel, ""),
+		),
+	)
+	menu.Inline(rows...)
+
+	return text, menu, kb.Err()
+}
+
+// captionSnippet shortens a caption to a single line that fits on a button
+func captionSnippet(caption string) string {
+	caption = strings.Join(strings.Fields(caption), " ")
+	runes := []rune(caption)
+	if len(runes) > pickerCaptionLen {
+		return string(runes[:pickerCaptionLen]) + "…"
+	}
+	return caption
+}
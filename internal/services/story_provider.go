@@ -0,0 +1,260 @@
+package services
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/metrics"
+	"github.com/bbr/telestory-api-based/internal/models"
+	tele "gopkg.in/telebot.v3"
+)
+
+// StoryMode selects which subset of a target's stories should be fetched
+type StoryMode string
+
+const (
+	StoryModeLatest  StoryMode = "latest"  // only the most recent active story
+	StoryModeActive  StoryMode = "active"  // stories that are currently visible
+	StoryModeArchive StoryMode = "archive" // active stories plus the pinned archive
+)
+
+// StoryRequest describes what the user asked to receive
+type StoryRequest struct {
+	Input   string    // username or phone number
+	StoryID int64     // non-zero when the user sent a link to a single story
+	Mode    StoryMode // empty means "ask the user"
+	ChatID  int64     // chat receiving the stories, zero means the user's private chat
+
+	// ReservationID is the quota slot taken by UserService.ReserveDownload, zero for admins
+	ReservationID int64
+}
+
+// Recipient returns where the stories of req are delivered
+func (r StoryRequest) Recipient(user *models.User) tele.Recipient {
+	if r.ChatID != 0 {
+		return &tele.Chat{ID: r.ChatID}
+	}
+	return &tele.User{ID: user.ID}
+}
+
+// StoryProvider fetches stories from an upstream source
+type StoryProvider interface {
+	FetchStories(ctx context.Context, req StoryRequest) (*TeleStoryResponse, error)
+}
+
+// TeleStoryResponse represents the API response structure
+type TeleStoryResponse struct {
+	OK      bool    `json:"ok"`
+	Stories []Story `json:"stories"`
+	BaseURL string  `json:"base_url"`
+	Success bool    `json:"success"`
+	Error   string  `json:"error"`
+}
+
+type Story struct {
+	ID      int64  `json:"id"`
+	URL     string `json:"url"`
+	Date    int64  `json:"date"`
+	Caption string `json:"caption"`
+}
+
+// TeleStoryProvider is the StoryProvider backed by the TeleStory HTTP API
+type TeleStoryProvider struct {
+	HTTPClient *http.Client
+	APIURL     string
+	APIKey     string
+
+	// Breaker fails fast while the API keeps timing out or returning 5xx
+	Breaker *CircuitBreaker
+}
+
+func NewTeleStoryProvider(client *http.Client, apiURL, apiKey string) *TeleStoryProvider {
+	return &TeleStoryProvider{
+		HTTPClient: client,
+		APIURL:     apiURL,
+		APIKey:     apiKey,
+		Breaker:    NewCircuitBreaker(5, 30*time.Second),
+	}
+}
+
+// FetchStories fetches the stories matching req.
+// Story IDs are looked up among active stories first so the archive is only requested when needed.
+func (p *TeleStoryProvider) FetchStories(ctx context.Context, req StoryRequest) (*TeleStoryResponse, error) {
+	if req.StoryID != 0 {
+		resp, err := p.fetch(ctx, req.Input, false)
+		if err != nil {
+			return nil, err
+		}
+		if filterStoryByID(resp, req.StoryID) {
+			return resp, nil
+		}
+
+		resp, err = p.fetch(ctx, req.Input, true)
+		if err != nil {
+			return nil, err
+		}
+		filterStoryByID(resp, req.StoryID)
+		return resp, nil
+	}
+
+	switch req.Mode {
+	case StoryModeArchive:
+		return p.fetch(ctx, req.Input, true)
+	case StoryModeLatest:
+		resp, err := p.fetch(ctx, req.Input, false)
+		if err != nil {
+			return nil, err
+		}
+		resp.Stories = LatestStories(resp.Stories, 1)
+		return resp, nil
+	default:
+		return p.fetch(ctx, req.Input, false)
+	}
+}
+
+func (p *TeleStoryProvider) fetch(ctx context.Context, input string, archive bool) (*TeleStoryResponse, error) {
+	if p.APIKey == "" || p.APIURL == "" {
+		return nil, fmt.Errorf("TELESTORY_API_KEY or TELESTORY_API_URL not set")
+	}
+
+	if !p.Breaker.Allow() {
+		metrics.APIRequests.With("circuit_open").Inc()
+		return nil, ErrCircuitOpen
+	}
+
+	// result is the error class reported to metrics
+	result := "ok"
+	start := time.Now()
+	defer func() {
+		metrics.APIRequests.With(result).Inc()
+		metrics.APIDuration.With(result).Observe(time.Since(start).Seconds())
+		slog.DebugContext(ctx, "TeleStory API request", "archive", archive, "result", result, "duration_ms", time.Since(start).Milliseconds())
+		// Only transport failures and server errors count against the upstream
+		switch result {
+		case "timeout", "network_error", "http_5xx":
+			p.Breaker.Record(false)
+		default:
+			p.Breaker.Record(true)
+		}
+	}()
+
+	// Clean input (remove @ for username or + for phone number)
+	cleanInput := strings.TrimPrefix(input, "@")
+	cleanInput = strings.TrimPrefix(cleanInput, "+")
+
+	// Build request URL
+	reqURL := fmt.Sprintf("%s/get_stories_by_username?api_key=%s&username=%s&archive=%t&mark=true",
+		p.APIURL, url.QueryEscape(p.APIKey), url.QueryEscape(cleanInput), archive)
+
+	// Create request
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	// Set headers
+	req.Header.Set("User-Agent", "TeleStory Android Client v1.43Build: 79, Patch: 20250820")
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	// Execute request
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		result = "network_error"
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			result = "timeout"
+		}
+		return nil, fmt.Errorf("failed to execute request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		result = fmt.Sprintf("http_%dxx", resp.StatusCode/100)
+	}
+
+	// Handle gzip response
+	var reader io.ReadCloser
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		reader, err = gzip.NewReader(resp.Body)
+		if err != nil {
+			result = "decode_error"
+			return nil, fmt.Errorf("failed to create gzip reader: %v", err)
+		}
+		defer reader.Close()
+	} else {
+		reader = resp.Body
+	}
+
+	// Parse JSON response
+	var apiResp TeleStoryResponse
+	if err := json.NewDecoder(reader).Decode(&apiResp); err != nil {
+		if result == "ok" {
+			result = "decode_error"
+		}
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+	if result == "ok" && apiResp.Error != "" {
+		result = "api_error"
+	}
+
+	return &apiResp, nil
+}
+
+// filterStoryByID keeps only the story with the given ID and reports whether it was found
+func filterStoryByID(resp *TeleStoryResponse, storyID int64) bool {
+	for _, st := range resp.Stories {
+		if st.ID == storyID {
+			resp.Stories = []Story{st}
+			return true
+		}
+	}
+	resp.Stories = nil
+	return false
+}
+
+// LatestStories returns up to n stories, newest first
+func LatestStories(stories []Story, n int) []Story {
+	sorted := make([]Story, len(stories))
+	copy(sorted, stories)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Date > sorted[j].Date })
+	if n > 0 && len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
+// NormalizeTarget strips the @ or + prefix and lowercases a username or phone number
+func NormalizeTarget(input string) string {
+	target := strings.TrimSpace(input)
+	target = strings.TrimPrefix(target, "@")
+	target = strings.TrimPrefix(target, "+")
+	return strings.ToLower(target)
+}
+
+// storyLinkRx matches links such as https://t.me/username/s/123
+var storyLinkRx = regexp.MustCompile(`^(?:https?://)?(?:www\.)?t(?:elegram)?\.me/([A-Za-z0-9_]{3,32})/s/(\d+)/?$`)
+
+// ParseStoryLink extracts the username and story ID from a Telegram story link
+func ParseStoryLink(input string) (string, int64, bool) {
+	match := storyLinkRx.FindStringSubmatch(strings.TrimSpace(input))
+	if match == nil {
+		return "", 0, false
+	}
+	storyID, err := strconv.ParseInt(match[2], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return match[1], storyID, true
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/bbr/telestory-api-based/internal/models"
+	"github.com/bbr/telestory-api-based/internal/repositories"
+)
+
+// UserRoles are the roles an admin can assign
+var UserRoles = []string{"user", "admin"}
+
+// UserUpdate is a partial change of a user by an admin; nil fields are left as they are
+type UserUpdate struct {
+	Role *string
+	// PremiumExpiresAt sets bot premium; a zero time removes it
+	PremiumExpiresAt *time.Time
+}
+
+// SearchUsers returns a page of matching users and the number of all matches
+func (s *UserService) SearchUsers(ctx context.Context, filter repositories.UserFilter, limit, offset int) ([]models.User, int, error) {
+	total, err := s.UserRepo.CountSearch(ctx, filter)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count users: %v", err)
+	}
+	users, err := s.UserRepo.Search(ctx, filter, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search users: %v", err)
+	}
+	return users, total, nil
+}
+
+func (s *UserService) GetUser(ctx context.Context, id int64) (*models.User, error) {
+	return s.UserRepo.GetByID(ctx, id)
+}
+
+// ValidateUserUpdate checks an update before anything is written
+func ValidateUserUpdate(update UserUpdate) error {
+	if update.Role == nil && update.PremiumExpiresAt == nil {
+		return fmt.Errorf("nothing to update, expected role or premium_expires_at")
+	}
+	if update.Role != nil && !slices.Contains(UserRoles, *update.Role) {
+		return fmt.Errorf("unknown role %q, expected one of %v", *update.Role, UserRoles)
+	}
+	return nil
+}
+
+// UpdateUser applies an admin update; sql.ErrNoRows if the user does not exist
+func (s *UserService) UpdateUser(ctx context.Context, id int64, update UserUpdate) (*models.User, error) {
+	if err := ValidateUserUpdate(update); err != nil {
+		return nil, err
+	}
+
+	if update.Role != nil {
+		found, err := s.UserRepo.UpdateRole(ctx, id, *update.Role)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update role: %v", err)
+		}
+		if !found {
+			return nil, sql.ErrNoRows
+		}
+	}
+	if update.PremiumExpiresAt != nil {
+		expiresAt := sql.NullTime{Time: *update.PremiumExpiresAt, Valid: !update.PremiumExpiresAt.IsZero()}
+		found, err := s.UserRepo.UpdatePremium(ctx, id, expiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update premium: %v", err)
+		}
+		if !found {
+			return nil, sql.ErrNoRows
+		}
+	}
+	return s.UserRepo.GetByID(ctx, id)
+}
@@ -1,11 +1,16 @@
 package services
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
-	"os"
+	"log/slog"
+	"math"
 	"time"
 
 	"github.com/bbr/telestory-api-based/internal/i18n"
+	"github.com/bbr/telestory-api-based/internal/metrics"
 	"github.com/bbr/telestory-api-based/internal/models"
 	"github.com/bbr/telestory-api-based/internal/repositories"
 	tele "gopkg.in/telebot.v3"
@@ -14,18 +19,20 @@ import (
 type UserService struct {
 	UserRepo     *repositories.UserRepository
 	DownloadRepo *repositories.DownloadRepository
+	Runtime      *RuntimeSettings
 }
 
-func NewUserService(userRepo *repositories.UserRepository, downloadRepo *repositories.DownloadRepository) *UserService {
+func NewUserService(userRepo *repositories.UserRepository, downloadRepo *repositories.DownloadRepository, runtime *RuntimeSettings) *UserService {
 	return &UserService{
 		UserRepo:     userRepo,
 		DownloadRepo: downloadRepo,
+		Runtime:      runtime,
 	}
 }
 
-func (s *UserService) RegisterUser(teleUser *tele.User) (*models.User, error) {
+func (s *UserService) RegisterUser(ctx context.Context, teleUser *tele.User) (*models.User, error) {
 	// 1. Check if user already exists
-	existingUser, err := s.UserRepo.GetByID(teleUser.ID)
+	existingUser, err := s.UserRepo.GetByID(ctx, teleUser.ID)
 	if err == nil {
 		// User exists, return it without modifying
 		return existingUser, nil
@@ -42,63 +49,108 @@ func (s *UserService) RegisterUser(teleUser *tele.User) (*models.User, error) {
 		IsTelegramPremium: teleUser.IsPremium,
 	}
 
-	if err := s.UserRepo.Insert(user); err != nil {
+	if err := s.UserRepo.Insert(ctx, user); err != nil {
 		return nil, err
 	}
 
-	return s.UserRepo.GetByID(user.ID)
+	return s.UserRepo.GetByID(ctx, user.ID)
 }
 
-func (s *UserService) CanDownload(user *models.User) (bool, string, error) {
-	// 0. Admins bypass all limits
+// ReserveDownload checks the cooldown and daily limit and reserves a quota slot in one atomic step.
+// It returns the reservation ID, or a localized message when the request is refused.
+// Admins bypass all limits and get no reservation. The DownloadService turns the reservation
+// into a download record or releases it.
+func (s *UserService) ReserveDownload(ctx context.Context, user *models.User) (int64, string, error) {
 	if user.Role == "admin" {
-		return true, "", nil
+		return 0, "", s.RecordActivity(ctx, user.ID)
 	}
 
-	// Get environment-based limits
-	env := os.Getenv("APP_ENV")
-
-	var cooldownDuration time.Duration
-	var dailyLimit int
-
-	if env == "production" {
-		cooldownDuration = 1 * time.Minute
-		dailyLimit = 3
-	} else {
-		// Development/local environment
-		cooldownDuration = 10 * time.Second
-		dailyLimit = 100
+	// Premium users only have the cooldown
+	dailyLimit := s.Runtime.DailyLimit()
+	if user.IsBotPremium() {
+		dailyLimit = 0
 	}
 
-	// 1. Check cooldown
-	if user.LastActiveAt.Valid && time.Since(user.LastActiveAt.Time) < cooldownDuration {
-		remainingTime := cooldownDuration - time.Since(user.LastActiveAt.Time)
-		msg := fmt.Sprintf(i18n.GetMessage(user.LanguageCode, "cooldown"), int(remainingTime.Seconds()))
-		return false, msg, nil
+	result, err := s.DownloadRepo.Reserve(ctx, user.ID, s.Runtime.DownloadCooldown(), dailyLimit)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to reserve download: %v", err)
+	}
+	if result.RetryAfter > 0 {
+		metrics.QuotaRejections.With("cooldown").Inc()
+		msg := fmt.Sprintf(i18n.GetMessage(user.LanguageCode, "cooldown"), int(math.Ceil(result.RetryAfter.Seconds())))
+		return 0, msg, nil
+	}
+	if result.ReservationID == 0 {
+		metrics.QuotaRejections.With("daily_limit").Inc()
+		msg := fmt.Sprintf(i18n.GetMessage(user.LanguageCode, "error_limit"), result.Used, dailyLimit)
+		return 0, msg, nil
 	}
+	return result.ReservationID, "", nil
+}
 
-	// 2. Check Daily Limit (if not premium)
-	if !user.IsBotPremium() {
-		count, err := s.DownloadRepo.CountToday(user.ID)
-		if err != nil {
-			return false, "", err
-		}
-		if count >= dailyLimit {
-			msg := fmt.Sprintf(i18n.GetMessage(user.LanguageCode, "error_limit"), count, dailyLimit)
-			return false, msg, nil
-		}
+// CanUseInline checks only the daily limit, since inline results come from the archive
+// and inline queries arrive on every keystroke
+func (s *UserService) CanUseInline(ctx context.Context, user *models.User) (bool, string, error) {
+	if user.Role == "admin" || user.IsBotPremium() {
+		return true, "", nil
 	}
 
+	dailyLimit := s.Runtime.DailyLimit()
+	count, err := s.DownloadRepo.CountToday(ctx, user.ID)
+	if err != nil {
+		return false, "", err
+	}
+	if count >= dailyLimit {
+		metrics.QuotaRejections.With("inline_daily_limit").Inc()
+		msg := fmt.Sprintf(i18n.GetMessage(user.LanguageCode, "inline_limit"), count, dailyLimit)
+		return false, msg, nil
+	}
 	return true, "", nil
 }
 
-func (s *UserService) RecordActivity(userID int64) error {
-	return s.UserRepo.UpdateActivity(userID)
+func (s *UserService) RecordActivity(ctx context.Context, userID int64) error {
+	return s.UserRepo.UpdateActivity(ctx, userID)
 }
 
-func (s *UserService) UpdateLanguage(userID int64, langCode string) error {
+func (s *UserService) UpdateLanguage(ctx context.Context, userID int64, langCode string) error {
 	// Simple update query via repo (we need to add this to repo too)
 	// For now, let's just reuse Upsert but that's heavy.
 	// Better to add UpdateLanguage to Repo.
-	return s.UserRepo.UpdateLanguage(userID, langCode)
+	return s.UserRepo.UpdateLanguage(ctx, userID, langCode)
+}
+
+// GetSettings returns the user's settings, or the defaults if they were never changed
+func (s *UserService) GetSettings(ctx context.Context, userID int64) (*models.UserSettings, error) {
+	settings, err := s.UserRepo.GetSettings(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.DefaultUserSettings(userID), nil
+	}
+	return settings, err
+}
+
+// SettingsOrDefault is GetSettings for callers that should keep working when the lookup fails
+func (s *UserService) SettingsOrDefault(ctx context.Context, userID int64) *models.UserSettings {
+	settings, err := s.GetSettings(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error loading user settings", "error", err)
+		return models.DefaultUserSettings(userID)
+	}
+	return settings
+}
+
+func (s *UserService) UpdateSettings(ctx context.Context, settings *models.UserSettings) error {
+	switch settings.DeliveryStyle {
+	case models.DeliveryStyleIndividual, models.DeliveryStyleAlbum:
+	default:
+		return fmt.Errorf("unknown delivery style %q", settings.DeliveryStyle)
+	}
+	switch settings.CaptionFormat {
+	case models.CaptionFull, models.CaptionDate, models.CaptionNone:
+	default:
+		return fmt.Errorf("unknown caption format %q", settings.CaptionFormat)
+	}
+	if _, err := time.LoadLocation(settings.Timezone); err != nil {
+		return fmt.Errorf("unknown timezone %q: %v", settings.Timezone, err)
+	}
+	return s.UserRepo.UpsertSettings(ctx, settings)
 }
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS downloads;
+DROP TABLE IF EXISTS users;
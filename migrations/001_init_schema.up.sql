@@ -21,5 +21,5 @@ CREATE TABLE IF NOT EXISTS downloads (
     created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 
-CREATE INDEX idx_downloads_user_id ON downloads(user_id);
-CREATE INDEX idx_downloads_created_at ON downloads(created_at);
+CREATE INDEX IF NOT EXISTS idx_downloads_user_id ON downloads(user_id);
+CREATE INDEX IF NOT EXISTS idx_downloads_created_at ON downloads(created_at);
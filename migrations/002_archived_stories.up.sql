@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS archived_stories (
+    id SERIAL PRIMARY KEY,
+    target TEXT NOT NULL, -- Normalized username or phone number (no @ or +, lowercase)
+    story_id BIGINT DEFAULT 0,
+    media_type TEXT NOT NULL, -- photo, video
+    file_id TEXT NOT NULL, -- Telegram file_id from the archive channel message
+    caption TEXT DEFAULT '',
+    story_date TIMESTAMP WITH TIME ZONE NOT NULL,
+    archive_message_id BIGINT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_archived_stories_unique ON archived_stories(target, story_date, media_type);
+CREATE INDEX IF NOT EXISTS idx_archived_stories_target ON archived_stories(target, story_date DESC);
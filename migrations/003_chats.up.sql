@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS chats (
+    id BIGINT PRIMARY KEY, -- Telegram Chat ID
+    title TEXT DEFAULT '',
+    type TEXT NOT NULL, -- group, supergroup
+    delivery TEXT DEFAULT 'group', -- group: post results in the chat, private: send to the requesting member
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS user_settings (
+    user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    delivery_style TEXT DEFAULT 'individual', -- individual, album
+    caption_format TEXT DEFAULT 'full', -- full, date, none
+    include_archive BOOLEAN DEFAULT TRUE,
+    timezone TEXT DEFAULT 'UTC', -- IANA name used to format story dates
+    notifications BOOLEAN DEFAULT TRUE, -- FALSE delivers stories silently
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
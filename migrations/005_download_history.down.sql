@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS download_stories;
+ALTER TABLE downloads DROP COLUMN IF EXISTS hidden_at;
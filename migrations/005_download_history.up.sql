@@ -0,0 +1,9 @@
+-- Hidden history entries still count towards the daily limit
+ALTER TABLE downloads ADD COLUMN IF NOT EXISTS hidden_at TIMESTAMP WITH TIME ZONE;
+
+CREATE TABLE IF NOT EXISTS download_stories (
+    download_id INTEGER REFERENCES downloads(id) ON DELETE CASCADE,
+    archived_story_id INTEGER REFERENCES archived_stories(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (download_id, archived_story_id)
+);
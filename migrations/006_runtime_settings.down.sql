@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS runtime_settings_notify ON runtime_settings;
+DROP FUNCTION IF EXISTS notify_runtime_settings();
+DROP TABLE IF EXISTS runtime_settings;
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS runtime_settings (
+    key TEXT PRIMARY KEY,
+    value TEXT NOT NULL,
+    updated_by BIGINT, -- admin who changed it, NULL when set from SQL
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Every change is broadcast so all instances refresh their cache
+CREATE OR REPLACE FUNCTION notify_runtime_settings() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('runtime_settings', COALESCE(NEW.key, OLD.key));
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS runtime_settings_notify ON runtime_settings;
+CREATE TRIGGER runtime_settings_notify
+    AFTER INSERT OR UPDATE OR DELETE ON runtime_settings
+    FOR EACH ROW EXECUTE FUNCTION notify_runtime_settings();
@@ -0,0 +1,16 @@
+-- Story deliveries queued by the instance receiving updates and run by any instance
+CREATE TABLE IF NOT EXISTS delivery_jobs (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    payload JSONB NOT NULL,
+    status TEXT NOT NULL DEFAULT 'queued', -- queued, running, done, failed
+    attempts INT NOT NULL DEFAULT 0,
+    locked_by TEXT, -- instance running the job
+    locked_at TIMESTAMP WITH TIME ZONE, -- refreshed while running, stale means the instance died
+    last_error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_delivery_jobs_queued ON delivery_jobs(id) WHERE status = 'queued';
+CREATE INDEX IF NOT EXISTS idx_delivery_jobs_running ON delivery_jobs(locked_at) WHERE status = 'running';
@@ -0,0 +1,9 @@
+-- Quota slots taken when a request is accepted and removed when it completes or fails,
+-- so concurrent requests cannot all pass the daily limit check
+CREATE TABLE IF NOT EXISTS download_reservations (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_download_reservations_user ON download_reservations(user_id, created_at);
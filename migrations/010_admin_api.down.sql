@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS broadcasts;
+DROP TABLE IF EXISTS admin_api_keys;
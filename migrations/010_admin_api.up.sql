@@ -0,0 +1,27 @@
+-- Keys of the admin REST API; only the SHA-256 of a key is stored, the key is shown once
+CREATE TABLE IF NOT EXISTS admin_api_keys (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL, -- first characters of the key, to tell keys apart
+    key_hash TEXT NOT NULL UNIQUE,
+    created_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Messages sent to every user, or to the users of one language
+CREATE TABLE IF NOT EXISTS broadcasts (
+    id SERIAL PRIMARY KEY,
+    message TEXT NOT NULL,
+    language_code TEXT, -- NULL for all users
+    parse_mode TEXT NOT NULL DEFAULT '', -- empty for plain text
+    status TEXT NOT NULL DEFAULT 'pending', -- pending, running, done, interrupted
+    total INTEGER NOT NULL DEFAULT 0,
+    sent INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    created_by TEXT NOT NULL DEFAULT '', -- API key name
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(), -- heartbeat of the sending instance
+    finished_at TIMESTAMP WITH TIME ZONE
+);
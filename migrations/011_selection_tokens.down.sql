@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_download_reservations_selection;
+ALTER TABLE download_reservations DROP COLUMN IF EXISTS selection_token;
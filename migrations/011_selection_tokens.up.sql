@@ -0,0 +1,5 @@
+-- The keyboard token of a reservation waiting for the user's choice. Selections live in the memory
+-- of one instance, so after a failover the new leader releases the slot by this token.
+ALTER TABLE download_reservations ADD COLUMN IF NOT EXISTS selection_token TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_download_reservations_selection ON download_reservations(selection_token) WHERE selection_token IS NOT NULL;